@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+var appendOutputs bool
+
+// appendTarget is an earlier split that --append adds rows to
+type appendTarget struct {
+	// Buckets is every output's size and row count from the earlier split's manifest, the load packing starts from
+	Buckets []FileBucket
+	// Stats is every output's bytes and content hash before appending, which the writers carry on from
+	Stats []BucketStats
+	// Header is the outputs' header rows, which the input must share
+	Header [][]string
+	// Ranges is every output's line ranges from the earlier manifest, which the appended rows' ranges are added to
+	Ranges [][][2]int
+	// InputRows is how many data rows the earlier inputs held, the appended rows are numbered after them
+	InputRows int
+}
+
+// checkAppend rejects flags that don't apply to adding rows to files that already exist
+func checkAppend(prefix string, sizeCap int64) error {
+	switch {
+	case isS3Prefix(prefix):
+		return fmt.Errorf("--append only supports local output paths")
+	case archiveOutputs:
+		return fmt.Errorf("--append cannot be combined with --archive")
+	case compressOutputs:
+		return fmt.Errorf("--append cannot be combined with --compress")
+	case checkpointSplit || resumeSplit:
+		return fmt.Errorf("--append cannot be combined with --checkpoint or --resume")
+	case sizeCap > 0:
+		return fmt.Errorf("--append cannot be combined with %s, the bucket count is the earlier split's", capFlag)
+	case sortOutputBy != "none":
+		return fmt.Errorf("--append cannot be combined with --sort-output-by, the outputs keep their numbers")
+	case packOpts.MaxCountSpread > 0:
+		return fmt.Errorf("--append cannot be combined with --max-count-spread")
+	case scanOpts.Size.Relative:
+		return fmt.Errorf("--append cannot be combined with --size-mode relative, the new weights don't add up with the old")
+	case writeOpts.Preallocate:
+		return fmt.Errorf("--append cannot be combined with --preallocate")
+	case overwriteOutputs:
+		return fmt.Errorf("--append cannot be combined with --overwrite, it adds to the outputs rather than replacing them")
+	}
+	return nil
+}
+
+// existingSplit reads the outputs of the split at prefix and its manifest, which must describe exactly bucketsN outputs as they are on disk now
+func existingSplit(prefix string, bucketsN int, contentHash bool) (*appendTarget, error) {
+	paths, err := discoverBucketFiles(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("--append: %w", err)
+	}
+	if len(paths) != bucketsN {
+		return nil, fmt.Errorf("--append: found %d outputs at %s, but asked for %d buckets", len(paths), prefix, bucketsN)
+	}
+	doc, err := loadManifest(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("--append needs %s%s from the earlier split to know how full each output is", prefix, manifestName)
+	}
+	manifest := doc.byBucket()
+
+	t := &appendTarget{Buckets: make([]FileBucket, bucketsN), Stats: make([]BucketStats, bucketsN), Ranges: make([][][2]int, bucketsN), InputRows: doc.InputRows}
+	for i, path := range paths {
+		if path != outputPath(prefix, i) {
+			return nil, fmt.Errorf("--append: found %s where bucket %d should be %s, pass the --name-template and --no-pad-index of the earlier split", path, i+1, outputPath(prefix, i))
+		}
+		entry, ok := manifest[i+1]
+		if !ok {
+			return nil, fmt.Errorf("--append: %s%s has no entry for %s", prefix, manifestName, path)
+		}
+		stat, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if stat.Size() != entry.WrittenBytes {
+			return nil, fmt.Errorf("--append: %s has %d bytes but the manifest says %d, it changed after the split", path, stat.Size(), entry.WrittenBytes)
+		}
+		t.Buckets[i] = FileBucket{TotalSize: entry.TotalSize, Lines: entry.Lines}
+		t.Ranges[i] = entry.LineRanges
+		if doc.InputRows == 0 {
+			// a manifest from before input_rows was recorded: the last row in any output is the closest count of the earlier rows there is
+			for _, r := range entry.LineRanges {
+				t.InputRows = max(t.InputRows, r[1])
+			}
+		}
+		t.Stats[i].WrittenBytes = stat.Size()
+		if contentHash {
+			if entry.ContentHash == "" {
+				return nil, fmt.Errorf("--append: %s has no content hash to add to, the earlier split ran without --content-hash", path)
+			}
+			if t.Stats[i].ContentHash, err = strconv.ParseUint(entry.ContentHash, 16, 64); err != nil {
+				return nil, fmt.Errorf("--append: content hash of %s: %w", path, err)
+			}
+		}
+	}
+	if hasHeader(scanOpts.Format) {
+		if t.Header, err = readHeader(paths[0], headerCount(scanOpts.Format)); err != nil {
+			return nil, err
+		}
+	}
+	logInfo("append", "adding to %d outputs at %s holding %s rows", bucketsN, prefix, FormatNumber(int64(totalLines(t.Buckets))))
+	return t, nil
+}
+
+// readHeader reads the first rows records of an output
+func readHeader(path string, rows int) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	in, err := inputReader(f, path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newRecordReader(outputFormat(scanOpts.Format), bufio.NewReader(in), SizeSpec{})
+	if err != nil {
+		return nil, err
+	}
+	var header [][]string
+	for range rows {
+		record, _, err := r.Read()
+		if err != nil && !errors.Is(err, ErrBadSize) {
+			return nil, fmt.Errorf("%s: reading header: %w", path, err)
+		}
+		header = append(header, record)
+	}
+	return header, nil
+}
+
+func totalLines(buckets []FileBucket) int {
+	n := 0
+	for _, b := range buckets {
+		n += b.Lines
+	}
+	return n
+}
+
+// appendOutput opens an existing output for appending, after checking it still has the size it had when the split was read
+func appendOutput(path string, size int64) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if stat.Size() != size {
+		f.Close()
+		return nil, fmt.Errorf("%s changed from %d to %d bytes while appending", path, size, stat.Size())
+	}
+	return f, nil
+}
+
+// rollback cuts every output back to the size it had before appending, for an interrupted run
+func (t *appendTarget) rollback(files []io.WriteCloser, prefix string) {
+	for i, file := range files {
+		f := file.(interface {
+			io.Closer
+			Truncate(size int64) error
+		})
+		if err := f.Truncate(t.Stats[i].WrittenBytes); err != nil {
+			logInfo("write", "could not cut %s back to %d bytes: %v", outputPath(prefix, i), t.Stats[i].WrittenBytes, err)
+		}
+		f.Close()
+	}
+	logInfo("write", "cut %d outputs back to their size before appending", len(files))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var archiveOutputs bool
+
+// outputArchive collects the outputs of an --archive split, nil otherwise
+var outputArchive *tarArchive
+
+// tarArchive writes every output of a split as a member of <prefix>.tar. A tar header needs the member's size up front and the archive is written one member at a time, so each bucket is spooled to a temp file and copied in when it is closed
+type tarArchive struct {
+	path    string
+	mu      sync.Mutex
+	f       *os.File
+	tw      *tar.Writer
+	members int
+}
+
+func archivePath(prefix string) string {
+	return prefix + ".tar"
+}
+
+// checkArchive rejects flags that need the outputs as files of their own
+func checkArchive(prefix string) error {
+	switch {
+	case isS3Prefix(prefix):
+		return fmt.Errorf("--archive only supports local output paths")
+	case writeOpts.AfterWriteHook != "":
+		return fmt.Errorf("--archive cannot be combined with --after-write-hook, the outputs aren't files of their own")
+	case checkOutputs:
+		return fmt.Errorf("--archive cannot be combined with --check-outputs")
+	case checkpointSplit || resumeSplit:
+		return fmt.Errorf("--archive cannot be combined with --checkpoint or --resume, a finished member can't be reopened")
+	}
+	return nil
+}
+
+// openArchive creates <prefix>.tar, removed again if the run is interrupted or fails before finish
+func openArchive(prefix string) (*tarArchive, error) {
+	path := archivePath(prefix)
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	a := &tarArchive{path: path, f: f, tw: tar.NewWriter(f)}
+	atExit(func() {
+		if a.f != nil {
+			a.f.Close()
+			os.Remove(a.path)
+		}
+	})
+	return a, nil
+}
+
+// member starts a member called name, spooled to a temp file until it is closed
+func (a *tarArchive) member(name string) (io.WriteCloser, error) {
+	spool, err := os.CreateTemp(spillDir, "binpacking-member-*")
+	if err != nil {
+		return nil, err
+	}
+	atExit(func() { os.Remove(spool.Name()) })
+	return &archiveMember{archive: a, name: name, spool: spool}, nil
+}
+
+// finish ends the archive once the last member is in
+func (a *tarArchive) finish() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	err := a.f.Close()
+	a.f = nil
+	if err == nil {
+		logInfo("write", "wrote archive %s with %d members", a.path, a.members)
+	}
+	return err
+}
+
+// archiveMember is one output being spooled for the archive
+type archiveMember struct {
+	archive *tarArchive
+	name    string
+	spool   *os.File
+}
+
+func (m *archiveMember) Write(p []byte) (int, error) {
+	return m.spool.Write(p)
+}
+
+// Close copies the spooled member into the archive
+func (m *archiveMember) Close() error {
+	defer os.Remove(m.spool.Name())
+	defer m.spool.Close()
+	size, err := m.spool.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := m.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	a := m.archive
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	header := &tar.Header{Typeflag: tar.TypeReg, Name: m.name, Mode: 0644, Size: size, ModTime: time.Now()}
+	if err := a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := io.Copy(a.tw, m.spool); err != nil {
+		return fmt.Errorf("%s: adding %s: %w", a.path, m.name, err)
+	}
+	a.members++
+	return nil
+}
+
+// Abort drops the member without adding it
+func (m *archiveMember) Abort() error {
+	m.spool.Close()
+	return os.Remove(m.spool.Name())
+}
+
+// archiveMemberName is a file's name inside the archive: its base name, so extracting the archive next to it puts the outputs where the manifest says they are
+func archiveMemberName(path string) string {
+	return filepath.Base(path)
+}
@@ -0,0 +1,398 @@
+// Package binpack balances rows across a fixed number of buckets by total size, the packing core of the binpacking CLI
+package binpack
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"iter"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+)
+
+// LineMeta is one data row as seen by the packer: its 1-based line number among the data rows, its size and its group
+type LineMeta struct {
+	LineNumber int
+	Size       int64
+	Group      int // id of the row's group when grouping by a column, 0 otherwise
+}
+
+// FileBucket is one output of the packing, its total size and how many rows it holds
+type FileBucket struct {
+	TotalSize int64
+	Lines     int
+}
+
+// Assignment maps each line number to the bucket its row was packed into, stored as bucket index + 1 so that 0 marks a line no bucket holds. It takes 4 bytes per line, where per-bucket sets of line numbers took tens
+type Assignment []uint32
+
+// Bucket returns the index of the bucket holding line, and false when no bucket does
+func (a Assignment) Bucket(line int) (int, bool) {
+	if line < 0 || line >= len(a) || a[line] == 0 {
+		return 0, false
+	}
+	return int(a[line]) - 1, true
+}
+
+// BucketLines returns every bucket's line numbers in ascending order
+func (a Assignment) BucketLines(n int) [][]int {
+	lines := make([][]int, n)
+	for line, b := range a {
+		if b != 0 {
+			lines[b-1] = append(lines[b-1], line)
+		}
+	}
+	return lines
+}
+
+// Renumber moves every line of bucket order[i] to bucket i, after the buckets themselves were reordered the same way
+func (a Assignment) Renumber(order []int) {
+	to := make([]uint32, len(order)+1)
+	for i, from := range order {
+		to[from+1] = uint32(i + 1)
+	}
+	for line, b := range a {
+		a[line] = to[b]
+	}
+}
+
+// Options holds the optional constraints Pack honours on top of size balancing
+type Options struct {
+	// MaxCountSpread bounds how far any bucket's row count may deviate from the mean, as a fraction (0.05 = ±5%). Zero disables the constraint
+	MaxCountSpread float64
+	// Trace, when set, is called by the worst-fit strategy for every placement with the item's rank in the sorted order, the bucket it went to and the buckets as they were just before placement. buckets is only valid for the duration of the call
+	Trace func(rank int, meta LineMeta, bucket int, buckets []FileBucket)
+	// Weights, when set, gives each bucket a target share of the total size in proportion to its weight instead of an equal one
+	Weights []float64
+	// Shuffle places rows in a random order seeded by Seed instead of largest first, so which rows share a bucket is randomized
+	Shuffle bool
+	Seed    int64
+	// Strategy picks the placement algorithm: worst-fit (the default, also used when empty), best-fit or kk
+	Strategy string
+	// Initial, when set, is what every bucket already holds before packing, as when appending to an existing split. Rows are placed to even out the loads including it, and the returned buckets count it
+	Initial []FileBucket
+	// MaxBucketSize caps every bucket's total size. A row that fits in no bucket is left unassigned, or starts a new bucket with GrowBuckets. A row larger than the cap is an error. Only worst-fit supports it, zero disables it
+	MaxBucketSize int64
+	GrowBuckets   bool
+	// Overflow, when set, receives the line numbers of the rows MaxBucketSize left unassigned, ascending
+	Overflow *[]int
+}
+
+// Pack distributes metas over n buckets so their total sizes are as even as the strategy manages, and returns the buckets along with which bucket each line went to. Rows sharing a non-zero Group are kept in one bucket. metas is reordered in place
+func Pack(metas []LineMeta, n int, opts Options) ([]FileBucket, Assignment, error) {
+	return PackContext(context.Background(), metas, n, opts)
+}
+
+// packCheckEvery is how many items a strategy places between checks for cancellation
+const packCheckEvery = 4096
+
+// PackContext is Pack, giving up with ctx's error once ctx is done
+func PackContext(ctx context.Context, metas []LineMeta, n int, opts Options) ([]FileBucket, Assignment, error) {
+	if err := checkPack(n, opts); err != nil {
+		return nil, nil, err
+	}
+	// sized once from the highest line number, line numbers are dense so little of it goes unused
+	maxLine := 0
+	for _, meta := range metas {
+		maxLine = max(maxLine, meta.LineNumber)
+	}
+	assign := make(Assignment, maxLine+1)
+	// grouped rows are packed as one item per group, then expanded back into their lines on placement
+	var groupLines [][]int
+	if len(metas) > 0 && metas[0].Group != 0 {
+		metas, groupLines = groupMetas(metas)
+	}
+
+	if opts.Shuffle {
+		rand.New(rand.NewSource(opts.Seed)).Shuffle(len(metas), func(i, j int) {
+			metas[i], metas[j] = metas[j], metas[i]
+		})
+	} else {
+		SortLargestFirst(metas)
+	}
+
+	p := &Packing{ctx: ctx, buckets: startBuckets(n, opts), assign: assign, groupLines: groupLines}
+	name := strategyName(opts)
+	if err := packers[name].Pack(metas, p, opts); err != nil {
+		return nil, nil, err
+	}
+	if p.handled != len(metas) {
+		return nil, nil, fmt.Errorf("the %s packer placed %d of %d items", name, p.handled, len(metas))
+	}
+	return p.buckets, p.assign, nil
+}
+
+// SortLargestFirst orders metas by size, largest first, breaking ties by line number. Line numbers are unique, so every sort algorithm and Go version ends up with the same order, and the same input always packs into the same buckets
+func SortLargestFirst(metas []LineMeta) {
+	sort.Slice(metas, func(i, j int) bool {
+		if metas[i].Size != metas[j].Size {
+			return metas[i].Size > metas[j].Size
+		}
+		return metas[i].LineNumber < metas[j].LineNumber
+	})
+}
+
+// PackStream is PackContext for more rows than fit in memory. metas must yield count rows, largest first, none with a line number above maxLine. Only the worst-fit strategy can place rows as they stream past, and rows can't be grouped or shuffled
+func PackStream(ctx context.Context, metas iter.Seq[LineMeta], count int, maxLine int, n int, opts Options) ([]FileBucket, Assignment, error) {
+	if err := checkPack(n, opts); err != nil {
+		return nil, nil, err
+	}
+	if opts.Strategy != "" && opts.Strategy != "worst-fit" {
+		return nil, nil, fmt.Errorf("the %s strategy needs every row in memory, only worst-fit can pack a stream", opts.Strategy)
+	}
+	if opts.Shuffle {
+		return nil, nil, fmt.Errorf("a stream can't be shuffled")
+	}
+
+	var streamErr error
+	checked := func(yield func(LineMeta) bool) {
+		prev := int64(math.MaxInt64)
+		for meta := range metas {
+			switch {
+			case meta.Group != 0:
+				streamErr = fmt.Errorf("line %d is grouped, a stream can't keep groups together", meta.LineNumber)
+			case meta.Size > prev:
+				streamErr = fmt.Errorf("line %d of size %d follows a row of size %d, the stream must be sorted largest first", meta.LineNumber, meta.Size, prev)
+			case meta.LineNumber < 0 || meta.LineNumber > maxLine:
+				streamErr = fmt.Errorf("line %d is outside 0..%d", meta.LineNumber, maxLine)
+			}
+			if streamErr != nil || !yield(meta) {
+				return
+			}
+			prev = meta.Size
+		}
+	}
+	p := &Packing{ctx: ctx, buckets: startBuckets(n, opts), assign: make(Assignment, maxLine+1)}
+	err := packWorstFit(checked, count, p, opts)
+	if streamErr != nil {
+		return nil, nil, streamErr
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return p.buckets, p.assign, nil
+}
+
+// checkPack validates what every way of packing needs
+func checkPack(n int, opts Options) error {
+	if n < 1 {
+		return fmt.Errorf("need at least 1 bucket, got %d", n)
+	}
+	if n > math.MaxUint32-1 {
+		return fmt.Errorf("at most %d buckets are supported, got %d", uint32(math.MaxUint32-1), n)
+	}
+	if opts.Initial != nil && len(opts.Initial) != n {
+		return fmt.Errorf("got initial loads for %d buckets, packing into %d", len(opts.Initial), n)
+	}
+	if opts.MaxBucketSize > 0 && opts.MaxCountSpread > 0 {
+		return fmt.Errorf("a bucket size cap can't be combined with a count spread")
+	}
+	if opts.GrowBuckets && opts.Weights != nil {
+		return fmt.Errorf("buckets with weights can't grow in number")
+	}
+	return CheckStrategy(opts)
+}
+
+// startBuckets is the n buckets packing starts from, empty or holding opts.Initial
+func startBuckets(n int, opts Options) []FileBucket {
+	buckets := make([]FileBucket, n)
+	copy(buckets, opts.Initial)
+	return buckets
+}
+
+// Packing is the state a Packer fills in as it places items
+type Packing struct {
+	ctx        context.Context
+	buckets    []FileBucket
+	assign     Assignment
+	groupLines [][]int // member lines of each group, nil when not grouping
+	handled    int     // items placed, or left out for want of room under MaxBucketSize
+}
+
+// Buckets is every bucket's load so far, only valid until the next Place
+func (p *Packing) Buckets() []FileBucket {
+	return p.buckets
+}
+
+// groupMetas collapses rows into one meta per group, returning the member lines of each group indexed by group id
+func groupMetas(metas []LineMeta) ([]LineMeta, [][]int) {
+	groups := []LineMeta{}
+	groupLines := [][]int{nil}
+	for _, meta := range metas {
+		for meta.Group >= len(groupLines) {
+			groupLines = append(groupLines, nil)
+			groups = append(groups, LineMeta{LineNumber: meta.LineNumber, Group: len(groupLines) - 1}) // first line of the group
+		}
+		groups[meta.Group-1].Size += meta.Size
+		groupLines[meta.Group] = append(groupLines[meta.Group], meta.LineNumber)
+	}
+	return groups, groupLines
+}
+
+// packWorstFit places every item, in order, into the currently lightest bucket that the count bounds allow
+func packWorstFit(metas iter.Seq[LineMeta], count int, p *Packing, opts Options) error {
+	buckets := p.buckets
+	bucketsN := len(buckets)
+	// lighter compares buckets by load relative to their target share, which is plain load when every share is equal
+	lighter := func(a, b *FileBucket, i, j int) bool {
+		return a.TotalSize < b.TotalSize
+	}
+	if opts.Weights != nil {
+		if len(opts.Weights) != bucketsN {
+			return fmt.Errorf("got %d weights for %d buckets", len(opts.Weights), bucketsN)
+		}
+		lighter = func(a, b *FileBucket, i, j int) bool {
+			return float64(a.TotalSize)*opts.Weights[j] < float64(b.TotalSize)*opts.Weights[i]
+		}
+	}
+
+	// the row count is known up front, so the count constraint is expressed as fixed per-bucket bounds around the final mean
+	// lower is only enforced once the remaining rows are just enough to cover every bucket's deficit, so size stays the objective for as long as possible
+	upper, lower := math.MaxInt, 0
+	deficit := 0
+	if opts.MaxCountSpread > 0 {
+		mean := float64(count) / float64(bucketsN)
+		upper = int(math.Floor(mean*(1+opts.MaxCountSpread) + 1e-9))
+		lower = int(math.Ceil(mean*(1-opts.MaxCountSpread) - 1e-9))
+		if upper < lower || upper*bucketsN < count || lower*bucketsN > count {
+			return fmt.Errorf("cannot split %d rows into %d buckets within a count spread of %.2f%%", count, bucketsN, opts.MaxCountSpread*100)
+		}
+		deficit = lower * bucketsN
+	}
+
+	// eligible buckets live in a min-heap ordered by lighter, ties going to the lowest index like a linear scan would
+	h := &bucketHeap{buckets: buckets, lighter: lighter}
+	for i := range buckets {
+		h.idx = append(h.idx, i)
+	}
+	heap.Init(h)
+	deficitPhase := false
+	var overflow []int
+
+	n := 0
+	for meta := range metas {
+		if err := p.Interrupted(n); err != nil {
+			return err
+		}
+		if n == count {
+			return fmt.Errorf("got more than the %d items announced", count)
+		}
+		remaining := count - n
+		if !deficitPhase && remaining <= deficit {
+			// from here on every row must go to a bucket still under lower, and that stays true until the end
+			deficitPhase = true
+			h.idx = h.idx[:0]
+			for i := range buckets {
+				if buckets[i].Lines < lower {
+					h.idx = append(h.idx, i)
+				}
+			}
+			heap.Init(h)
+		}
+		minIndex := h.idx[0]
+		if opts.MaxBucketSize > 0 && meta.Size > opts.MaxBucketSize {
+			return fmt.Errorf("line %d of size %d is larger than the bucket size cap %d", meta.LineNumber, meta.Size, opts.MaxBucketSize)
+		}
+		// the lightest bucket is the one with the most room, so a row that doesn't fit there fits nowhere
+		if opts.MaxBucketSize > 0 && buckets[minIndex].TotalSize+meta.Size > opts.MaxBucketSize {
+			if !opts.GrowBuckets {
+				overflow = append(overflow, p.lines(meta)...)
+				p.handled++
+				n++
+				continue
+			}
+			// every existing bucket holds more than cap - size > 0, so the new empty one becomes the heap's minimum
+			buckets = append(buckets, FileBucket{})
+			p.buckets, h.buckets = buckets, buckets
+			heap.Push(h, len(buckets)-1)
+			minIndex = h.idx[0]
+		}
+		if opts.Trace != nil {
+			opts.Trace(n, meta, minIndex, buckets)
+		}
+		if buckets[minIndex].Lines < lower {
+			deficit--
+		}
+		p.Place(minIndex, meta)
+
+		lines := buckets[minIndex].Lines
+		if lines >= upper || (deficitPhase && lines >= lower) {
+			heap.Pop(h)
+		} else {
+			heap.Fix(h, 0)
+		}
+		n++
+	}
+	if n != count {
+		return fmt.Errorf("got %d of the %d items announced", n, count)
+	}
+	if opts.Overflow != nil {
+		slices.Sort(overflow)
+		*opts.Overflow = overflow
+	}
+	return nil
+}
+
+// lines is the input lines of a packed item, its group's lines when grouping
+func (p *Packing) lines(meta LineMeta) []int {
+	if p.groupLines != nil {
+		return p.groupLines[meta.Group]
+	}
+	return []int{meta.LineNumber}
+}
+
+// Interrupted returns ctx's error, checking it only on every packCheckEvery-th item n
+func (p *Packing) Interrupted(n int) error {
+	if n%packCheckEvery != 0 {
+		return nil
+	}
+	return p.ctx.Err()
+}
+
+// Place adds a packed item to bucket b, expanding a group back into its lines
+func (p *Packing) Place(b int, meta LineMeta) {
+	p.handled++
+	bucket := &p.buckets[b]
+	bucket.TotalSize += meta.Size
+	if p.groupLines != nil {
+		for _, lineNum := range p.groupLines[meta.Group] {
+			p.assign[lineNum] = uint32(b + 1)
+		}
+		bucket.Lines += len(p.groupLines[meta.Group])
+		return
+	}
+	p.assign[meta.LineNumber] = uint32(b + 1)
+	bucket.Lines++
+}
+
+// bucketHeap orders bucket indexes by load so binpack finds the lightest bucket in O(log k)
+type bucketHeap struct {
+	idx     []int
+	buckets []FileBucket
+	lighter func(a, b *FileBucket, i, j int) bool
+}
+
+func (h *bucketHeap) Len() int { return len(h.idx) }
+
+func (h *bucketHeap) Less(a, b int) bool {
+	i, j := h.idx[a], h.idx[b]
+	if h.lighter(&h.buckets[i], &h.buckets[j], i, j) {
+		return true
+	}
+	if h.lighter(&h.buckets[j], &h.buckets[i], j, i) {
+		return false
+	}
+	return i < j
+}
+
+func (h *bucketHeap) Swap(a, b int) { h.idx[a], h.idx[b] = h.idx[b], h.idx[a] }
+
+func (h *bucketHeap) Push(x any) { h.idx = append(h.idx, x.(int)) }
+
+func (h *bucketHeap) Pop() any {
+	last := h.idx[len(h.idx)-1]
+	h.idx = h.idx[:len(h.idx)-1]
+	return last
+}
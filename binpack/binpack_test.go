@@ -0,0 +1,175 @@
+package binpack
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// linearWorstFit is the greedy worst-fit packWorstFit replaced: every row, largest first, goes into the lightest bucket found by scanning them all, the lowest index winning ties
+func linearWorstFit(metas []LineMeta, n int) ([]FileBucket, Assignment) {
+	metas = slices.Clone(metas)
+	SortLargestFirst(metas)
+	maxLine := 0
+	for _, meta := range metas {
+		maxLine = max(maxLine, meta.LineNumber)
+	}
+	buckets := make([]FileBucket, n)
+	assign := make(Assignment, maxLine+1)
+	for _, meta := range metas {
+		lightest := 0
+		for i := range buckets {
+			if buckets[i].TotalSize < buckets[lightest].TotalSize {
+				lightest = i
+			}
+		}
+		buckets[lightest].TotalSize += meta.Size
+		buckets[lightest].Lines++
+		assign[meta.LineNumber] = uint32(lightest + 1)
+	}
+	return buckets, assign
+}
+
+// randomMetas makes rows with sizes in [1, maxSize], a small maxSize giving many equal sizes and equally loaded buckets
+func randomMetas(rows int, maxSize int64, seed int64) []LineMeta {
+	rng := rand.New(rand.NewSource(seed))
+	metas := make([]LineMeta, rows)
+	for i := range metas {
+		metas[i] = LineMeta{LineNumber: i + 1, Size: rng.Int63n(maxSize) + 1}
+	}
+	return metas
+}
+
+func TestWorstFitMatchesLinearScan(t *testing.T) {
+	for _, tc := range []struct {
+		rows, buckets int
+		maxSize       int64
+	}{
+		{1, 1, 10},
+		{10, 3, 1},
+		{1000, 7, 5},
+		{1000, 64, 1000},
+		{5000, 1000, 100},
+	} {
+		metas := randomMetas(tc.rows, tc.maxSize, int64(tc.rows))
+		wantBuckets, wantAssign := linearWorstFit(metas, tc.buckets)
+		buckets, assign, err := Pack(metas, tc.buckets, Options{})
+		if err != nil {
+			t.Fatalf("%d rows into %d buckets: %v", tc.rows, tc.buckets, err)
+		}
+		if !slices.Equal(buckets, wantBuckets) {
+			t.Errorf("%d rows into %d buckets: got buckets %v, the linear scan gives %v", tc.rows, tc.buckets, buckets, wantBuckets)
+		}
+		if !slices.Equal(assign, wantAssign) {
+			t.Errorf("%d rows into %d buckets: the assignment differs from the linear scan's", tc.rows, tc.buckets)
+		}
+	}
+}
+
+func BenchmarkWorstFit10kBuckets(b *testing.B) {
+	metas := randomMetas(100_000, 1_000_000, 1)
+	for b.Loop() {
+		if _, _, err := Pack(slices.Clone(metas), 10_000, Options{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLinearScan10kBuckets(b *testing.B) {
+	metas := randomMetas(100_000, 1_000_000, 1)
+	for b.Loop() {
+		linearWorstFit(metas, 10_000)
+	}
+}
+
+func TestKKSpreadAtMostWorstFit(t *testing.T) {
+	for seed := int64(1); seed <= 300; seed++ {
+		for _, tc := range []struct {
+			rows, buckets int
+			maxSize       int64
+		}{
+			{5, 2, 10},
+			{20, 5, 10},
+			{20, 5, 1_000_000},
+			{100, 3, 1000},
+			{100, 8, 1_000_000},
+			{3, 5, 100},
+		} {
+			metas := randomMetas(tc.rows, tc.maxSize, seed)
+			kk, _, err := Pack(metas, tc.buckets, Options{Strategy: "kk"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			wf, _, err := Pack(metas, tc.buckets, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if Imbalance(kk) > Imbalance(wf) {
+				t.Errorf("seed %d, %d rows into %d buckets: kk spread %d, worst-fit %d", seed, tc.rows, tc.buckets, Imbalance(kk), Imbalance(wf))
+			}
+		}
+	}
+}
+
+// TestKKBeatsWorstFit packs an input where worst-fit is known to be uneven: largest first, 8 and 7 go apart and 6, 5, 4 leave 8+5+4 against 7+6, where differencing gets to 8+6 against 7+5+4
+func TestKKBeatsWorstFit(t *testing.T) {
+	var metas []LineMeta
+	for i, size := range []int64{8, 7, 6, 5, 4} {
+		metas = append(metas, LineMeta{LineNumber: i + 1, Size: size})
+	}
+	kk, _, err := Pack(metas, 2, Options{Strategy: "kk"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf, _, err := Pack(metas, 2, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Imbalance(wf) != 4 || Imbalance(kk) != 2 {
+		t.Errorf("got worst-fit spread %d and kk spread %d, want 4 and 2", Imbalance(wf), Imbalance(kk))
+	}
+}
+
+// lookupRows is the input the lookup benchmarks build a line-to-bucket lookup for and then read every line of, their B/op being the memory each lookup takes
+const lookupRows = 1_000_000
+
+func BenchmarkAssignmentLookup(b *testing.B) {
+	metas := randomMetas(lookupRows, 1_000_000, 1)
+	_, placed, err := Pack(metas, 64, Options{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		assign := make(Assignment, lookupRows+1)
+		for line := 1; line <= lookupRows; line++ {
+			assign[line] = placed[line]
+		}
+		for line := 1; line <= lookupRows; line++ {
+			if _, ok := assign.Bucket(line); !ok {
+				b.Fatalf("line %d has no bucket", line)
+			}
+		}
+	}
+}
+
+// BenchmarkLineMapLookup is BenchmarkAssignmentLookup with the map from line number to bucket that Assignment replaced
+func BenchmarkLineMapLookup(b *testing.B) {
+	metas := randomMetas(lookupRows, 1_000_000, 1)
+	_, placed, err := Pack(metas, 64, Options{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		lineToBucket := map[int]int{}
+		for line := 1; line <= lookupRows; line++ {
+			lineToBucket[line] = int(placed[line]) - 1
+		}
+		for line := 1; line <= lookupRows; line++ {
+			if _, ok := lineToBucket[line]; !ok {
+				b.Fatalf("line %d has no bucket", line)
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package binpack
+
+import (
+	"slices"
+	"sort"
+)
+
+// Packer is a placement strategy. Pack gets the items in the order they should be placed, largest first or shuffled, with every group collapsed into one item, and must hand each of them to p.Place exactly once
+type Packer interface {
+	Pack(metas []LineMeta, p *Packing, opts Options) error
+}
+
+// OptionChecker is implemented by packers that support options beyond equal shares. Without it a packer gets no count spread, weights, shuffling, size cap or initial loads
+type OptionChecker interface {
+	Check(opts Options) error
+}
+
+var packers = map[string]Packer{
+	"worst-fit": WorstFitPacker{},
+	"best-fit":  BestFitPacker{},
+	"kk":        KKPacker{},
+}
+
+// RegisterPacker makes a custom strategy selectable as Options.Strategy, and with --strategy name in the CLI. Call it from an init function before packing
+//
+//	func init() {
+//		binpack.RegisterPacker("round-robin", roundRobin{})
+//	}
+func RegisterPacker(name string, p Packer) {
+	packers[name] = p
+}
+
+// Packers lists the registered strategy names, sorted
+func Packers() []string {
+	names := []string{}
+	for name := range packers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// strategyName is the packer opts selects, worst-fit when Strategy is empty
+func strategyName(opts Options) string {
+	if opts.Strategy == "" {
+		return "worst-fit"
+	}
+	return opts.Strategy
+}
+
+// WorstFitPacker puts every item into the currently lightest bucket, the default strategy and the only one with every option
+type WorstFitPacker struct{}
+
+func (WorstFitPacker) Pack(metas []LineMeta, p *Packing, opts Options) error {
+	return packWorstFit(slices.Values(metas), len(metas), p, opts)
+}
+
+func (WorstFitPacker) Check(Options) error {
+	return nil
+}
+
+// BestFitPacker puts every item into the fullest bucket it still fits in under an even share
+type BestFitPacker struct{}
+
+func (BestFitPacker) Pack(metas []LineMeta, p *Packing, _ Options) error {
+	return packBestFit(metas, p)
+}
+
+func (BestFitPacker) Check(opts Options) error {
+	return checkPlainOptions("best-fit", opts, true)
+}
+
+// KKPacker partitions with the Karmarkar-Karp largest differencing method
+type KKPacker struct{}
+
+func (KKPacker) Pack(metas []LineMeta, p *Packing, _ Options) error {
+	return packKK(metas, p)
+}
@@ -0,0 +1,79 @@
+package binpack_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"binpacking/binpack"
+)
+
+// roundRobin deals the items out to the buckets in turn, a strategy written outside the package against the exported Packer interface only
+type roundRobin struct{}
+
+func (roundRobin) Pack(metas []binpack.LineMeta, p *binpack.Packing, _ binpack.Options) error {
+	for i, m := range metas {
+		if err := p.Interrupted(i); err != nil {
+			return err
+		}
+		p.Place(i%len(p.Buckets()), m)
+	}
+	return nil
+}
+
+// dropLast places every item but the last
+type dropLast struct{}
+
+func (dropLast) Pack(metas []binpack.LineMeta, p *binpack.Packing, _ binpack.Options) error {
+	for _, m := range metas[:len(metas)-1] {
+		p.Place(0, m)
+	}
+	return nil
+}
+
+func init() {
+	binpack.RegisterPacker("round-robin", roundRobin{})
+	binpack.RegisterPacker("drop-last", dropLast{})
+}
+
+func TestCustomPacker(t *testing.T) {
+	if !slices.Contains(binpack.Packers(), "round-robin") {
+		t.Fatalf("registered packers %v don't include round-robin", binpack.Packers())
+	}
+	metas := []binpack.LineMeta{
+		{LineNumber: 1, Size: 10},
+		{LineNumber: 2, Size: 50},
+		{LineNumber: 3, Size: 30},
+		{LineNumber: 4, Size: 40},
+		{LineNumber: 5, Size: 20},
+	}
+	buckets, assign, err := binpack.Pack(metas, 2, binpack.Options{Strategy: "round-robin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// taken largest first: 50, 40, 30, 20, 10 are dealt to buckets 0, 1, 0, 1, 0
+	want := []binpack.FileBucket{{TotalSize: 90, Lines: 3}, {TotalSize: 60, Lines: 2}}
+	if !slices.Equal(buckets, want) {
+		t.Errorf("got buckets %v, want %v", buckets, want)
+	}
+	for line, bucket := range map[int]int{1: 0, 2: 0, 3: 0, 4: 1, 5: 1} {
+		if got, ok := assign.Bucket(line); !ok || got != bucket {
+			t.Errorf("line %d went to bucket %d (assigned %t), want %d", line, got, ok, bucket)
+		}
+	}
+}
+
+func TestCustomPackerWithoutCheckRejectsOptions(t *testing.T) {
+	metas := []binpack.LineMeta{{LineNumber: 1, Size: 10}, {LineNumber: 2, Size: 20}}
+	if _, _, err := binpack.Pack(metas, 2, binpack.Options{Strategy: "round-robin", Shuffle: true}); err == nil {
+		t.Error("a packer without Check accepted Shuffle")
+	}
+}
+
+func TestCustomPackerMustPlaceEveryItem(t *testing.T) {
+	metas := []binpack.LineMeta{{LineNumber: 1, Size: 10}, {LineNumber: 2, Size: 20}}
+	_, _, err := binpack.Pack(metas, 2, binpack.Options{Strategy: "drop-last"})
+	if err == nil || !strings.Contains(err.Error(), "placed 1 of 2") {
+		t.Errorf("got %v, want an error that the packer placed 1 of 2 items", err)
+	}
+}
@@ -0,0 +1,235 @@
+package binpack
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// CheckStrategy rejects unknown strategies and options the strategy's packer doesn't implement
+func CheckStrategy(opts Options) error {
+	name := strategyName(opts)
+	packer, ok := packers[name]
+	if !ok {
+		return fmt.Errorf("unknown --strategy %q, available: %v", opts.Strategy, Packers())
+	}
+	if c, ok := packer.(OptionChecker); ok {
+		return c.Check(opts)
+	}
+	return checkPlainOptions(name, opts, false)
+}
+
+// checkPlainOptions rejects the options only worst-fit implements. initialOK lets buckets start from Initial loads
+func checkPlainOptions(name string, opts Options, initialOK bool) error {
+	switch {
+	case opts.MaxCountSpread > 0:
+		return fmt.Errorf("--strategy %s cannot be combined with --max-count-spread", name)
+	case opts.Weights != nil:
+		return fmt.Errorf("--strategy %s only supports equal bucket shares", name)
+	case opts.Shuffle:
+		return fmt.Errorf("--strategy %s cannot be combined with shuffling", name)
+	case opts.MaxBucketSize > 0:
+		return fmt.Errorf("--strategy %s cannot be combined with a bucket size cap", name)
+	case opts.Initial != nil && !initialOK:
+		return fmt.Errorf("--strategy %s partitions from empty buckets, it can't add to existing loads", name)
+	}
+	return nil
+}
+
+// Imbalance is the largest bucket's size minus the smallest's
+func Imbalance(buckets []FileBucket) int64 {
+	if len(buckets) == 0 {
+		return 0
+	}
+	lo, hi := buckets[0].TotalSize, buckets[0].TotalSize
+	for _, b := range buckets[1:] {
+		lo, hi = min(lo, b.TotalSize), max(hi, b.TotalSize)
+	}
+	return hi - lo
+}
+
+// Balance summarizes how evenly a packing spread the total size over its buckets
+type Balance struct {
+	Min, Max     int64
+	Mean, StdDev float64
+	Imbalance    float64 // how far the largest bucket is above the mean, as a fraction of the mean
+}
+
+// Summarize computes the Balance of buckets, using the population standard deviation
+func Summarize(buckets []FileBucket) Balance {
+	if len(buckets) == 0 {
+		return Balance{}
+	}
+	b := Balance{Min: buckets[0].TotalSize, Max: buckets[0].TotalSize}
+	for _, bucket := range buckets {
+		b.Min, b.Max = min(b.Min, bucket.TotalSize), max(b.Max, bucket.TotalSize)
+		b.Mean += float64(bucket.TotalSize)
+	}
+	b.Mean /= float64(len(buckets))
+	for _, bucket := range buckets {
+		d := float64(bucket.TotalSize) - b.Mean
+		b.StdDev += d * d
+	}
+	b.StdDev = math.Sqrt(b.StdDev / float64(len(buckets)))
+	if b.Mean > 0 {
+		b.Imbalance = (float64(b.Max) - b.Mean) / b.Mean
+	}
+	return b
+}
+
+// packBestFit places every item, largest first, into the fullest bucket it still fits in without going over an even share of the total, falling back to the lightest bucket when it fits nowhere
+func packBestFit(metas []LineMeta, p *Packing) error {
+	buckets := p.buckets
+	var total int64
+	for _, m := range metas {
+		total += m.Size
+	}
+	for _, b := range buckets {
+		total += b.TotalSize
+	}
+	capacity := (total + int64(len(buckets)) - 1) / int64(len(buckets))
+
+	// bucket indexes kept sorted by load, lightest first
+	order := make([]int, len(buckets))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(x, y int) bool { return buckets[order[x]].TotalSize < buckets[order[y]].TotalSize })
+	for n, meta := range metas {
+		if err := p.Interrupted(n); err != nil {
+			return err
+		}
+		pos := sort.Search(len(order), func(p int) bool {
+			return buckets[order[p]].TotalSize+meta.Size > capacity
+		}) - 1
+		if pos < 0 {
+			pos = 0
+		}
+		b := order[pos]
+		p.Place(b, meta)
+		// loads only grow, so the bucket only ever moves towards the heavy end
+		for pos+1 < len(order) && buckets[order[pos+1]].TotalSize < buckets[b].TotalSize {
+			order[pos], order[pos+1] = order[pos+1], b
+			pos++
+		}
+	}
+	return nil
+}
+
+// kkSubset is one bucket of a partial partition. Its items are chained through kkState.next so merging two subsets is O(1)
+type kkSubset struct {
+	sum        int64
+	head, tail int
+}
+
+// kkPartition is a partial k-way partition, subsets sorted by sum largest first. Subsets past the end are empty
+type kkPartition struct {
+	subsets []kkSubset
+	spread  int64
+}
+
+type kkHeap []kkPartition
+
+func (h kkHeap) Len() int           { return len(h) }
+func (h kkHeap) Less(a, b int) bool { return h[a].spread > h[b].spread }
+func (h kkHeap) Swap(a, b int)      { h[a], h[b] = h[b], h[a] }
+func (h *kkHeap) Push(x any)        { *h = append(*h, x.(kkPartition)) }
+func (h *kkHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// packKK runs the Karmarkar-Karp largest differencing method: every item starts as its own partition and the two partitions with the largest spread are repeatedly merged, pairing the heaviest subsets of one with the lightest of the other, until one partition is left.
+// Differencing usually beats worst-fit but not on every input, so worst-fit's partition is placed instead when its spread is smaller
+func packKK(metas []LineMeta, p *Packing) error {
+	if len(metas) == 0 {
+		return nil
+	}
+	k := len(p.buckets)
+	next := make([]int, len(metas))
+	h := make(kkHeap, len(metas))
+	for i, m := range metas {
+		next[i] = -1
+		h[i] = kkPartition{subsets: []kkSubset{{sum: m.Size, head: i, tail: i}}, spread: m.Size}
+	}
+	heap.Init(&h)
+
+	join := func(a, b kkSubset) kkSubset {
+		if a.head < 0 {
+			return b
+		}
+		if b.head < 0 {
+			return a
+		}
+		next[a.tail] = b.head
+		return kkSubset{sum: a.sum + b.sum, head: a.head, tail: b.tail}
+	}
+	empty := kkSubset{head: -1, tail: -1}
+	at := func(subsets []kkSubset, i int) kkSubset {
+		if i < len(subsets) {
+			return subsets[i]
+		}
+		return empty
+	}
+
+	for h.Len() > 1 {
+		if err := p.Interrupted(h.Len()); err != nil {
+			return err
+		}
+		a := heap.Pop(&h).(kkPartition)
+		b := heap.Pop(&h).(kkPartition)
+		// subset i of a pairs with subset k-1-i of b, only indexes where either side is non-empty matter
+		merged := make([]kkSubset, 0, min(k, len(a.subsets)+len(b.subsets)))
+		for i := range a.subsets {
+			merged = append(merged, join(a.subsets[i], at(b.subsets, k-1-i)))
+		}
+		for i := max(len(a.subsets), k-len(b.subsets)); i < k; i++ {
+			merged = append(merged, at(b.subsets, k-1-i))
+		}
+		sort.Slice(merged, func(x, y int) bool {
+			if merged[x].sum != merged[y].sum {
+				return merged[x].sum > merged[y].sum
+			}
+			return merged[x].head < merged[y].head
+		})
+		part := kkPartition{subsets: merged, spread: merged[0].sum}
+		if len(merged) == k {
+			part.spread -= merged[k-1].sum
+		}
+		heap.Push(&h, part)
+	}
+
+	if greedy, spread := worstFitPartition(metas, k); spread < h[0].spread {
+		for i, m := range metas {
+			p.Place(greedy[i], m)
+		}
+		return nil
+	}
+	for i, subset := range h[0].subsets {
+		for item := subset.head; item >= 0; item = next[item] {
+			p.Place(i, metas[item])
+		}
+	}
+	return nil
+}
+
+// worstFitPartition is the bucket packWorstFit would put each item in, in order, from k empty buckets with no other options, and the resulting spread
+func worstFitPartition(metas []LineMeta, k int) ([]int, int64) {
+	buckets := make([]FileBucket, k)
+	h := &bucketHeap{buckets: buckets, lighter: func(a, b *FileBucket, _, _ int) bool { return a.TotalSize < b.TotalSize }}
+	for i := range buckets {
+		h.idx = append(h.idx, i)
+	}
+	heap.Init(h)
+	placed := make([]int, len(metas))
+	for i, m := range metas {
+		b := h.idx[0]
+		buckets[b].TotalSize += m.Size
+		placed[i] = b
+		heap.Fix(h, 0)
+	}
+	return placed, Imbalance(buckets)
+}
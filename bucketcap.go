@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+var maxBucketSize string
+var overflowMode string
+var relaxConstraint string
+
+// bucketCap is the parsed --max-bucket-size, which --overflow fail checks the packed buckets against
+var bucketCap int64
+
+// oversizedName is the file rows no bucket has room for go to, with --max-bucket-size and a fixed bucket count
+const oversizedName = "oversized.csv"
+
+// the --overflow modes
+const (
+	overflowFile   = "file"
+	overflowBucket = "bucket"
+	overflowFail   = "fail"
+)
+
+// relaxBuckets is --relax buckets, which raises the bucket count until every bucket is under --max-bucket-size
+const relaxBuckets = "buckets"
+
+// checkBucketCap parses --max-bucket-size into packOpts and rejects flags it can't be combined with. With --relax buckets it returns the cap
+// for split to pack to as it would a --target-size, starting from <buckets>, and leaves packOpts alone
+func checkBucketCap(sizeCap int64) (int64, error) {
+	limit, err := ParseBytes(maxBucketSize)
+	if err != nil {
+		return 0, fmt.Errorf("--max-bucket-size: %w", err)
+	}
+	switch {
+	case limit < 1:
+		return 0, fmt.Errorf("--max-bucket-size must be at least 1 byte")
+	case overflowMode != overflowFile && overflowMode != overflowBucket && overflowMode != overflowFail:
+		return 0, fmt.Errorf("unknown --overflow %q, expected %s, %s or %s", overflowMode, overflowFile, overflowBucket, overflowFail)
+	case relaxConstraint != "" && relaxConstraint != relaxBuckets:
+		return 0, fmt.Errorf("unknown --relax %q, only %s can be relaxed", relaxConstraint, relaxBuckets)
+	case relaxConstraint != "" && overflowMode != overflowFile:
+		return 0, fmt.Errorf("--relax buckets cannot be combined with --overflow, it repacks into more buckets instead of handling rows that don't fit")
+	case sizeCap > 0:
+		return 0, fmt.Errorf("--max-bucket-size cannot be combined with %s, which already derives the bucket count from a cap", capFlag)
+	case scanOpts.Size.Relative:
+		return 0, fmt.Errorf("--max-bucket-size cannot be combined with --size-mode relative")
+	case partitionBy != "":
+		return 0, fmt.Errorf("--max-bucket-size cannot be combined with --partition-by, a key's rows can't move to another bucket")
+	case overflowMode == overflowFile && relaxConstraint == "" && (checkpointSplit || resumeSplit):
+		return 0, fmt.Errorf("--max-bucket-size cannot be combined with --checkpoint or --resume, the checkpoint doesn't record the oversized rows, pass --overflow bucket")
+	}
+	bucketCap = limit
+	if relaxConstraint == relaxBuckets {
+		capFlag = "--max-bucket-size"
+		return limit, nil
+	}
+	if overflowMode == overflowFail {
+		// packed as usual, and only checked against the cap afterwards
+		return 0, nil
+	}
+	packOpts.MaxBucketSize = limit
+	packOpts.GrowBuckets = overflowMode == overflowBucket
+	if !packOpts.GrowBuckets {
+		packOpts.Overflow = &writeOpts.Oversized
+	}
+	return 0, nil
+}
+
+// printOverflow reports what keeping every bucket under --max-bucket-size took
+func printOverflow(prefix string, requested int, buckets []FileBucket, oversized []int) {
+	limit := displaySize(packOpts.MaxBucketSize, FormatNumber(packOpts.MaxBucketSize))
+	if added := len(buckets) - requested; added > 0 {
+		logInfo("binpack", "added %d overflow buckets to keep every bucket under %s", added, limit)
+	} else if len(oversized) > 0 {
+		logWarn("binpack", "%s rows fit in no bucket under %s, writing them to %s%s", FormatNumber(int64(len(oversized))), limit, prefix, oversizedName)
+	} else {
+		logInfo("binpack", "every bucket is under %s", limit)
+	}
+}
+
+// checkUnderCap is --overflow fail: an error naming every bucket over --max-bucket-size, if any is
+func checkUnderCap(buckets []FileBucket) error {
+	var over []string
+	for i, b := range buckets {
+		if b.TotalSize > bucketCap {
+			over = append(over, fmt.Sprintf("bucket %d has %s, %s over", i+1, displaySize(b.TotalSize, FormatNumber(b.TotalSize)), displaySize(b.TotalSize-bucketCap, FormatNumber(b.TotalSize-bucketCap))))
+		}
+	}
+	if len(over) == 0 {
+		logInfo("binpack", "every bucket is under %s, the bucket count was the binding constraint", displaySize(bucketCap, FormatNumber(bucketCap)))
+		return nil
+	}
+	count := len(over)
+	if len(over) > 5 {
+		over = append(over[:5], fmt.Sprintf("and %d more", count-5))
+	}
+	return fmt.Errorf("%d of %d buckets are over --max-bucket-size %s: %s. Pass --relax buckets to use as few more buckets as keep them all under it",
+		count, len(buckets), displaySize(bucketCap, FormatNumber(bucketCap)), strings.Join(over, "; "))
+}
+
+// printRelaxed reports which constraint decided a --relax buckets split, the <buckets> asked for or the cap
+func printRelaxed(requested int, buckets []FileBucket) {
+	limit := displaySize(bucketCap, FormatNumber(bucketCap))
+	if len(buckets) == requested {
+		logInfo("binpack", "%d buckets keep every bucket under %s, the bucket count was the binding constraint", requested, limit)
+		return
+	}
+	logInfo("binpack", "%d buckets couldn't keep every bucket under %s, relaxed to %d: the size cap was the binding constraint", requested, limit, len(buckets))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// cancelCheckEvery is how many rows the scan and write loops handle between checks for cancellation
+const cancelCheckEvery = 4096
+
+// cleanups run, newest first, when a run exits early through exit
+var cleanups []func()
+
+// atExit registers work that must happen if the run is interrupted or fails before finishing, like removing a spill file
+func atExit(f func()) {
+	cleanups = append(cleanups, f)
+}
+
+// exit runs the registered cleanups, then exits with code
+func exit(code int) {
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+	os.Exit(code)
+}
+
+// exitCancelled exits non-zero once phase has noticed the interruption
+func exitCancelled(phase string) {
+	logWarn(phase, "cancelled")
+	exit(1)
+}
+
+// signalContext returns a context cancelled by the first SIGINT or SIGTERM. A second signal kills the process as usual, in case it is blocked on a read that never gets to check the context
+func signalContext() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		logWarn("", "interrupted, cleaning up (interrupt again to quit immediately)")
+	}()
+	return ctx
+}
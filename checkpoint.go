@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+var checkpointSplit bool
+var resumeSplit bool
+
+// checkpointName is written next to the outputs while a split with --checkpoint runs, and removed once it finishes
+const checkpointName = "split.checkpoint"
+
+// checkpointMagic starts every checkpoint file, bump the version whenever the layout changes
+const checkpointMagic = "BPCHECK4"
+
+// checkpointEvery is how many rows each writer takes between committed watermarks
+const checkpointEvery = 65536
+
+// watermarkRecord is the encoded length of one watermark: bucket, last line, bytes and content hash as little endian uint64s
+const watermarkRecord = 32
+
+// splitPlan is what a resumed split needs from the first run's scan and binpack passes
+type splitPlan struct {
+	Fingerprint     sizeFingerprint
+	NameTemplate    string
+	Columns         string // the --columns list the outputs were projected with
+	ExpectedRecords int
+	ContentHash     bool // the watermarks carry content hashes, which a resumed --content-hash run needs to carry on from
+	Buckets         []FileBucket
+	Assign          Assignment
+	RowOffsets      []int64 // the scan's seek index, empty without --seek-index
+}
+
+// watermark is how far one output got: every row of the bucket up to LastLine is in its first Bytes bytes. Bytes 0 means nothing was committed, not even the header
+type watermark struct {
+	Bucket      int
+	LastLine    int
+	Bytes       int64
+	ContentHash uint64
+}
+
+// checkpoint is the plan followed by an append-only log of watermarks. A watermark is only appended once the output is flushed and synced up to it, so a crash loses at most the rows since each bucket's last one
+type checkpoint struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+}
+
+func checkpointPath(prefix string) string {
+	return prefix + checkpointName
+}
+
+// checkCheckpoint rejects outputs a checkpoint can't cut back and append to
+func checkCheckpoint(input string, prefix string) error {
+	flag := "--checkpoint"
+	if resumeSplit {
+		flag = "--resume"
+	}
+	switch {
+	case input == stdinInput:
+		return fmt.Errorf("%s needs an input file, stdin can't be read again by a later run", flag)
+	case isS3Prefix(prefix):
+		return fmt.Errorf("%s only supports local output paths", flag)
+	case compressOutputs:
+		return fmt.Errorf("%s cannot be combined with --compress, a gzip stream can't be cut back and appended to", flag)
+	case writeOpts.RowGroupSize > 0:
+		return fmt.Errorf("%s cannot be combined with --row-group-size", flag)
+	case resumeSplit && (dryRun || checkOutputs || preflight):
+		return fmt.Errorf("--resume skips the scan and binpack passes, it cannot be combined with --dry-run, --check-outputs or --preflight")
+	}
+	return nil
+}
+
+// createCheckpoint writes plan to <prefix>split.checkpoint, ready to log watermarks after it
+func createCheckpoint(prefix string, plan splitPlan) (*checkpoint, error) {
+	path := checkpointPath(prefix)
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	w.WriteString(checkpointMagic)
+	writeFingerprint(w, plan.Fingerprint)
+	for _, s := range []string{plan.NameTemplate, plan.Columns} {
+		binary.Write(w, binary.LittleEndian, uint32(len(s)))
+		w.WriteString(s)
+	}
+	hashed := uint64(0)
+	if plan.ContentHash {
+		hashed = 1
+	}
+	binary.Write(w, binary.LittleEndian, [3]uint64{uint64(plan.ExpectedRecords), uint64(len(plan.Buckets)), hashed})
+	for _, b := range plan.Buckets {
+		binary.Write(w, binary.LittleEndian, [2]int64{b.TotalSize, int64(b.Lines)})
+	}
+	binary.Write(w, binary.LittleEndian, uint64(len(plan.Assign)))
+	binary.Write(w, binary.LittleEndian, []uint32(plan.Assign))
+	binary.Write(w, binary.LittleEndian, uint64(len(plan.RowOffsets)))
+	binary.Write(w, binary.LittleEndian, plan.RowOffsets)
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &checkpoint{path: path, f: f}, nil
+}
+
+// loadCheckpoint reads the plan and each bucket's last watermark from <prefix>split.checkpoint and reopens it to log more
+func loadCheckpoint(prefix string) (splitPlan, []watermark, *checkpoint, error) {
+	var plan splitPlan
+	path := checkpointPath(prefix)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return plan, nil, nil, err
+	}
+	marks, end, err := readCheckpoint(&countingReader{r: bufio.NewReader(f)}, &plan)
+	if err != nil {
+		f.Close()
+		return plan, nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	// a crash can leave half a watermark at the end, cut it off so new ones stay aligned
+	if err := f.Truncate(end); err != nil {
+		f.Close()
+		return plan, nil, nil, err
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return plan, nil, nil, err
+	}
+	return plan, marks, &checkpoint{path: path, f: f}, nil
+}
+
+// readCheckpoint decodes the plan into plan and returns the last watermark of every bucket along with the offset just past the last whole one
+func readCheckpoint(r *countingReader, plan *splitPlan) ([]watermark, int64, error) {
+	magic := make([]byte, len(checkpointMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != checkpointMagic {
+		return nil, 0, errors.New("not a checkpoint file")
+	}
+	fp, err := readFingerprint(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	plan.Fingerprint = fp
+	strs := make([]string, 2)
+	for i := range strs {
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return nil, 0, err
+		}
+		if n > 1<<16 {
+			return nil, 0, errors.New("corrupt name template or column list")
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, 0, err
+		}
+		strs[i] = string(b)
+	}
+	plan.NameTemplate, plan.Columns = strs[0], strs[1]
+	var counts [3]uint64
+	if err := binary.Read(r, binary.LittleEndian, &counts); err != nil {
+		return nil, 0, err
+	}
+	if counts[1] > maxBuckets {
+		return nil, 0, errors.New("corrupt bucket count")
+	}
+	plan.ExpectedRecords = int(counts[0])
+	plan.ContentHash = counts[2] == 1
+	plan.Buckets = make([]FileBucket, counts[1])
+	for i := range plan.Buckets {
+		var b [2]int64
+		if err := binary.Read(r, binary.LittleEndian, &b); err != nil {
+			return nil, 0, err
+		}
+		plan.Buckets[i] = FileBucket{TotalSize: b[0], Lines: int(b[1])}
+	}
+	var lines uint64
+	if err := binary.Read(r, binary.LittleEndian, &lines); err != nil {
+		return nil, 0, err
+	}
+	if lines != uint64(plan.ExpectedRecords)+1 {
+		return nil, 0, errors.New("corrupt assignment")
+	}
+	plan.Assign = make(Assignment, lines)
+	if err := binary.Read(r, binary.LittleEndian, []uint32(plan.Assign)); err != nil {
+		return nil, 0, err
+	}
+	var offsets uint64
+	if err := binary.Read(r, binary.LittleEndian, &offsets); err != nil {
+		return nil, 0, err
+	}
+	// one per data row at least, plus the header and the end of the input
+	if offsets != 0 && offsets < lines+1 {
+		return nil, 0, errors.New("corrupt seek index")
+	}
+	plan.RowOffsets = make([]int64, offsets)
+	if err := binary.Read(r, binary.LittleEndian, plan.RowOffsets); err != nil {
+		return nil, 0, err
+	}
+	if offsets == 0 {
+		plan.RowOffsets = nil
+	}
+	end := r.n
+
+	marks := make([]watermark, len(plan.Buckets))
+	var rec [watermarkRecord]byte
+	for {
+		if _, err := io.ReadFull(r, rec[:]); err != nil {
+			return marks, end, nil
+		}
+		m := watermark{
+			Bucket:      int(binary.LittleEndian.Uint64(rec[0:])),
+			LastLine:    int(binary.LittleEndian.Uint64(rec[8:])),
+			Bytes:       int64(binary.LittleEndian.Uint64(rec[16:])),
+			ContentHash: binary.LittleEndian.Uint64(rec[24:]),
+		}
+		if m.Bucket < 0 || m.Bucket >= len(marks) {
+			return nil, 0, errors.New("corrupt watermark")
+		}
+		marks[m.Bucket] = m
+		end += watermarkRecord
+	}
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// commit logs m. Callers flush and sync the output up to m first
+func (c *checkpoint) commit(m watermark) error {
+	var rec [watermarkRecord]byte
+	binary.LittleEndian.PutUint64(rec[0:], uint64(m.Bucket))
+	binary.LittleEndian.PutUint64(rec[8:], uint64(m.LastLine))
+	binary.LittleEndian.PutUint64(rec[16:], uint64(m.Bytes))
+	binary.LittleEndian.PutUint64(rec[24:], m.ContentHash)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.f.Write(rec[:])
+	return err
+}
+
+// remove deletes the checkpoint once the split it describes has finished
+func (c *checkpoint) remove() error {
+	c.f.Close()
+	return os.Remove(c.path)
+}
+
+// syncedOutput is a local output the checkpoint can commit to disk, an *os.File or the cachedFile --max-open-files wraps it in
+type syncedOutput interface {
+	Sync() error
+	Name() string
+}
+
+// reopenOutput cuts an output back to the bytes its watermark vouches for, dropping any rows a crashed run wrote after it, and positions it for appending
+func reopenOutput(path string, bytes int64) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if stat.Size() < bytes {
+		f.Close()
+		return nil, fmt.Errorf("%s has %d bytes, fewer than the %d the checkpoint recorded", path, stat.Size(), bytes)
+	}
+	if err := f.Truncate(bytes); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(bytes, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// resume finishes a split from its checkpoint: the saved plan stands in for the scan and binpack passes, and write picks every output up from its last watermark
+func resume(ctx context.Context, input string, prefix string, bucketsN int) []FileBucket {
+	plan, marks, cp, err := loadCheckpoint(prefix)
+	if errors.Is(err, os.ErrNotExist) {
+		logError("", "--resume found no checkpoint at %s, the split finished or never ran with --checkpoint", checkpointPath(prefix))
+		os.Exit(1)
+	}
+	if err != nil {
+		logError("resume", "%v", err)
+		os.Exit(1)
+	}
+	writeOpts.InputStat, err = os.Stat(input)
+	if err != nil {
+		logError("", "%v", err)
+		os.Exit(1)
+	}
+	switch {
+	case plan.Fingerprint != newSizeFingerprint(writeOpts.InputStat, scanOpts):
+		err = fmt.Errorf("the input or its size settings changed since the checkpoint was written")
+	case plan.NameTemplate != nameTemplate:
+		err = fmt.Errorf("the checkpoint was written with --name-template %q", plan.NameTemplate)
+	case plan.Columns != outputColumns:
+		err = fmt.Errorf("the checkpoint was written with --columns %q", plan.Columns)
+	case writeOpts.ContentHash && !plan.ContentHash:
+		err = fmt.Errorf("the checkpointed run didn't hash its outputs, so --content-hash can't cover the rows it wrote")
+	case bucketsN != 0 && bucketsN != len(plan.Buckets):
+		err = fmt.Errorf("the checkpoint plans %d buckets, not %d", len(plan.Buckets), bucketsN)
+	}
+	if err != nil {
+		logError("resume", "%v, remove %s to start over", err, cp.path)
+		os.Exit(1)
+	}
+
+	setOutputCount(len(plan.Buckets))
+	committed := 0
+	for i, m := range marks {
+		if m.Bytes > 0 {
+			committed++
+			logInfo("resume", "%s: written up to line %d, %s", outputPath(prefix, i), m.LastLine, displaySize(m.Bytes, FormatNumber(m.Bytes)+" bytes"))
+		}
+	}
+	logInfo("resume", "continuing %d of %d outputs from %s, %d not started", committed, len(plan.Buckets), cp.path, len(plan.Buckets)-committed)
+	writeOpts.ExpectedRecords = plan.ExpectedRecords
+	writeOpts.Checkpoint = cp
+	writeOpts.Resume = marks
+	writeOpts.RowOffsets = plan.RowOffsets
+	write(ctx, input, prefix, plan.Buckets, plan.Assign, writeOpts)
+	cp.remove()
+	fmt.Fprintf(reportOut, "Split %s into %d files with prefix %s\n", input, len(plan.Buckets), prefix)
+	return plan.Buckets
+}
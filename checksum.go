@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+var checksumAlgo string
+
+// checksumsName is the sha256sum -c compatible list of digests written next to the outputs, <prefix>.sha256sums
+const checksumsName = ".sha256sums"
+
+// outputSums collects each output's digest as it is closed with --checksum, nil otherwise
+var outputSums *checksums
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// newChecksum returns a fresh hash for a --checksum algorithm, nil for an unknown one
+func newChecksum(algo string) hash.Hash {
+	switch algo {
+	case "sha256":
+		return sha256.New()
+	case "crc32c":
+		return crc32.New(castagnoli)
+	}
+	return nil
+}
+
+// checkChecksum rejects unknown algorithms and flags that write outputs in more than one pass, whose digests would miss the bytes written before
+func checkChecksum() error {
+	if newChecksum(checksumAlgo) == nil {
+		return fmt.Errorf("unknown --checksum %q, expected sha256 or crc32c", checksumAlgo)
+	}
+	switch {
+	case appendOutputs:
+		return fmt.Errorf("--checksum cannot be combined with --append, the rows already in the outputs would have to be read back to hash them")
+	case checkpointSplit || resumeSplit:
+		return fmt.Errorf("--checksum cannot be combined with --checkpoint or --resume, a resumed output's digest would miss what the first run wrote")
+	}
+	return nil
+}
+
+// checksums holds the digest of every closed output by bucket, as "<algo>:<hex>"
+type checksums struct {
+	algo string
+	mu   sync.Mutex
+	sums map[int]string
+}
+
+func newChecksums(algo string) *checksums {
+	return &checksums{algo: algo, sums: map[int]string{}}
+}
+
+// outputs hashes every byte written to the outputs of factory, below any compression so the digest is the file's
+func (c *checksums) outputs(factory OutputFactory) OutputFactory {
+	return func(bucket int) (io.WriteCloser, error) {
+		w, err := factory(bucket)
+		if err != nil {
+			return nil, err
+		}
+		return &checksummedOutput{WriteCloser: w, h: newChecksum(c.algo), done: func(sum []byte) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.sums[bucket] = c.algo + ":" + hex.EncodeToString(sum)
+		}}, nil
+	}
+}
+
+// sum is bucket's digest, empty if its output wasn't closed
+func (c *checksums) sum(bucket int) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sums[bucket]
+}
+
+// checksummedOutput hashes the bytes on their way to an output, handing the digest to done once it is closed
+type checksummedOutput struct {
+	io.WriteCloser
+	h    hash.Hash
+	done func(sum []byte)
+}
+
+func (c *checksummedOutput) Write(p []byte) (int, error) {
+	n, err := c.WriteCloser.Write(p)
+	c.h.Write(p[:n])
+	return n, err
+}
+
+func (c *checksummedOutput) Close() error {
+	if err := c.WriteCloser.Close(); err != nil {
+		return err
+	}
+	c.done(c.h.Sum(nil))
+	return nil
+}
+
+// localOutput is the file behind an output, if it is a local one
+func localOutput(file io.WriteCloser) (*os.File, bool) {
+	if c, ok := file.(*checksummedOutput); ok {
+		file = c.WriteCloser
+	}
+	f, ok := file.(*os.File)
+	return f, ok
+}
+
+// writeChecksums lists every output's sha256 as <prefix>.sha256sums, by base name so sha256sum -c checks it from the outputs' directory
+func writeChecksums(prefix string, stats []BucketStats) error {
+	out, err := openSidecar(prefix, checksumsName)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	for i := range stats {
+		fmt.Fprintf(w, "%s  %s\n", strings.TrimPrefix(stats[i].Checksum, "sha256:"), archiveMemberName(outputPath(prefix, i)))
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	logInfo("write", "wrote checksums %s%s", prefix, checksumsName)
+	return nil
+}
+
+// fileChecksum hashes path the way --checksum hashed it while writing, for a digest recorded as "<algo>:<hex>"
+func fileChecksum(path string, recorded string) (string, error) {
+	algo, _, _ := strings.Cut(recorded, ":")
+	h := newChecksum(algo)
+	if h == nil {
+		return "", fmt.Errorf("unknown checksum algorithm in %q", recorded)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return algo + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+var outputColumns string
+
+// columnProjection picks and orders the fields of every row written to the outputs, from --columns
+type columnProjection struct {
+	specs []string
+	index []int
+}
+
+// parseColumns reads a --columns list of column names or zero-based indexes, in output order
+func parseColumns(list string, format string) (*columnProjection, error) {
+	if format == jsonlFormat {
+		return nil, fmt.Errorf("--columns only applies to csv input, a jsonl record is a single field")
+	}
+	p := &columnProjection{}
+	for _, spec := range strings.Split(list, ",") {
+		spec = strings.TrimSpace(spec)
+		switch {
+		case spec == "":
+			return nil, fmt.Errorf("--columns %q lists an empty column", list)
+		case !hasHeader(format) && !isColumnIndex(spec):
+			return nil, fmt.Errorf("without a header row there are no column names, give --columns as zero-based indexes")
+		}
+		p.specs = append(p.specs, spec)
+	}
+	return p, nil
+}
+
+// resolve looks the columns up in header, the input's first header row, or takes them as plain indexes when it is nil
+func (p *columnProjection) resolve(header []string) error {
+	p.index = p.index[:0]
+	for _, spec := range p.specs {
+		i, err := resolveColumn(header, spec)
+		if err != nil {
+			return fmt.Errorf("--columns: %w", err)
+		}
+		p.index = append(p.index, i)
+	}
+	return nil
+}
+
+// apply returns the fields of record the projection keeps, in its order. A nil projection keeps record as it is
+func (p *columnProjection) apply(record []string) []string {
+	if p == nil {
+		return record
+	}
+	out := make([]string, len(p.index))
+	for i, c := range p.index {
+		// readers that don't enforce a field count can hand over short rows, whose missing fields are written empty
+		if c < len(record) {
+			out[i] = record[c]
+		}
+	}
+	return out
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var perColumn bool
+
+// columnSamples is how many distinct values inspect --per-column keeps from each column
+const columnSamples = 3
+
+// columnStats is what inspect --per-column found in one column, gathered in constant memory as the rows stream past
+type columnStats struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"` // the first header row's name, or colN without one
+	// Values counts the rows that have this field, Empty those where it is empty
+	Values int `json:"values"`
+	Empty  int `json:"empty"`
+	// Numeric counts the non-empty values that are integers, which are summed as sizes would be
+	Numeric         int      `json:"numeric"`
+	NumericFraction float64  `json:"numeric_fraction"` // of the non-empty values
+	Min             int64    `json:"min,omitempty"`
+	Max             int64    `json:"max,omitempty"`
+	Sum             int64    `json:"sum,omitempty"`
+	SumOverflows    bool     `json:"sum_overflows,omitempty"`
+	Samples         []string `json:"samples"` // the first few distinct non-empty values
+}
+
+// columnReport gathers a columnStats for every column seen, header columns first and any that only some rows have after them
+type columnReport struct {
+	header  []string
+	columns []*columnStats
+}
+
+func (r *columnReport) add(record []string) {
+	for i, field := range record {
+		if i == len(r.columns) {
+			name := "col" + strconv.Itoa(i)
+			if i < len(r.header) {
+				name = r.header[i]
+			}
+			r.columns = append(r.columns, &columnStats{Index: i, Name: name, Samples: []string{}})
+		}
+		r.columns[i].add(field)
+	}
+}
+
+func (s *columnStats) add(field string) {
+	s.Values++
+	if field == "" {
+		s.Empty++
+		return
+	}
+	if len(s.Samples) < columnSamples && !slices.Contains(s.Samples, field) {
+		s.Samples = append(s.Samples, field)
+	}
+	n, err := strconv.ParseInt(field, 10, 64)
+	if err != nil {
+		return
+	}
+	if s.Numeric == 0 || n < s.Min {
+		s.Min = n
+	}
+	if s.Numeric == 0 || n > s.Max {
+		s.Max = n
+	}
+	s.Numeric++
+	if sum := s.Sum + n; (sum > s.Sum) != (n > 0) {
+		s.SumOverflows = true
+	} else {
+		s.Sum = sum
+	}
+}
+
+// finish fills in the fractions once every row has been added
+func (r *columnReport) finish() []columnStats {
+	stats := make([]columnStats, len(r.columns))
+	for i, s := range r.columns {
+		if values := s.Values - s.Empty; values > 0 {
+			s.NumericFraction = float64(s.Numeric) / float64(values)
+		}
+		if s.SumOverflows {
+			s.Sum = 0
+		}
+		stats[i] = *s
+	}
+	return stats
+}
+
+// printColumns writes the report as a table, with the numbers only for columns where at least one value was an integer
+func printColumns(stats []columnStats) {
+	rows := [][]string{{"column", "name", "numeric", "empty", "min", "max", "sum", "samples"}}
+	for _, s := range stats {
+		row := []string{strconv.Itoa(s.Index), s.Name, fmt.Sprintf("%.1f%%", s.NumericFraction*100), FormatNumber(int64(s.Empty)), "", "", "", ""}
+		if s.Numeric > 0 {
+			row[4], row[5], row[6] = FormatNumber(s.Min), FormatNumber(s.Max), FormatNumber(s.Sum)
+			if s.SumOverflows {
+				row[6] = "overflows"
+			}
+		}
+		samples := make([]string, len(s.Samples))
+		for i, v := range s.Samples {
+			samples[i] = strconv.Quote(truncate(v, 20))
+		}
+		row[7] = strings.Join(samples, ", ")
+		rows = append(rows, row)
+	}
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], len(cell))
+		}
+	}
+	for _, row := range rows {
+		var line strings.Builder
+		for i, cell := range row {
+			if i == len(row)-1 {
+				line.WriteString(cell)
+				break
+			}
+			line.WriteString(cell + strings.Repeat(" ", widths[i]-len(cell)+2))
+		}
+		fmt.Fprintln(reportOut, strings.TrimRight(line.String(), " "))
+	}
+}
+
+// truncate cuts s to at most n bytes, marking the cut with an ellipsis
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+)
+
+var compressOutputs bool
+
+func isGzipPath(path string) bool {
+	return strings.HasSuffix(path, ".gz")
+}
+
+// inputReader transparently decompresses f when its path ends in .gz
+func inputReader(f *os.File, path string) (io.Reader, error) {
+	if !isGzipPath(path) {
+		return f, nil
+	}
+	return gzip.NewReader(bufio.NewReader(f))
+}
+
+// gzipOutput compresses into an output, closing the gzip stream before the output so its trailer is written
+type gzipOutput struct {
+	*gzip.Writer
+	dst io.WriteCloser
+}
+
+func (g gzipOutput) Close() error {
+	err := g.Writer.Close()
+	if cerr := g.dst.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// compressedOutputs wraps every output of factory in gzip
+func compressedOutputs(factory OutputFactory) OutputFactory {
+	return func(bucket int) (io.WriteCloser, error) {
+		w, err := factory(bucket)
+		if err != nil {
+			return nil, err
+		}
+		return gzipOutput{Writer: gzip.NewWriter(w), dst: w}, nil
+	}
+}
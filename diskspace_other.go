@@ -0,0 +1,13 @@
+//go:build !(linux || darwin)
+
+package main
+
+import "errors"
+
+func diskSpace(dir string) (string, uint64, error) {
+	return "", 0, errors.New("free space check not supported on this platform")
+}
+
+func openFileLimit() (uint64, bool) {
+	return 0, false
+}
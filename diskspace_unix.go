@@ -0,0 +1,29 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// diskSpace returns an identifier for the filesystem holding dir and the bytes available on it to unprivileged users
+func diskSpace(dir string) (string, uint64, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(dir, &st); err != nil {
+		return "", 0, err
+	}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(dir, &fs); err != nil {
+		return "", 0, err
+	}
+	return fmt.Sprint(st.Dev), uint64(fs.Bavail) * uint64(fs.Bsize), nil
+}
+
+func openFileLimit() (uint64, bool) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, false
+	}
+	return uint64(rl.Cur), true
+}
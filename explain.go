@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+var explainCmd = &cobra.Command{
+	Use:   "explain <input_csv> <buckets> <line_number>",
+	Short: "Explain which bucket a data row is packed into and why",
+	Long:  "Runs the scan and binpack passes and reports, for one data row (1-based, header excluded), its size, its rank in the size-sorted order, the bucket it was placed in and the bucket loads at the moment of placement.",
+	Args:  cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		input := args[0]
+		bucketsN, err := parseBucketCount(args[1])
+		if err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		target, err := strconv.Atoi(args[2])
+		if err != nil {
+			logError("", "line number must be an integer")
+			os.Exit(1)
+		}
+		if err := checkScanFlags(); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+
+		metas := scan(cmd.Context(), input, scanOpts)
+		total := len(metas)
+
+		found := false
+		var rank, placed int
+		var size int64
+		var loads []int64
+		opts := packOpts
+		opts.Trace = func(n int, meta LineMeta, bucket int, buckets []FileBucket) {
+			if meta.LineNumber != target {
+				return
+			}
+			found = true
+			rank, placed, size = n, bucket, meta.Size
+			loads = make([]int64, len(buckets))
+			for i, b := range buckets {
+				loads[i] = b.TotalSize
+			}
+		}
+		if _, _, err := pack(cmd.Context(), metas, bucketsN, opts); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if !found {
+			logError("", "line %d was not packed, it is either out of range or its size could not be parsed", target)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Line %d: size %s, rank %s of %s by size\n", target, displaySize(size, FormatNumber(size)), FormatNumber(int64(rank+1)), FormatNumber(int64(total)))
+		fmt.Printf("Placed in bucket %d\n", placed+1)
+		fmt.Println("Bucket loads at placement:")
+		lightest := 0
+		for i := range loads {
+			if loads[i] < loads[lightest] {
+				lightest = i
+			}
+		}
+		for i, load := range loads {
+			note := ""
+			switch {
+			case i == placed && i == lightest:
+				note = " <- placed (least loaded)"
+			case i == placed:
+				note = " <- placed (lighter buckets were full on row count)"
+			case i == lightest:
+				note = " (least loaded)"
+			}
+			fmt.Printf("  bucket %d: %s%s\n", i+1, displaySize(load, FormatNumber(load)), note)
+		}
+	},
+}
+
+func init() {
+	addScanFlags(explainCmd)
+	explainCmd.Flags().Float64Var(&packOpts.MaxCountSpread, "max-count-spread", 0, "apply the same row count constraint as split")
+}
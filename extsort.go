@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"time"
+	"unsafe"
+
+	"binpacking/binpack"
+)
+
+var streamingPack bool
+var sortBuffer string
+
+// metaRunRecord is the on-disk size of a meta in a sorted run: its line number and its size
+const metaRunRecord = 16
+
+// metaBufferCost is what one meta takes up in the sort buffer
+const metaBufferCost = int64(unsafe.Sizeof(LineMeta{}))
+
+// metaSorter orders metas largest first in bounded memory: it sorts them a buffer at a time, spills each sorted run to a temp file and merges the runs back at the end
+type metaSorter struct {
+	dir     string
+	limit   int // metas held in memory before a run is spilled
+	buf     []LineMeta
+	runs    []string
+	count   int
+	maxLine int
+	total   int64
+}
+
+func newMetaSorter(bufferBytes int64) *metaSorter {
+	dir, err := os.MkdirTemp(spillDir, "binpacking-sort-*")
+	if err != nil {
+		logError("sort", "creating spill directory: %v", err)
+		exit(1)
+	}
+	s := &metaSorter{dir: dir, limit: int(max(1, bufferBytes/metaBufferCost))}
+	// runs are removed even when the scan or packing fails and exits early
+	atExit(s.cleanup)
+	return s
+}
+
+// add takes the next meta from the scan
+func (s *metaSorter) add(meta LineMeta) {
+	s.buf = append(s.buf, meta)
+	s.count++
+	s.maxLine = max(s.maxLine, meta.LineNumber)
+	s.total += meta.Size
+	if len(s.buf) >= s.limit {
+		s.spill()
+	}
+}
+
+func (s *metaSorter) spill() {
+	// the same order as an in-memory pack, which the run merge keeps, so both pack the same rows into the same buckets
+	binpack.SortLargestFirst(s.buf)
+	path := filepath.Join(s.dir, fmt.Sprintf("run-%d", len(s.runs)))
+	f, err := os.Create(path)
+	if err != nil {
+		logError("sort", "creating run: %v", err)
+		exit(1)
+	}
+	s.runs = append(s.runs, path)
+	w := bufio.NewWriter(f)
+	var rec [metaRunRecord]byte
+	for _, meta := range s.buf {
+		binary.LittleEndian.PutUint64(rec[:8], uint64(meta.LineNumber))
+		binary.LittleEndian.PutUint64(rec[8:], uint64(meta.Size))
+		w.Write(rec[:])
+	}
+	if err := w.Flush(); err != nil {
+		logError("sort", "writing run: %v", err)
+		exit(1)
+	}
+	if err := f.Close(); err != nil {
+		logError("sort", "writing run: %v", err)
+		exit(1)
+	}
+	logInfo("sort", "spilled run %d with %s rows", len(s.runs), FormatNumber(int64(len(s.buf))))
+	s.buf = s.buf[:0]
+}
+
+// runSource is one sorted run being merged, head being its next meta
+type runSource struct {
+	r    *bufio.Reader
+	f    *os.File
+	head LineMeta
+}
+
+// next advances to the run's next meta, false once the run is exhausted
+func (src *runSource) next() bool {
+	var rec [metaRunRecord]byte
+	if _, err := io.ReadFull(src.r, rec[:]); err != nil {
+		if err != io.EOF {
+			logError("sort", "reading run: %v", err)
+			exit(1)
+		}
+		src.f.Close()
+		return false
+	}
+	src.head = LineMeta{LineNumber: int(binary.LittleEndian.Uint64(rec[:8])), Size: int64(binary.LittleEndian.Uint64(rec[8:]))}
+	return true
+}
+
+// runHeap orders runs by their head meta, largest first
+type runHeap []*runSource
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(a, b int) bool {
+	if h[a].head.Size != h[b].head.Size {
+		return h[a].head.Size > h[b].head.Size
+	}
+	return h[a].head.LineNumber < h[b].head.LineNumber
+}
+func (h runHeap) Swap(a, b int) { h[a], h[b] = h[b], h[a] }
+func (h *runHeap) Push(x any)   { *h = append(*h, x.(*runSource)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// sorted yields every meta added, largest first, merging the spilled runs with whatever is still buffered
+func (s *metaSorter) sorted() iter.Seq[LineMeta] {
+	return func(yield func(LineMeta) bool) {
+		// the last partial buffer becomes one more run rather than lingering in memory next to the merge buffers
+		if len(s.buf) > 0 {
+			s.spill()
+		}
+		h := runHeap{}
+		for _, path := range s.runs {
+			f, err := os.Open(path)
+			if err != nil {
+				logError("sort", "opening run: %v", err)
+				exit(1)
+			}
+			src := &runSource{r: bufio.NewReader(f), f: f}
+			if src.next() {
+				h = append(h, src)
+			}
+		}
+		heap.Init(&h)
+		for h.Len() > 0 {
+			src := h[0]
+			if !yield(src.head) {
+				return
+			}
+			if src.next() {
+				heap.Fix(&h, 0)
+			} else {
+				heap.Pop(&h)
+			}
+		}
+	}
+}
+
+// cleanup removes every spilled run
+func (s *metaSorter) cleanup() {
+	os.RemoveAll(s.dir)
+}
+
+// checkStreamingPack rejects flags that need every row in memory and returns the sort buffer size
+func checkStreamingPack(sizeCap int64) (int64, error) {
+	switch {
+	case precomputeSizes:
+		return 0, fmt.Errorf("--streaming-pack cannot be combined with --precompute-sizes")
+	case sizeCap > 0:
+		return 0, fmt.Errorf("--streaming-pack cannot be combined with %s", capFlag)
+	case preflight:
+		return 0, fmt.Errorf("--streaming-pack cannot be combined with --preflight")
+	case scanOpts.GroupColumn != "":
+		return 0, fmt.Errorf("--streaming-pack cannot be combined with --keep-groups-together")
+	case packOpts.Strategy != "" && packOpts.Strategy != "worst-fit":
+		return 0, fmt.Errorf("--streaming-pack only supports the worst-fit strategy")
+	}
+	bufferBytes, err := ParseBytes(sortBuffer)
+	if err != nil {
+		return 0, fmt.Errorf("--sort-buffer: %w", err)
+	}
+	if bufferBytes < metaBufferCost {
+		return 0, fmt.Errorf("--sort-buffer must hold at least one row, %d bytes", metaBufferCost)
+	}
+	return bufferBytes, nil
+}
+
+// packStream packs the sorter's metas as they are merged, so they are never all in memory at once
+func packStream(ctx context.Context, s *metaSorter, bucketsN int, opts PackOptions) ([]FileBucket, Assignment, error) {
+	start := time.Now()
+	logInfo("binpack", "merging %d sorted runs of line metas...", len(s.runs)+min(1, len(s.buf)))
+	buckets, assign, err := binpack.PackStream(ctx, s.sorted(), s.count, s.maxLine, bucketsN, opts)
+	if ctx.Err() != nil {
+		exitCancelled("binpack")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	logInfo("binpack", "binpacking finished in %s", time.Since(start))
+	printBuckets(buckets)
+	printLineTotals(buckets, s.count)
+	return buckets, assign, nil
+}
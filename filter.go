@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var filterExprs []string
+
+// rejectedName is the file filtered-out rows go to with --reject-file, next to the outputs
+const rejectedName = "rejected.csv"
+
+// errFiltered marks a row the filter leaves out when records are handed on regardless
+var errFiltered = errors.New("row filtered out")
+
+// filterOps are the --filter comparisons, two-character ones first so "!=" isn't read as "!"
+var filterOps = []string{"!=", ">=", "<=", "=", ">", "<"}
+
+// rowFilter is the --filter predicates, every one of which a row must satisfy to be split
+type rowFilter struct {
+	preds []filterPred
+}
+
+// filterPred compares one column with a value, as text for = and != and as numbers otherwise
+type filterPred struct {
+	column string
+	index  int
+	op     string
+	value  string
+	number float64
+}
+
+// parseFilters compiles col=value, col!=value, col>num, col<num, col>=num and col<=num expressions. Columns are names or zero-based indexes, resolved against the header later
+func parseFilters(exprs []string) (*rowFilter, error) {
+	f := &rowFilter{}
+	for _, expr := range exprs {
+		at := strings.IndexAny(expr, "!=<>")
+		if at <= 0 {
+			return nil, fmt.Errorf("--filter %q: expected <column><op><value> with op one of %s", expr, strings.Join(filterOps, " "))
+		}
+		pred := filterPred{column: expr[:at]}
+		for _, op := range filterOps {
+			if strings.HasPrefix(expr[at:], op) {
+				pred.op = op
+				break
+			}
+		}
+		if pred.op == "" {
+			return nil, fmt.Errorf("--filter %q: expected <column><op><value> with op one of %s", expr, strings.Join(filterOps, " "))
+		}
+		pred.value = expr[at+len(pred.op):]
+		if pred.op != "=" && pred.op != "!=" {
+			n, err := strconv.ParseFloat(pred.value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("--filter %q: %s compares numbers, %q isn't one", expr, pred.op, pred.value)
+			}
+			pred.number = n
+		}
+		f.preds = append(f.preds, pred)
+	}
+	return f, nil
+}
+
+// resolve looks up every predicate's column in header. Both passes resolve against the same header, so they agree on every row
+func (f *rowFilter) resolve(header []string) error {
+	for i := range f.preds {
+		index, err := resolveColumn(header, f.preds[i].column)
+		if err != nil {
+			return fmt.Errorf("--filter: %w", err)
+		}
+		f.preds[i].index = index
+	}
+	return nil
+}
+
+// match reports whether record satisfies every predicate. A row missing a filtered column, or with a non-number where a number is compared, doesn't
+func (f *rowFilter) match(record []string) bool {
+	for _, pred := range f.preds {
+		if pred.index >= len(record) {
+			return false
+		}
+		field := record[pred.index]
+		switch pred.op {
+		case "=":
+			if field != pred.value {
+				return false
+			}
+		case "!=":
+			if field == pred.value {
+				return false
+			}
+		default:
+			n, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				return false
+			}
+			if !(pred.op == ">" && n > pred.number || pred.op == "<" && n < pred.number ||
+				pred.op == ">=" && n >= pred.number || pred.op == "<=" && n <= pred.number) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isEmptyRow reports whether every field of record is empty, as in the ,,, lines spreadsheets leave after the data
+func isEmptyRow(record []string) bool {
+	for _, field := range record {
+		if field != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// checkFilter rejects inputs and flags --filter can't be applied the same way in both passes
+func checkFilter() error {
+	switch {
+	case !hasHeader(scanOpts.Format):
+		return fmt.Errorf("--filter needs a header row, it isn't supported %s", withoutHeader(scanOpts.Format))
+	case precomputeSizes:
+		return fmt.Errorf("--filter cannot be combined with --precompute-sizes, the size cache holds every row")
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// headerRows is how many rows at the top of a csv input make up its header, copied to the top of every output. --no-header sets it to 0
+var headerRows = 1
+var noHeader bool
+
+// checkHeaderRows folds --no-header into headerRows and rejects settings that need a header row the input doesn't have
+func checkHeaderRows(opts ScanOptions) error {
+	if opts.Format == jsonlFormat && (noHeader || headerRows != 1) {
+		return fmt.Errorf("--format jsonl has no header, --header-rows and --no-header only apply to csv input")
+	}
+	if noHeader {
+		if headerRows != 1 {
+			return fmt.Errorf("--no-header cannot be combined with --header-rows, use --header-rows 0 or leave it out")
+		}
+		headerRows = 0
+	}
+	switch {
+	case headerRows < 0:
+		return fmt.Errorf("--header-rows can't be negative, got %d", headerRows)
+	case headerRows == 0 && !isColumnIndex(opts.Size.Column):
+		return fmt.Errorf("without a header row there are no column names, give --size-column as a zero-based index")
+	case headerRows == 0 && len(exprNames(opts.Size.expr)) > 0:
+		return fmt.Errorf("without a header row there are no column names, refer to columns in --size-expr as col0, col1, ...")
+	case headerRows == 0 && opts.GroupColumn != "" && !isColumnIndex(opts.GroupColumn):
+		return fmt.Errorf("without a header row there are no column names, give --keep-groups-together as a zero-based index")
+	}
+	return nil
+}
+
+// headerCount is how many header rows an input in format starts with
+func headerCount(format string) int {
+	if format == jsonlFormat {
+		return 0
+	}
+	return headerRows
+}
+
+// withoutHeader names what left the input without a header row, for errors about flags that need one
+func withoutHeader(format string) string {
+	if format == jsonlFormat {
+		return "for --format jsonl"
+	}
+	return "with --no-header"
+}
+
+// isColumnIndex reports whether a column spec is empty, meaning the default index, or a zero-based index rather than a name
+func isColumnIndex(spec string) bool {
+	if spec == "" {
+		return true
+	}
+	_, err := strconv.Atoi(spec)
+	return err == nil
+}
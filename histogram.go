@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var histogramBins int
+var histogramLog bool
+var histogramJSON bool
+
+// histogramBarWidth is the number of cells of the most populated bin's bar
+const histogramBarWidth = 40
+
+// histogramPercentiles are the ranks reported next to every histogram, by name
+var histogramPercentiles = []struct {
+	name string
+	p    float64
+}{{"p50", 0.5}, {"p90", 0.9}, {"p99", 0.99}}
+
+// SizeBin counts the rows whose size is in [From, To)
+type SizeBin struct {
+	From  int64 `json:"from"`
+	To    int64 `json:"to"`
+	Count int   `json:"count"`
+}
+
+// SizeDistribution summarises the row sizes of an input
+type SizeDistribution struct {
+	Rows        int              `json:"rows"`
+	TotalSize   int64            `json:"total_size"`
+	Min         int64            `json:"min"`
+	Max         int64            `json:"max"`
+	Mean        float64          `json:"mean"`
+	Percentiles map[string]int64 `json:"percentiles"`
+	Bins        []SizeBin        `json:"bins"`
+}
+
+var histogramCmd = &cobra.Command{
+	Use:   "histogram <input_csv>",
+	Short: "Print the distribution of row sizes in the input",
+	Long:  "Runs the scan pass and prints a histogram of row sizes with the p50, p90 and p99 sizes and the largest row, to help choose a packing strategy and bucket count. Bins are equally wide by default, or grow geometrically with --log for long-tailed sizes.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if histogramBins < 1 {
+			logError("", "--bins must be at least 1")
+			os.Exit(1)
+		}
+		var err error
+		scanOpts.Size, err = sizeSpecFromFlags(scanOpts.Size, sizeMode, sizeSource, "size")
+		if err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if err := checkBadSizePolicy(scanOpts.OnBadSize); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if err := checkFormatOptions(scanOpts); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+
+		metas := scan(cmd.Context(), args[0], scanOpts)
+		if len(metas) == 0 {
+			logError("", "no row has a readable size")
+			os.Exit(1)
+		}
+
+		sizes := make([]int64, len(metas))
+		for i, m := range metas {
+			sizes[i] = m.Size
+		}
+		d := sizeDistribution(sizes, histogramBins, histogramLog)
+		if histogramJSON {
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(d); err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+			return
+		}
+		printHistogram(cmd.OutOrStdout(), d)
+	},
+}
+
+// sizeDistribution sorts sizes and buckets them into at most bins bins, equally wide or, with logScale, geometrically growing
+func sizeDistribution(sizes []int64, bins int, logScale bool) SizeDistribution {
+	slices.Sort(sizes)
+	d := SizeDistribution{Rows: len(sizes), Min: sizes[0], Max: sizes[len(sizes)-1], Percentiles: map[string]int64{}}
+	for _, s := range sizes {
+		d.TotalSize += s
+	}
+	d.Mean = float64(d.TotalSize) / float64(len(sizes))
+	for _, p := range histogramPercentiles {
+		d.Percentiles[p.name] = percentile(sizes, p.p)
+	}
+	d.Percentiles["max"] = d.Max
+
+	edges := binEdges(d.Min, d.Max, bins, logScale)
+	i := 0
+	for b := 0; b+1 < len(edges); b++ {
+		bin := SizeBin{From: edges[b], To: edges[b+1]}
+		for i < len(sizes) && sizes[i] < bin.To {
+			bin.Count++
+			i++
+		}
+		d.Bins = append(d.Bins, bin)
+	}
+	return d
+}
+
+// binEdges splits [lo, hi] into at most bins ranges, returning their bounds. Integer sizes can't split a narrow range as many times as asked, so duplicate bounds are dropped rather than kept as empty bins
+func binEdges(lo, hi int64, bins int, logScale bool) []int64 {
+	end := hi + 1
+	edges := []int64{lo}
+	for b := 1; b < bins; b++ {
+		var edge int64
+		if logScale {
+			// geometric from the smallest positive size, so a row of size 0 shares the first bin
+			from := float64(max(lo, 1))
+			edge = int64(math.Ceil(from * math.Pow(float64(end)/from, float64(b)/float64(bins))))
+		} else {
+			edge = lo + int64(math.Ceil(float64(end-lo)*float64(b)/float64(bins)))
+		}
+		if edge > edges[len(edges)-1] && edge < end {
+			edges = append(edges, edge)
+		}
+	}
+	return append(edges, end)
+}
+
+// printHistogram draws to w one bar per bin, scaled to the most populated bin, then the percentiles
+func printHistogram(w io.Writer, d SizeDistribution) {
+	size := func(n int64) string { return displaySize(n, FormatNumber(n)) }
+	logInfo("histogram", "%s rows, total size %s, mean %s", FormatNumber(int64(d.Rows)), size(d.TotalSize), size(int64(math.Round(d.Mean))))
+
+	most := 0
+	for _, bin := range d.Bins {
+		most = max(most, bin.Count)
+	}
+	labels := make([]string, len(d.Bins))
+	width := 0
+	for i, bin := range d.Bins {
+		labels[i] = fmt.Sprintf("[%s, %s)", size(bin.From), size(bin.To))
+		width = max(width, len(labels[i]))
+	}
+	for i, bin := range d.Bins {
+		cells := 0
+		if most > 0 {
+			cells = int(math.Round(float64(bin.Count) / float64(most) * histogramBarWidth))
+		}
+		if bin.Count > 0 {
+			cells = max(cells, 1)
+		}
+		fmt.Fprintf(w, "  %-*s %-*s %s (%.1f%%)\n", width, labels[i], histogramBarWidth, strings.Repeat("#", cells),
+			FormatNumber(int64(bin.Count)), float64(bin.Count)/float64(d.Rows)*100)
+	}
+
+	var ranks []string
+	for _, p := range histogramPercentiles {
+		ranks = append(ranks, fmt.Sprintf("%s %s", p.name, size(d.Percentiles[p.name])))
+	}
+	fmt.Fprintf(w, "%s, max %s\n", strings.Join(ranks, ", "), size(d.Max))
+}
+
+func init() {
+	histogramCmd.Flags().IntVar(&histogramBins, "bins", 10, "number of histogram bins")
+	histogramCmd.Flags().BoolVar(&histogramLog, "log", false, "grow bin widths geometrically from the smallest size to the largest, for long-tailed sizes")
+	histogramCmd.Flags().BoolVar(&histogramJSON, "json", false, "print the distribution as JSON")
+	histogramCmd.Flags().StringVarP(&scanOpts.Size.Column, "size-column", "c", "", "name or zero-based index of the column holding each row's size (default 2)")
+	histogramCmd.Flags().StringVar(&scanOpts.Size.Field, "size-field", "", "dotted path to each object's size with --format jsonl, e.g. meta.bytes")
+	histogramCmd.Flags().StringVar(&scanOpts.Format, "format", "csv", "input format, one of the registered record readers")
+	histogramCmd.Flags().StringVar(&sizeMode, "size-mode", "absolute", "absolute reads the size column as bytes, relative as decimal weights such as percentages")
+	histogramCmd.Flags().StringVar(&sizeSource, "size", "column", "column reads each row's size from the size column, bytes measures the row as written to the output")
+	histogramCmd.Flags().StringVar(&scanOpts.OnBadSize, "on-bad-size", badSizeSkip, "what to do with a row whose size can't be read: skip leaves it out, fail aborts, zero counts it as size 0")
+}
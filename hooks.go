@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// hookRunner runs the --after-write-hook command for finished bucket files, at most a fixed number at a time
+type hookRunner struct {
+	template string
+	slots    chan struct{}
+	wg       sync.WaitGroup
+	results  []hookResult
+}
+
+type hookResult struct {
+	command  string
+	exitCode int
+	err      error
+	output   []byte
+}
+
+func newHookRunner(template string, buckets int, concurrency int) *hookRunner {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &hookRunner{template: template, slots: make(chan struct{}, concurrency), results: make([]hookResult, buckets)}
+}
+
+// expandHook fills {file}, {bucket} and {size} in the template, shell quoting each value
+func expandHook(template string, file string, bucket int, size int64) string {
+	return strings.NewReplacer(
+		"{file}", shellQuote(file),
+		"{bucket}", strconv.Itoa(bucket+1),
+		"{size}", strconv.FormatInt(size, 10),
+	).Replace(template)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// start runs the hook for one bucket in the background once a slot is free
+func (h *hookRunner) start(bucket int, file string, size int64) {
+	command := expandHook(h.template, file, bucket, size)
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		h.slots <- struct{}{}
+		defer func() { <-h.slots }()
+
+		output, err := exec.Command("sh", "-c", command).CombinedOutput()
+		result := hookResult{command: command, err: err, output: output}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			result.exitCode = exitErr.ExitCode()
+		} else if err != nil {
+			result.exitCode = -1
+		}
+		h.results[bucket] = result
+	}()
+}
+
+// wait blocks until every hook has finished, reports each bucket's result and returns how many failed
+func (h *hookRunner) wait(prefix string) int {
+	h.wg.Wait()
+	failed := 0
+	for i, r := range h.results {
+		if r.err == nil {
+			logInfo("hook", "%s: ok", outputPath(prefix, i))
+			continue
+		}
+		failed++
+		logInfo("hook", "%s: failed with exit code %d: %v", outputPath(prefix, i), r.exitCode, r.err)
+		if out := strings.TrimSpace(string(r.output)); out != "" {
+			logInfo("hook", "  %s", strings.ReplaceAll(out, "\n", "\n[hook]   "))
+		}
+	}
+	return failed
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// jsonlFormat reads JSON Lines: one object per line, no header. Each record is the line itself as a single field, so outputs get the input's lines back unchanged
+const jsonlFormat = "jsonl"
+
+func init() {
+	RegisterFormat(jsonlFormat, newJSONLRecordReader)
+}
+
+// RecordWriter writes records to an output file in its format. *csv.Writer is one
+type RecordWriter interface {
+	Write(record []string) error
+	Flush()
+	Error() error
+}
+
+// hasHeader reports whether the first record of format is a header row rather than data
+func hasHeader(format string) bool {
+	return headerCount(format) > 0
+}
+
+// outputFormat is the format split writes for input in format, which is also the output files' extension. Custom formats are written as csv
+func outputFormat(format string) string {
+	if format == jsonlFormat {
+		return jsonlFormat
+	}
+	return "csv"
+}
+
+// newRecordWriter writes records in outputFormat(format)
+func newRecordWriter(format string, w io.Writer) RecordWriter {
+	if outputFormat(format) == jsonlFormat {
+		return &jsonlWriter{w: bufio.NewWriter(w)}
+	}
+	return newCSVWriter(w)
+}
+
+// checkFormatOptions rejects size and grouping settings the input format can't honour
+func checkFormatOptions(opts ScanOptions) error {
+	if opts.Format != jsonlFormat {
+		if opts.Size.Field != "" {
+			return fmt.Errorf("--size-field only applies to --format jsonl, use --size-column for %s", opts.Format)
+		}
+		return nil
+	}
+	switch {
+	case opts.Size.Column != "":
+		return fmt.Errorf("--format jsonl reads sizes with --size-field, not --size-column")
+	case opts.Size.Expr != "":
+		return fmt.Errorf("--size-expr only applies to csv input, --format jsonl reads sizes with --size-field")
+	case opts.GroupColumn != "":
+		return fmt.Errorf("--keep-groups-together needs a header row, it isn't supported for --format jsonl")
+	case opts.SkipEmptyRows:
+		return fmt.Errorf("--skip-empty-rows only applies to csv input, a jsonl record is a single field")
+	case opts.Size.Field != "" && (opts.Size.Bytes || opts.Size.Lines):
+		return fmt.Errorf("--size-field cannot be combined with --size bytes or --by lines, which don't read a size field")
+	case opts.Size.Field == "" && !opts.Size.Bytes && !opts.Size.Lines:
+		return fmt.Errorf("--format jsonl needs --size-field <path> or --size bytes")
+	}
+	return nil
+}
+
+type jsonlRecordReader struct {
+	r    *bufio.Reader
+	size SizeSpec
+	path []string
+}
+
+func newJSONLRecordReader(r io.Reader, size SizeSpec) RecordReader {
+	j := &jsonlRecordReader{r: bufio.NewReader(r), size: size}
+	if size.Field != "" {
+		j.path = strings.Split(size.Field, ".")
+	}
+	return j
+}
+
+// Read returns the next non-blank line as a one-field record
+func (j *jsonlRecordReader) Read() ([]string, int64, error) {
+	for {
+		line, err := j.r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil, 0, io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		record := []string{line}
+		size, err := j.parse(line)
+		if err != nil {
+			return record, 0, fmt.Errorf("%w: %v", ErrBadSize, err)
+		}
+		return record, size, nil
+	}
+}
+
+// parse measures one line as the size spec says
+func (j *jsonlRecordReader) parse(line string) (int64, error) {
+	switch {
+	case j.size.Lines:
+		return 1, nil
+	case j.size.Bytes:
+		// jsonlWriter writes the line back with a single newline
+		return int64(len(line)) + 1, nil
+	case j.path == nil:
+		return 0, fmt.Errorf("no --size-field to read the size from")
+	}
+	v, err := jsonField(line, j.path)
+	if err != nil {
+		return 0, err
+	}
+	if !j.size.Relative {
+		return strconv.ParseInt(v.String(), 10, 64)
+	}
+	weight, err := v.Float64()
+	if err != nil {
+		return 0, err
+	}
+	if weight < 0 || math.IsInf(weight, 0) {
+		return 0, fmt.Errorf("relative weight %s must be a non-negative number", v)
+	}
+	return int64(math.Round(weight * relativeScale)), nil
+}
+
+// jsonField follows path through nested objects in line and returns the number it ends at
+func jsonField(line string, path []string) (json.Number, error) {
+	v, err := jsonValue(line, path)
+	if err != nil {
+		return "", err
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return "", fmt.Errorf("field %s is not a number", strings.Join(path, "."))
+	}
+	return n, nil
+}
+
+// jsonValue follows path through nested objects in line and returns the value it ends at, numbers as json.Number
+func jsonValue(line string, path []string) (any, error) {
+	d := json.NewDecoder(strings.NewReader(line))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return "", fmt.Errorf("invalid JSON: %v", err)
+	}
+	if d.More() {
+		return "", fmt.Errorf("invalid JSON: more than one value on the line")
+	}
+	for i, key := range path {
+		obj, ok := v.(map[string]any)
+		if !ok && i == 0 {
+			return "", fmt.Errorf("line is not a JSON object")
+		}
+		if !ok {
+			return "", fmt.Errorf("%s is not an object", strings.Join(path[:i], "."))
+		}
+		if v, ok = obj[key]; !ok {
+			return "", fmt.Errorf("no field %s", strings.Join(path[:i+1], "."))
+		}
+	}
+	return v, nil
+}
+
+// jsonlWriter writes each record's single field as one line
+type jsonlWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (j *jsonlWriter) Write(record []string) error {
+	if j.err != nil {
+		return j.err
+	}
+	if len(record) != 1 {
+		j.err = fmt.Errorf("jsonl records hold a single line, got %d fields", len(record))
+		return j.err
+	}
+	if _, err := j.w.WriteString(record[0]); err != nil {
+		j.err = err
+		return err
+	}
+	if err := j.w.WriteByte('\n'); err != nil {
+		j.err = err
+	}
+	return j.err
+}
+
+func (j *jsonlWriter) Flush() {
+	if err := j.w.Flush(); err != nil && j.err == nil {
+		j.err = err
+	}
+}
+
+func (j *jsonlWriter) Error() error {
+	return j.err
+}
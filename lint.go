@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// lintMaxReports caps how many individual problems are printed so a badly broken file doesn't flood the terminal, the summary still counts all of them
+const lintMaxReports = 20
+
+var lintWarnOnly bool
+var lintValidateUTF8 bool
+
+var lintCmd = &cobra.Command{
+	Use:   "lint <input_csv>",
+	Short: "Check the input CSV for structural problems without splitting it",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		problems := lint(args[0])
+		if problems > 0 && !lintWarnOnly {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	lintCmd.Flags().BoolVar(&lintWarnOnly, "warn-only", false, "report problems but exit zero")
+	lintCmd.Flags().BoolVar(&lintValidateUTF8, "validate-utf8", false, "also report fields containing invalid UTF-8")
+}
+
+// lineCounter counts newlines as they stream past so trailing blank lines, which csv.Reader drops silently, can still be detected
+type lineCounter struct {
+	r        io.Reader
+	newlines int
+	last     byte
+}
+
+func (c *lineCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.newlines += bytes.Count(p[:n], []byte{'\n'})
+		c.last = p[n-1]
+	}
+	return n, err
+}
+
+func lint(filename string) int {
+	logInfo("lint", "checking file structure...")
+	f, err := os.Open(filename)
+	if err != nil {
+		logError("", "opening file: %v", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	in, err := inputReader(f, filename)
+	if err != nil {
+		logError("", "opening file: %v", err)
+		os.Exit(1)
+	}
+
+	counter := &lineCounter{r: in}
+	br := bufio.NewReader(counter)
+	r := newCSVReader(br)
+	r.FieldsPerRecord = -1 // we check field counts ourselves so a mismatch doesn't stop the read
+
+	problems := 0
+	report := func(format string, a ...any) {
+		problems++
+		if problems <= lintMaxReports {
+			logWarn("lint", format, a...)
+		} else if problems == lintMaxReports+1 {
+			logWarn("lint", "too many problems, only counting from here on...")
+		}
+	}
+
+	hasBOM := false
+	if b, _ := br.Peek(len(utf8BOM)); bytes.Equal(b, utf8BOM) {
+		hasBOM = true
+		report("file starts with a UTF-8 BOM, the first header field will include it")
+	}
+
+	expectedFields := -1
+	records := 0
+	fieldMismatches, badSizes, blankLines, invalidUTF8 := 0, 0, 0, 0
+	prevEnd := 0 // last physical line consumed by the previous record
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			report("line %d: %v", parseErr.StartLine, parseErr.Err)
+			prevEnd = parseErr.Line
+			continue
+		}
+		if err != nil {
+			logError("", "reading file: %v", err)
+			os.Exit(1)
+		}
+
+		line, _ := r.FieldPos(0)
+		if line > prevEnd+1 {
+			blankLines += line - prevEnd - 1
+			report("%s: blank", lineRange(prevEnd+1, line-1))
+		}
+		last := len(record) - 1
+		lastLine, _ := r.FieldPos(last)
+		prevEnd = lastLine + strings.Count(record[last], "\n")
+
+		if expectedFields < 0 {
+			expectedFields = len(record)
+			continue
+		}
+		records++
+
+		if len(record) != expectedFields {
+			fieldMismatches++
+			report("line %d: expected %d fields, got %d", line, expectedFields, len(record))
+		}
+		if _, err := parseSize(record); err != nil {
+			badSizes++
+			report("line %d: bad size value: %v", line, err)
+		}
+		if lintValidateUTF8 {
+			if field := invalidUTF8Field(record); field >= 0 {
+				invalidUTF8++
+				report("line %d: invalid UTF-8 in field %d", line, field)
+			}
+		}
+	}
+
+	totalLines := counter.newlines
+	if counter.last != '\n' && counter.last != 0 {
+		totalLines++
+	}
+	if totalLines > prevEnd {
+		blankLines += totalLines - prevEnd
+		report("%s: blank", lineRange(prevEnd+1, totalLines))
+	}
+
+	logInfo("lint", "data records: %s, fields per record: %s", FormatNumber(int64(records)), FormatNumber(int64(expectedFields)))
+	logInfo("lint", "field count mismatches: %s", FormatNumber(int64(fieldMismatches)))
+	logInfo("lint", "bad size values: %s", FormatNumber(int64(badSizes)))
+	logInfo("lint", "blank lines: %s", FormatNumber(int64(blankLines)))
+	if lintValidateUTF8 {
+		logInfo("lint", "rows with invalid UTF-8: %s", FormatNumber(int64(invalidUTF8)))
+	}
+	logInfo("lint", "BOM: %t", hasBOM)
+	if problems == 0 {
+		logInfo("lint", "no problems found")
+	} else {
+		logWarn("lint", "%s problems found", FormatNumber(int64(problems)))
+	}
+	return problems
+}
+
+func lineRange(from, to int) string {
+	if from == to {
+		return fmt.Sprintf("line %d", from)
+	}
+	return fmt.Sprintf("lines %d-%d", from, to)
+}
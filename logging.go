@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var logLevelName string
+var logFormat string
+
+// logLevels are the --log-level values
+var logLevels = map[string]slog.Level{
+	"error": slog.LevelError,
+	"warn":  slog.LevelWarn,
+	"info":  slog.LevelInfo,
+	"debug": slog.LevelDebug,
+}
+
+// logContext is the running command's context, which every log record is handled with
+var logContext = context.Background()
+
+func init() {
+	slog.SetDefault(slog.New(&phaseHandler{level: slog.LevelInfo, out: os.Stderr, mu: &sync.Mutex{}}))
+}
+
+// setupLogging sends every log record of the command running with ctx to w at --log-level and above, as the usual text lines or, with --log-format json, one JSON object each
+func setupLogging(ctx context.Context, w io.Writer, levelName string, format string) error {
+	level, ok := logLevels[levelName]
+	if !ok {
+		return fmt.Errorf("unknown --log-level %q, expected error, warn, info or debug", levelName)
+	}
+	switch format {
+	case "text":
+		slog.SetDefault(slog.New(&phaseHandler{level: level, out: w, mu: &sync.Mutex{}}))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})))
+	default:
+		return fmt.Errorf("unknown --log-format %q, expected text or json", format)
+	}
+	logContext = ctx
+	if level > slog.LevelInfo {
+		// progress reports are info chatter too
+		progressMode = "off"
+	}
+	return nil
+}
+
+// logAt logs a message formatted like fmt.Printf, with the phase that logged it, such as "write", as a field. An empty phase adds none
+func logAt(level slog.Level, phase string, format string, args ...any) {
+	ctx := logContext
+	if !slog.Default().Enabled(ctx, level) {
+		return
+	}
+	var attrs []any
+	if phase != "" {
+		attrs = append(attrs, slog.String("phase", phase))
+	}
+	slog.Log(ctx, level, fmt.Sprintf(format, args...), attrs...)
+}
+
+// logDebug is for figures only worth seeing when tracking a problem down
+func logDebug(phase string, format string, args ...any) {
+	logAt(slog.LevelDebug, phase, format, args...)
+}
+
+// logInfo is for a phase's progress and findings
+func logInfo(phase string, format string, args ...any) {
+	logAt(slog.LevelInfo, phase, format, args...)
+}
+
+// logWarn is for something the run carries on past but the user should look at
+func logWarn(phase string, format string, args ...any) {
+	logAt(slog.LevelWarn, phase, format, args...)
+}
+
+// logError is for what stops the run, or marks its result unusable
+func logError(phase string, format string, args ...any) {
+	logAt(slog.LevelError, phase, format, args...)
+}
+
+// phaseHandler writes records as the lines the commands have always printed: "[phase] message", after "Error: " or "Warning: " at those levels,
+// and any other fields as key=value
+type phaseHandler struct {
+	level slog.Level
+	out   io.Writer
+	mu    *sync.Mutex // shared by the handlers WithAttrs derives, so lines from writer routines don't interleave
+	attrs []slog.Attr
+}
+
+func (h *phaseHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *phaseHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	switch {
+	case r.Level >= slog.LevelError:
+		b.WriteString("Error: ")
+	case r.Level >= slog.LevelWarn:
+		b.WriteString("Warning: ")
+	}
+	var rest []slog.Attr
+	phase := func(a slog.Attr) bool {
+		if a.Key == "phase" {
+			b.WriteString("[" + a.Value.String() + "] ")
+		} else {
+			rest = append(rest, a)
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		phase(a)
+	}
+	r.Attrs(phase)
+	b.WriteString(r.Message)
+	for _, a := range rest {
+		fmt.Fprintf(&b, " %s=%v", a.Key, a.Value)
+	}
+	b.WriteByte('\n')
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.out, b.String())
+	return err
+}
+
+func (h *phaseHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &phaseHandler{level: h.level, out: h.out, mu: h.mu, attrs: append(slices.Clip(h.attrs), attrs...)}
+}
+
+// WithGroup is a no-op, the commands log flat fields only
+func (h *phaseHandler) WithGroup(string) slog.Handler {
+	return h
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+// restoreLogging puts the default logging setup back once a test that changes it is done
+func restoreLogging(t testing.TB) {
+	progress := progressMode
+	t.Cleanup(func() {
+		setupLogging(context.Background(), os.Stderr, "info", "text")
+		progressMode = progress
+	})
+}
+
+func TestLogLevelSuppressesLowerLevels(t *testing.T) {
+	restoreLogging(t)
+	for _, tc := range []struct {
+		level string
+		want  string
+	}{
+		{"debug", "[p] d\n[p] i\nWarning: [p] w\nError: [p] e\n"},
+		{"info", "[p] i\nWarning: [p] w\nError: [p] e\n"},
+		{"warn", "Warning: [p] w\nError: [p] e\n"},
+		{"error", "Error: [p] e\n"},
+	} {
+		var out bytes.Buffer
+		if err := setupLogging(context.Background(), &out, tc.level, "text"); err != nil {
+			t.Fatal(err)
+		}
+		logDebug("p", "d")
+		logInfo("p", "i")
+		logWarn("p", "w")
+		logError("p", "e")
+		if out.String() != tc.want {
+			t.Errorf("--log-level %s logged %q, want %q", tc.level, out.String(), tc.want)
+		}
+	}
+}
+
+func TestLogLevelSuppressesLowerLevelsAsJSON(t *testing.T) {
+	restoreLogging(t)
+	var out bytes.Buffer
+	if err := setupLogging(context.Background(), &out, "warn", "json"); err != nil {
+		t.Fatal(err)
+	}
+	logInfo("write", "left out")
+	logWarn("write", "kept %d", 1)
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("--log-level warn logged %q, want the warning alone", out.String())
+	}
+	var record struct {
+		Level string `json:"level"`
+		Msg   string `json:"msg"`
+		Phase string `json:"phase"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Level != "WARN" || record.Msg != "kept 1" || record.Phase != "write" {
+		t.Errorf("got record %+v, want the warning with its phase", record)
+	}
+}
+
+// capturingHandler keeps every record it is handed at level and above, with the context it was logged with
+type capturingHandler struct {
+	level   slog.Level
+	records []slog.Record
+	ctxs    []context.Context
+}
+
+func (h *capturingHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *capturingHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.records = append(h.records, r)
+	h.ctxs = append(h.ctxs, ctx)
+	return nil
+}
+
+func (h *capturingHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *capturingHandler) WithGroup(string) slog.Handler      { return h }
+
+// commandKey marks the context a command runs with
+type commandKey struct{}
+
+func TestLogsCarryCommandContext(t *testing.T) {
+	restoreLogging(t)
+	ctx := context.WithValue(context.Background(), commandKey{}, "split")
+	if err := setupLogging(ctx, os.Stderr, "warn", "text"); err != nil {
+		t.Fatal(err)
+	}
+	h := &capturingHandler{level: slog.LevelWarn}
+	slog.SetDefault(slog.New(h))
+	logInfo("scan", "left out")
+	logWarn("scan", "kept")
+	if len(h.records) != 1 || h.records[0].Message != "kept" {
+		t.Fatalf("handler got %d records, want the warning alone", len(h.records))
+	}
+	if got := h.ctxs[0].Value(commandKey{}); got != "split" {
+		t.Errorf("record was logged with a context carrying %v, want the command's", got)
+	}
+}
@@ -2,256 +2,1865 @@ package main
 
 import (
 	"bufio"
-	"encoding/csv"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
+	"unsafe"
+
+	"binpacking/binpack"
 
 	"github.com/spf13/cobra"
 )
 
 // Due to extremely large file size, we are going to load the line metas separately in memory to perform greedy binpacking sorting, and then later based on this linemeta we will do another pass to stream our input and then stream to an output based on sorted line metas
 
-type LineMeta struct {
-	LineNumber int
-	Size 			 int64
+// the packing types live in the binpack package, aliased so the CLI code reads the same
+type (
+	LineMeta    = binpack.LineMeta
+	FileBucket  = binpack.FileBucket
+	Assignment  = binpack.Assignment
+	PackOptions = binpack.Options
+)
+
+var packOpts PackOptions
+
+// ScanOptions controls how scan reads the input
+type ScanOptions struct {
+	// GroupColumn names (or indexes) a column whose rows must all land in the same bucket. Empty disables grouping
+	GroupColumn string
+	// Format names the registered RecordReader used to parse the input
+	Format string
+	// Size says how each record's size is measured
+	Size SizeSpec
+	// ValidateUTF8 reports rows with fields that aren't valid UTF-8
+	ValidateUTF8 bool
+	// MaxErrors aborts the scan once this many rows could not be used. Zero means unlimited
+	MaxErrors int
+	// Mmap parses csv input straight from a memory mapping of the file instead of through bufio and csv.Reader
+	Mmap bool
+	// OnBadSize is what happens to a row whose size can't be read: badSizeSkip, badSizeFail or badSizeZero. Empty means skip
+	OnBadSize string
+	// RowOffsets, when set and the reader knows its offsets, receives the byte offset every record starts at, indexed by line number: the header at 0, then each data row, then the end of the input
+	RowOffsets *[]int64
+	// GroupKeys, when set, receives the key of every group, indexed by group id with "" for id 0
+	GroupKeys *[]string
+	// Filter, when set, leaves every row that doesn't match it out of the scan, as if it weren't in the input
+	Filter *rowFilter
+	// Threads scans a large csv file as this many byte ranges at once. One or less scans serially
+	Threads int
+	// SkipEmptyRows leaves rows whose fields are all empty out of the scan. They keep their line numbers, so write can tell them apart
+	SkipEmptyRows bool
+}
+
+// the --on-bad-size policies
+const (
+	badSizeSkip = "skip"
+	badSizeFail = "fail"
+	badSizeZero = "zero"
+)
+
+// checkBadSizePolicy rejects unknown --on-bad-size values
+func checkBadSizePolicy(policy string) error {
+	switch policy {
+	case "", badSizeSkip, badSizeFail, badSizeZero:
+		return nil
+	}
+	return fmt.Errorf("unknown --on-bad-size %q, expected skip, fail or zero", policy)
 }
 
-type FileBucket struct {
-	TotalSize int64
-	LineNums 	map[int]struct{}
+var scanOpts ScanOptions
+
+// WriteOptions controls what write records alongside the output files
+type WriteOptions struct {
+	// ContentHash computes an order-independent hash of each bucket's rows, stable across re-runs that produce the same rows in any order
+	ContentHash bool
+	// RowGroupSize flushes each output every this many data rows and records the byte offset where each row group starts. Zero disables it
+	RowGroupSize int
+	// FixUTF8 replaces invalid UTF-8 sequences in every field with the replacement character
+	FixUTF8 bool
+	// CountWritten reports the bytes actually written per bucket next to its logical size
+	CountWritten bool
+	// AfterWriteHook is a shell command run for every bucket file once it is closed, with {file}, {bucket} and {size} substituted. Empty disables it
+	AfterWriteHook string
+	// HookConcurrency caps how many hooks run at once
+	HookConcurrency int
+	// IgnoreHookErrors reports failing hooks without failing the run
+	IgnoreHookErrors bool
+	// Preallocate reserves each local output's projected size up front and truncates it to the bytes written at close
+	Preallocate bool
+	// Format must match the one scan used so line numbers line up
+	Format string
+	// Size is the scan's SizeSpec, which tells the rows the scan left out for a bad size apart from rows missing from the assignment
+	Size SizeSpec
+	// ExpectedRecords is the highest data line scan saw. write fails if the input turns out shorter, since that means it changed between passes
+	ExpectedRecords int
+	// InputStat is the input's stat from before the scan, compared against the file again before writing
+	InputStat os.FileInfo
+	// PreserveOrder checks that every output lists its rows in ascending input line order and fails the run otherwise
+	PreserveOrder bool
+	// Checkpoint, when set, logs each output's watermark every checkpointEvery rows so a failed run can be resumed
+	Checkpoint *checkpoint
+	// ChannelBuffer is how many records each bucket's channel holds while its writer catches up
+	ChannelBuffer int
+	// RowOffsets is where every record of the input starts, as scan's ScanOptions.RowOffsets recorded it. When set and few enough rows need writing, write seeks past the rest instead of parsing them
+	RowOffsets []int64
+	// Resume is every bucket's last watermark from the checkpoint of an earlier run. Outputs are cut back to it and the rows it covers are not written again
+	Resume []watermark
+	// Filter is the scan's ScanOptions.Filter, telling the rows it left out apart from rows with a bad size
+	Filter *rowFilter
+	// RejectFile writes the rows Filter left out to <prefix>rejected.csv
+	RejectFile bool
+	// Oversized is the ascending line numbers of the rows no bucket had room for under --max-bucket-size, written to <prefix>oversized.csv when not nil
+	Oversized []int
+	// Append, when set, adds the rows to the outputs of an earlier split instead of creating them
+	Append *appendTarget
+	// Columns, when set, writes only these fields of every row and header row, in its order. Packing still uses the rows' full sizes
+	Columns *columnProjection
+	// Strict fails the run, once the outputs are written, if any input row was skipped or the rows routed don't add up to the assignment
+	Strict bool
+	// SkipEmptyRows is the scan's ScanOptions.SkipEmptyRows, passing over the rows it left out without warning about them
+	SkipEmptyRows bool
+	// MaxOpenFiles, when below the bucket count, writes the outputs from this many writers and keeps no more than this many of them open. Zero means no limit
+	MaxOpenFiles int
 }
 
+var writeOpts WriteOptions
+
 var rootCmd = &cobra.Command{
 	Use: 	"binpacking",
 	Short: "Split a large CSV file into smaller files based on line size",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if err := setupLogging(cmd.Context(), cmd.ErrOrStderr(), logLevelName, logFormat); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		d, err := parseDelimiter(delimiter)
+		if err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		csvDelimiter = d
+		if err := checkNameTemplate(nameTemplate); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if err := checkProgressMode(progressMode); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if err := checkNumberSeparator(numberSeparatorName); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+	},
 }
 
 var splitCmd = &cobra.Command{
 	Use:   "split <input_csv> <buckets> <output_prefix>",
 	Short: "Split the input CSV file into smaller files",
-	Args:  cobra.ExactArgs(3),
+	Long:  "Split the input CSV file into <buckets> files of balanced total size. With --target-size the <buckets> argument is left out and the bucket count is derived from the cap: split <input_csv> <output_prefix> --target-size 500MB",
+	Args:  cobra.RangeArgs(2, 3),
 	Run: func(cmd *cobra.Command, args []string) {
+		start := time.Now()
+		if err := checkOutputMode(outputMode); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		stdout := summaryStdout(cmd)
 		input := args[0]
-		bucketsN, err := strconv.Atoi(args[1])
+		prefix := args[len(args)-1]
+		if outputDir != "" {
+			dirPrefix, err := inOutputDir(prefix)
+			if err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+			prefix = dirPrefix
+		}
+		var bucketsN int
+		var sizeCap int64
+		var err error
+		if maxLines != 0 {
+			// a row cap is a size cap with every row weighing 1
+			capFlag = "--max-lines"
+			if cmd.Flags().Changed("by") && splitBy != "lines" {
+				logError("", "--max-lines caps row counts, it needs --by lines")
+				os.Exit(1)
+			}
+			splitBy = "lines"
+		}
+		if targetSize != "" && maxLines != 0 {
+			err = fmt.Errorf("--target-size and --max-lines are two different caps, pass one of them")
+		} else if targetSize != "" || maxLines != 0 {
+			if len(args) != 2 {
+				logError("", "%s replaces the <buckets> argument, expected <input_csv> <output_prefix>", capFlag)
+				os.Exit(1)
+			}
+			if maxLines != 0 {
+				sizeCap = int64(maxLines)
+				if sizeCap < 1 {
+					err = fmt.Errorf("--max-lines must be at least 1")
+				}
+			} else {
+				sizeCap, err = ParseBytes(targetSize)
+				if err == nil && sizeCap < 1 {
+					err = fmt.Errorf("--target-size must be at least 1 byte")
+				}
+			}
+		} else if len(args) != 3 {
+			err = fmt.Errorf("expected <input_csv> <buckets> <output_prefix>, or --target-size or --max-lines instead of <buckets>")
+		} else {
+			bucketsN, err = parseBucketCount(args[1])
+		}
 		if err != nil {
-			fmt.Println("Error: buckets must be an integer")
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if dryRun && checkOutputs {
+			logError("", "--dry-run cannot be combined with --check-outputs, which creates and removes probe files")
+			os.Exit(1)
+		}
+		if scanOpts.Threads < 1 {
+			logError("", "--threads must be at least 1")
+			os.Exit(1)
+		}
+		if writeOpts.ChannelBuffer < 0 {
+			logError("", "--channel-buffer can't be negative")
+			os.Exit(1)
+		}
+		if compressOutputs && writeOpts.RowGroupSize > 0 {
+			logError("", "--row-group-size offsets can't be used to seek into --compress outputs")
+			os.Exit(1)
+		}
+		if scanOpts.GroupColumn != "" && packOpts.MaxCountSpread > 0 {
+			logError("", "--max-count-spread cannot be combined with --keep-groups-together")
 			os.Exit(1)
 		}
-		prefix := args[2]
-		metas := scan(input)
-		buckets := binpack(metas, bucketsN)
-		write(input, prefix, buckets)
-		fmt.Printf("Split %s into %d files with prefix %s\n", input, bucketsN, prefix)
+		scanOpts.Size, err = sizeSpecFromFlags(scanOpts.Size, sizeMode, sizeSource, splitBy)
+		if err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		writeOpts.Size = scanOpts.Size
+		if err := checkBadSizePolicy(scanOpts.OnBadSize); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if err := checkFormatOptions(scanOpts); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if err := checkHeaderRows(scanOpts); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if scanOpts.Size.Relative && sizeCap > 0 {
+			logError("", "--target-size cannot be combined with --size-mode relative")
+			os.Exit(1)
+		}
+		if err := binpack.CheckStrategy(packOpts); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		sortKey, err := bucketSortKey(sortOutputBy)
+		if err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		writeOpts.Format = scanOpts.Format
+		if seekIndex {
+			if err := checkSeekIndex(input); err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+			scanOpts.RowOffsets = &writeOpts.RowOffsets
+		}
+		if len(filterExprs) > 0 {
+			if err := checkFilter(); err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+			if scanOpts.Filter, err = parseFilters(filterExprs); err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+			writeOpts.Filter = scanOpts.Filter
+		} else if writeOpts.RejectFile {
+			logError("", "--reject-file needs at least one --filter")
+			os.Exit(1)
+		}
+		if scanOpts.SkipEmptyRows {
+			if precomputeSizes {
+				logError("", "--skip-empty-rows cannot be combined with --precompute-sizes, the size cache holds every row")
+				os.Exit(1)
+			}
+			writeOpts.SkipEmptyRows = true
+		}
+		if writeOpts.MaxOpenFiles < 0 {
+			logError("", "--max-open-files must be 0 for no limit, or a positive number of files")
+			os.Exit(1)
+		}
+		if writeOpts.MaxOpenFiles > 0 && writeOpts.Preallocate {
+			logError("", "--preallocate cannot be combined with --max-open-files, a reopened file would be appended to past its reserved size")
+			os.Exit(1)
+		}
+		var partitionKeys []string
+		if partitionBy != "" {
+			if err := checkPartition(sizeCap); err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+			scanOpts.GroupColumn = partitionBy
+			scanOpts.GroupKeys = &partitionKeys
+		}
+		// with --relax buckets the cap is packed to like a --target-size, with <buckets> as the fewest to use
+		requestedBuckets := bucketsN
+		if relaxConstraint != "" && maxBucketSize == "" {
+			logError("", "--relax needs --max-bucket-size, the cap it relaxes the bucket count for")
+			os.Exit(1)
+		}
+		if maxBucketSize != "" {
+			relaxed, err := checkBucketCap(sizeCap)
+			if err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+			sizeCap = max(sizeCap, relaxed)
+		}
+		if appendOutputs {
+			if err := checkAppend(prefix, sizeCap); err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+		}
+		if outputColumns != "" {
+			if writeOpts.Columns, err = parseColumns(outputColumns, scanOpts.Format); err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+			// the write pass resolves them again, but a typo is cheaper to catch before the scan
+			if input != stdinInput && hasHeader(scanOpts.Format) {
+				header, err := readHeader(input, 1)
+				if err == nil {
+					err = writeOpts.Columns.resolve(header[0])
+				}
+				if err != nil {
+					logError("", "%v", err)
+					os.Exit(1)
+				}
+			}
+		}
+		if scanOpts.Size.Expr != "" && input != stdinInput {
+			// columns the expression names must exist before a scan that would find every row bad. Without a header the first row gives the column count
+			first, err := readHeader(input, 1)
+			if err == nil {
+				_, err = scanOpts.Size.Resolve(first[0])
+			}
+			if err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+		}
+		if checksumAlgo != "" {
+			if err := checkChecksum(); err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+		}
+		if archiveOutputs {
+			if err := checkArchive(prefix); err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+		}
+		if checkpointSplit || resumeSplit {
+			if err := checkCheckpoint(input, prefix); err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+		}
+		if resumeSplit && overwriteOutputs {
+			logError("", "--resume cannot be combined with --overwrite, it carries on with the outputs it finds")
+			os.Exit(1)
+		}
+		if resumeSplit {
+			// a cap decides the bucket count, which --relax buckets may have raised past <buckets>
+			planned := bucketsN
+			if sizeCap > 0 {
+				planned = 0
+			}
+			buckets := resume(cmd.Context(), input, prefix, planned)
+			if stdout != nil {
+				splitSummary(input, prefix, buckets, 0).print(stdout, start)
+			}
+			return
+		}
+		if bucketsN > 0 && !appendOutputs && !overwriteOutputs && !dryRun {
+			// packing can still change the count, this only saves scanning the input to find the outputs are in the way
+			setOutputCount(bucketsN)
+			if err := checkOverwrite(prefix, bucketsN, writeOpts); err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+		}
+		var sorter *metaSorter
+		if streamingPack {
+			bufferBytes, err := checkStreamingPack(sizeCap)
+			if err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+			sorter = newMetaSorter(bufferBytes)
+			defer sorter.cleanup()
+		}
+		// the write pass reads the input again, from the spill file when the input is stdin
+		source := input
+		var metas []LineMeta
+		scanStart := time.Now()
+		if input == stdinInput {
+			if precomputeSizes {
+				logError("", "--precompute-sizes needs an input file, not stdin")
+				os.Exit(1)
+			}
+			if sorter != nil {
+				_, source = scanStdinTo(cmd.Context(), scanOpts, sorter.add)
+			} else {
+				metas, source = scanStdin(cmd.Context(), scanOpts)
+			}
+			defer os.Remove(source)
+		} else {
+			writeOpts.InputStat, err = os.Stat(input)
+			if err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+			if precomputeSizes {
+				metas = scanCached(cmd.Context(), input, writeOpts.InputStat, scanOpts)
+			} else if sorter != nil {
+				scanTo(cmd.Context(), input, scanOpts, sorter.add)
+			} else {
+				metas = scan(cmd.Context(), input, scanOpts)
+			}
+		}
+		scanTime := time.Since(scanStart)
+		for _, meta := range metas {
+			writeOpts.ExpectedRecords = max(writeOpts.ExpectedRecords, meta.LineNumber)
+		}
+		if sorter != nil {
+			writeOpts.ExpectedRecords = sorter.maxLine
+		}
+		if (writeOpts.Filter != nil || writeOpts.SkipEmptyRows) && writeOpts.RowOffsets != nil {
+			// a seeking write pass stops after the last expected row, which must be the input's last so trailing filtered or empty rows are read too
+			writeOpts.ExpectedRecords = len(writeOpts.RowOffsets) - 2
+		}
+		if sizeCap > 0 {
+			fewest, err := bucketsForCap(metas, sizeCap)
+			if err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+			bucketsN = max(bucketsN, fewest)
+			logInfo("target size", "starting with %d buckets", bucketsN)
+		}
+		scannedRows := len(metas)
+		if sorter != nil {
+			scannedRows = sorter.count
+		}
+		if appendOutputs {
+			if writeOpts.Append, err = existingSplit(prefix, bucketsN, writeOpts.ContentHash); err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+			packOpts.Initial = writeOpts.Append.Buckets
+		} else if err := checkEmptyBuckets(bucketsN, scannedRows, allowEmptyBuckets); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if preflight {
+			printPreflight(metas, bucketsN)
+			if !confirmPreflight() {
+				fmt.Fprintln(reportOut, "Aborted, nothing written")
+				return
+			}
+		}
+		if scanOpts.Size.Relative && sorter != nil {
+			checkRelativeTotal(sorter.total)
+		} else if scanOpts.Size.Relative {
+			checkRelativeWeights(metas)
+		}
+		packStart := time.Now()
+		var buckets []FileBucket
+		var assign Assignment
+		if partitionBy != "" {
+			buckets, assign = partition(cmd.Context(), metas, partitionKeys, bucketsN)
+		} else if sizeCap > 0 {
+			buckets, assign, err = packToCap(cmd.Context(), metas, bucketsN, sizeCap, packOpts)
+		} else if sorter != nil {
+			buckets, assign, err = packStream(cmd.Context(), sorter, bucketsN, packOpts)
+			sorter.cleanup()
+		} else {
+			buckets, assign, err = pack(cmd.Context(), metas, bucketsN, packOpts)
+		}
+		if err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if packOpts.MaxBucketSize > 0 {
+			printOverflow(prefix, bucketsN, buckets, writeOpts.Oversized)
+		}
+		if relaxConstraint != "" {
+			printRelaxed(requestedBuckets, buckets)
+		} else if bucketCap > 0 && overflowMode == overflowFail {
+			if err := checkUnderCap(buckets); err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+		}
+		packTime := time.Since(packStart)
+		bucketsN = len(buckets)
+		setOutputCount(bucketsN)
+		summary := splitSummary(input, prefix, buckets, scannedRows)
+		summary.time("scan", scanTime)
+		summary.time("pack", packTime)
+		if scanOpts.Size.Relative {
+			printBucketShares(buckets)
+		}
+		if sizeCap > 0 {
+			printCapReport(buckets, sizeCap)
+		}
+		if sortKey != nil {
+			sortBuckets(buckets, assign, sortKey)
+			logInfo("binpack", "output files ordered by %s, largest first", sortOutputBy)
+		}
+		if checkOutputs && isS3Prefix(prefix) {
+			logError("", "--check-outputs only supports local output paths")
+			os.Exit(1)
+		}
+		if dryRun && appendOutputs {
+			logInfo("dry run", "scan took %s, a full run then reads the input a second time to append it", scanTime)
+			logInfo("dry run", "nothing written")
+			return
+		}
+		if dryRun {
+			if isS3Prefix(prefix) {
+				logInfo("dry run", "existing S3 objects are not checked")
+			}
+			existing := existingOutputs(prefix, len(buckets), writeOpts)
+			for _, path := range existing {
+				logInfo("dry run", "%s already exists", path)
+			}
+			stale, err := staleOutputs(prefix, len(buckets))
+			if err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+			for _, path := range stale {
+				logInfo("dry run", "%s is from an earlier split, --overwrite would remove it", path)
+			}
+			logInfo("dry run", "scan took %s, a full run then reads the input a second time to write it", scanTime)
+			if len(existing) > 0 {
+				logWarn("dry run", "%d existing files would be overwritten, which needs --overwrite", len(existing))
+			}
+			logInfo("dry run", "nothing written")
+			if stdout != nil {
+				summary.DryRun = true
+				summary.print(stdout, start)
+			}
+			return
+		}
+		if outputDir != "" {
+			// the stem's own directories too, for a prefix like run/
+			if err := os.MkdirAll(filepath.Dir(prefix), 0o755); err != nil {
+				logError("", "creating --output-dir: %v", err)
+				os.Exit(1)
+			}
+		}
+		if checkOutputs {
+			if problems := checkOutputPaths(prefix, buckets); problems > 0 {
+				logError("", "%d problems found with output paths", problems)
+				os.Exit(1)
+			}
+			fmt.Fprintln(reportOut, "Output paths OK, nothing written")
+			return
+		}
+		if !appendOutputs {
+			if err := checkOverwrite(prefix, bucketsN, writeOpts); err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+		}
+		if checkpointSplit {
+			plan := splitPlan{Fingerprint: newSizeFingerprint(writeOpts.InputStat, scanOpts), NameTemplate: nameTemplate, Columns: outputColumns, ExpectedRecords: writeOpts.ExpectedRecords, ContentHash: writeOpts.ContentHash, Buckets: buckets, Assign: assign, RowOffsets: writeOpts.RowOffsets}
+			if writeOpts.Checkpoint, err = createCheckpoint(prefix, plan); err != nil {
+				logError("checkpoint", "writing the plan: %v", err)
+				os.Exit(1)
+			}
+			logInfo("checkpoint", "plan saved in %s", writeOpts.Checkpoint.path)
+		}
+		writeStart := time.Now()
+		write(cmd.Context(), source, prefix, buckets, assign, writeOpts)
+		if outputArchive != nil {
+			if err := outputArchive.finish(); err != nil {
+				logError("", "writing archive: %v", err)
+				os.Exit(1)
+			}
+		}
+		if writeOpts.Checkpoint != nil {
+			writeOpts.Checkpoint.remove()
+		}
+		fmt.Fprintf(reportOut, "Split %s into %d files with prefix %s\n", input, bucketsN, prefix)
+		if stdout != nil {
+			summary.time("write", time.Since(writeStart))
+			summary.print(stdout, start)
+		}
 	},
 }
 
+var checkOutputs bool
+var dryRun bool
+var humanSizes, rawBytes bool
+var numberSeparatorName string
+var delimiter string
+var sizeMode string
+var sizeSource string
+var splitBy string
+var precomputeSizes bool
+var partialColumnsOK bool
+var sortOutputBy string
+var quietBuckets bool
+var allowEmptyBuckets bool
+
 var inspectCmd = &cobra.Command{
 	Use: "inspect <input_csv>",
 	Short: "Print the number of entries and total size of the input CSV file",
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		start := time.Now()
+		if err := checkOutputMode(outputMode); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		stdout := summaryStdout(cmd)
 		input := args[0]
 		f, err := os.Open(input)
 		if err != nil {
-			fmt.Println("Error opening file:", err)
+			logError("", "opening file: %v", err)
 			os.Exit(1)
 		}
 		defer f.Close()
+		in, err := inputReader(f, input)
+		if err != nil {
+			logError("", "opening file: %v", err)
+			os.Exit(1)
+		}
 
-		r := csv.NewReader(bufio.NewReader(f))
+		r := newCSVReader(bufio.NewReader(in))
 		lineCount := 0
+		shortRows := 0
 		totalSize := int64(0)
+		scanErrs := newScanErrors(scanOpts.MaxErrors)
+		if partialColumnsOK || perColumn {
+			r.FieldsPerRecord = -1
+		}
 
-		r.Read()
+		if err := checkHeaderRows(ScanOptions{Format: "csv", Size: scanOpts.Size}); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		var header []string
+		for i := 0; i < headerRows; i++ {
+			record, err := r.Read()
+			if err != nil {
+				logError("", "reading header: %v", err)
+				os.Exit(1)
+			}
+			if i == 0 {
+				header = record
+			}
+		}
+		// --per-column looks at every column instead of taking one as the size
+		var columns *columnReport
+		col := -1
+		if perColumn {
+			columns = &columnReport{header: header}
+		} else {
+			size, err := scanOpts.Size.Resolve(header)
+			if err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+			col = size.column()
+		}
 
+		prog := newProgress("[inspect]", 0)
 		for {
+			prog.update(lineCount)
 			record, err := r.Read()
-			if err != nil {
+			if err == io.EOF {
 				break
 			}
+			if err != nil {
+				logError("", "reading data row %d: %v", lineCount+1, err)
+				os.Exit(1)
+			}
 			lineCount++
-			size, err := strconv.Atoi(record[2])
+			if columns != nil {
+				columns.add(record)
+				continue
+			}
+			if partialColumnsOK && len(record) <= col {
+				// counted as size 0 so a partially corrupt file can still be sized up
+				logWarn("", "line %d has only %d fields, counting it as size 0", lineCount, len(record))
+				shortRows++
+				continue
+			}
+			size, err := strconv.Atoi(record[col])
 			if err != nil {
-				fmt.Printf("Error parsing size for line %d: %v\n", lineCount, err)
+				logError("", "parsing size for line %d: %v", lineCount, err)
+				scanErrs.add(lineCount)
 				continue
 			}
 			totalSize += int64(size)
 
-			if lineCount % 1000000 == 0 {
-				fmt.Printf("Processed %d lines...\n", lineCount)
+		}
+		prog.done()
+
+		if columns != nil {
+			stats := columns.finish()
+			fmt.Fprintf(reportOut, "Total lines: %s, columns: %d\n", FormatNumber(int64(lineCount)), len(stats))
+			printColumns(stats)
+			if stdout != nil {
+				summary := &runSummary{Command: "inspect", Input: input, Rows: lineCount, Columns: stats, Timing: map[string]float64{}}
+				summary.print(stdout, start)
+			}
+			return
+		}
+		fmt.Fprintf(reportOut, "Total lines: %s, Total size: %s\n", FormatNumber(int64(lineCount)), displaySize(totalSize, fmt.Sprintf("%s bytes (%s)", FormatNumber(totalSize), FormatBytes(totalSize))))
+		if partialColumnsOK {
+			fmt.Fprintf(reportOut, "Short rows: %s\n", FormatNumber(int64(shortRows)))
+		}
+		if stdout != nil {
+			summary := &runSummary{Command: "inspect", Input: input, Rows: lineCount, TotalSize: totalSize, ShortRows: shortRows, Timing: map[string]float64{}}
+			summary.print(stdout, start)
+		}
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&humanSizes, "human", false, "print every size in human-readable units (KB, MB, GB, ...)")
+	rootCmd.PersistentFlags().BoolVar(&rawBytes, "bytes", false, "print every size as a raw byte count, for scripting")
+	rootCmd.MarkFlagsMutuallyExclusive("human", "bytes")
+	rootCmd.PersistentFlags().StringVar(&numberSeparatorName, "number-separator", "comma", "what separates the digit groups of large numbers in reports: comma, space, underscore or none")
+	rootCmd.PersistentFlags().StringVar(&logLevelName, "log-level", "info", "least severe log messages shown on stderr: error, warn, info or debug")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "text prints log messages as [phase] lines, json as one object each with the phase as a field")
+	rootCmd.PersistentFlags().StringVar(&progressMode, "progress", "plain", "progress reports on stderr every few seconds: off, plain lines, or a bar redrawn in place")
+	rootCmd.PersistentFlags().StringVar(&nameTemplate, "name-template", "{prefix}{index}.{ext}", "output file names, from {prefix}, the zero-padded bucket number {index} and {ext}, csv or jsonl")
+	rootCmd.PersistentFlags().BoolVar(&noPadIndex, "no-pad-index", false, "don't zero-pad {index}, naming outputs prefix1.csv ... prefix10.csv as before")
+	rootCmd.PersistentFlags().StringVar(&delimiter, "delimiter", ",", `field delimiter for every CSV read and written, e.g. "\t", "|" or ";"`)
+	splitCmd.Flags().StringVar(&scanOpts.Size.Expr, "size-expr", "", `compute each row's size from several columns, e.g. "col2 + 8*col4" or "bytes + 8*attachments", with integers, + - * and parentheses`)
+	splitCmd.Flags().StringVar(&outputMode, "output", "text", "text prints the usual logs, json prints them on stderr and a single JSON summary of the run on stdout")
+	inspectCmd.Flags().StringVar(&outputMode, "output", "text", "text prints the usual report, json prints it on stderr and a single JSON summary on stdout")
+	splitCmd.Flags().StringVar(&scanOpts.Format, "format", "csv", "input format, one of the registered record readers")
+	splitCmd.Flags().StringVar(&sizeMode, "size-mode", "absolute", "absolute reads the size column as bytes, relative as decimal weights such as percentages")
+	splitCmd.Flags().StringVar(&sizeSource, "size", "column", "column reads each row's size from the size column, bytes measures the row as written to the output")
+	splitCmd.Flags().StringVar(&splitBy, "by", "size", "size balances the outputs by row size, lines by row count alone")
+	splitCmd.Flags().IntVar(&maxLines, "max-lines", 0, "derive the bucket count so no output has more than this many rows, instead of passing <buckets>; implies --by lines")
+	splitCmd.Flags().StringVar(&scanOpts.GroupColumn, "keep-groups-together", "", "column (name or index) whose rows are packed as one atomic group")
+	splitCmd.Flags().BoolVar(&streamingPack, "streaming-pack", false, "sort row sizes with an external merge sort through temp files, so memory is bounded by --sort-buffer")
+	splitCmd.Flags().StringVar(&sortBuffer, "sort-buffer", "256MB", "memory for row sizes per sorted run with --streaming-pack")
+	splitCmd.Flags().BoolVar(&precomputeSizes, "precompute-sizes", false, "cache row sizes in <input>.sizes and reuse them on later runs while the input is unchanged")
+	splitCmd.Flags().StringVar(&maxBucketSize, "max-bucket-size", "", "hard cap on every bucket's total size, e.g. 500MB; rows that fit nowhere are handled as --overflow says")
+	splitCmd.Flags().StringVar(&overflowMode, "overflow", overflowFile, "with --max-bucket-size, file writes rows no bucket has room for to <prefix>oversized.csv, bucket adds buckets for them instead, fail stops if any bucket would go over")
+	splitCmd.Flags().StringVar(&relaxConstraint, "relax", "", "with --max-bucket-size, buckets packs into as few more than <buckets> as keep every bucket under the cap")
+	splitCmd.Flags().BoolVar(&appendOutputs, "append", false, "add the input's rows to the outputs of an earlier split with the same prefix and bucket count, packing them around the rows those already hold")
+	splitCmd.Flags().StringArrayVar(&filterExprs, "filter", nil, "only split rows matching col=value, col!=value, col>num, col<num, col>=num or col<=num; repeat to require several")
+	splitCmd.Flags().BoolVar(&writeOpts.RejectFile, "reject-file", false, "write the rows --filter leaves out to <prefix>rejected.csv")
+	splitCmd.Flags().StringVar(&partitionBy, "partition-by", "", "column (name or index) to hash-partition on instead of packing by size: every row goes to bucket hash(key) % buckets")
+	splitCmd.Flags().StringVar(&packOpts.Strategy, "strategy", "worst-fit", "packing strategy, one of the registered packers: "+strings.Join(binpack.Packers(), ", ")+" (kk is Karmarkar-Karp)")
+	splitCmd.Flags().StringVar(&targetSize, "target-size", "", "derive the bucket count so no bucket exceeds this size, e.g. 500MB or 2GB, instead of passing <buckets>")
+	splitCmd.Flags().StringVarP(&scanOpts.Size.Column, "size-column", "c", "", "name or zero-based index of the column holding each row's size (default 2)")
+	splitCmd.Flags().StringVar(&scanOpts.Size.Field, "size-field", "", "dotted path to each object's size with --format jsonl, e.g. meta.bytes")
+	inspectCmd.Flags().StringVarP(&scanOpts.Size.Column, "size-column", "c", "", "name or zero-based index of the column holding each row's size (default 2)")
+	splitCmd.Flags().StringVar(&scanOpts.OnBadSize, "on-bad-size", badSizeSkip, "what to do with a row whose size can't be read: skip leaves it out of every output, fail aborts, zero packs it as size 0")
+	splitCmd.Flags().IntVar(&scanOpts.MaxErrors, "max-scan-errors", 0, "abort the scan once this many rows fail to parse, 0 for unlimited")
+	inspectCmd.Flags().IntVar(&headerRows, "header-rows", 1, "number of header rows at the top of the input")
+	inspectCmd.Flags().BoolVar(&noHeader, "no-header", false, "the input has no header row, same as --header-rows 0")
+	inspectCmd.Flags().BoolVar(&partialColumnsOK, "partial-columns-ok", false, "count rows missing the size column as size 0 instead of stopping")
+	inspectCmd.Flags().BoolVar(&perColumn, "per-column", false, "report, for every column, how much of it is numeric with its min, max and sum, to pick a --size-column")
+	inspectCmd.Flags().IntVar(&scanOpts.MaxErrors, "max-scan-errors", 0, "abort once this many rows fail to parse, 0 for unlimited")
+	splitCmd.Flags().Float64Var(&packOpts.MaxCountSpread, "max-count-spread", 0, "keep every bucket's row count within this fraction of the mean (e.g. 0.05 for ±5%), 0 to disable")
+	splitCmd.Flags().StringVar(&s3Region, "s3-region", "", "AWS region for s3:// output prefixes, defaults to the region from the AWS config chain")
+	splitCmd.Flags().BoolVar(&writeOpts.ContentHash, "content-hash", false, "report an order-independent hash of each bucket's rows for change detection")
+	splitCmd.Flags().StringVar(&sortOutputBy, "sort-output-by", "none", "number output files by bucket size or count, largest first (size|count|none)")
+	splitCmd.Flags().IntVar(&writeOpts.RowGroupSize, "row-group-size", 0, "flush outputs every N data rows and report the byte offset of each row group")
+	splitCmd.Flags().BoolVar(&scanOpts.ValidateUTF8, "validate-utf8", false, "report rows containing invalid UTF-8")
+	splitCmd.Flags().IntVar(&headerRows, "header-rows", 1, "number of header rows at the top of a csv input, copied to every output")
+	splitCmd.Flags().BoolVar(&noHeader, "no-header", false, "the csv input has no header row, same as --header-rows 0")
+	splitCmd.Flags().BoolVar(&scanOpts.Mmap, "mmap", false, "memory map the input for the scan pass instead of buffered reads")
+	splitCmd.Flags().IntVar(&scanOpts.Threads, "threads", runtime.GOMAXPROCS(0), "scan a csv input file of 64MB or more as this many byte ranges in parallel, 1 scans serially")
+	splitCmd.Flags().BoolVar(&writeOpts.FixUTF8, "fix-utf8", false, "replace invalid UTF-8 bytes with U+FFFD in the outputs")
+	splitCmd.Flags().BoolVar(&writeOpts.PreserveOrder, "preserve-order", false, "check that every output lists its rows in ascending input line order, failing the run otherwise")
+	splitCmd.Flags().BoolVar(&writeOpts.CountWritten, "count-bytes-as-written", false, "report the bytes actually written per bucket next to its logical size")
+	splitCmd.Flags().StringVar(&writeOpts.AfterWriteHook, "after-write-hook", "", "shell command run for each finished bucket file, with {file}, {bucket} and {size} substituted")
+	splitCmd.Flags().IntVar(&writeOpts.ChannelBuffer, "channel-buffer", defaultChannelBuffer, "records queued per bucket for its writer, each slot takes 32 bytes up front plus the row it holds")
+	splitCmd.Flags().IntVar(&writeOpts.HookConcurrency, "hook-concurrency", 4, "maximum after-write hooks running at once")
+	splitCmd.Flags().BoolVar(&writeOpts.IgnoreHookErrors, "ignore-hook-errors", false, "report failing after-write hooks without failing the run")
+	splitCmd.Flags().StringVar(&spillDir, "spill-dir", "", "directory for the copy of stdin kept for the write pass, the system temp dir by default")
+	splitCmd.Flags().BoolVar(&compressOutputs, "compress", false, "gzip every output file, named <prefix>N.csv.gz")
+	splitCmd.Flags().BoolVar(&writeOpts.Preallocate, "preallocate", false, "reserve each output file's projected size before writing to reduce fragmentation")
+	splitCmd.Flags().IntVar(&writeOpts.MaxOpenFiles, "max-open-files", 0, "with more buckets than this, write them from this many writers that close and reopen the output files so no more are open at once, 0 for no limit")
+	splitCmd.Flags().IntVar(&s3MaxConcurrentParts, "max-concurrent-part-uploads", 0, "maximum S3 part uploads in flight across all buckets, 0 for unlimited; every bucket's multipart upload stays open until the split ends either way")
+	splitCmd.Flags().BoolVar(&quietBuckets, "quiet", false, "leave out the per-bucket lines after packing, keeping the balance summary")
+	splitCmd.Flags().BoolVar(&preflight, "preflight", false, "print a summary of the scanned input and projected bucket sizes, and ask before packing and writing")
+	splitCmd.Flags().BoolVar(&assumeYes, "yes", false, "don't ask for confirmation after --preflight")
+	splitCmd.Flags().BoolVar(&allowEmptyBuckets, "allow-empty-buckets", true, "allow more buckets than data rows, writing header-only outputs with a warning; false makes it an error")
+	splitCmd.SetFlagErrorFunc(bucketCountFlagError)
+	splitCmd.Flags().StringVar(&outputDir, "output-dir", "", "write the outputs and their manifest and other files into this directory, created if missing, with <output_prefix> as the file name stem")
+	splitCmd.Flags().BoolVar(&overwriteOutputs, "overwrite", false, "replace output files left by an earlier split instead of stopping, and remove its bucket files this split doesn't write")
+	splitCmd.Flags().BoolVar(&dryRun, "dry-run", false, "scan and pack, report the buckets and any existing output files, then exit without writing")
+	splitCmd.Flags().StringVar(&outputColumns, "columns", "", "comma-separated names or zero-based indexes of the columns to write, in output order; packing still uses the full rows' sizes")
+	splitCmd.Flags().BoolVar(&lenientFields, "lenient", false, "pad CSV rows with fewer fields than the header with empty ones and truncate those with more, with a warning count, instead of stopping at the first")
+	splitCmd.Flags().BoolVar(&scanOpts.SkipEmptyRows, "skip-empty-rows", false, "leave rows whose fields are all empty, such as a spreadsheet's trailing ,,, lines, out of every output")
+	splitCmd.Flags().BoolVar(&writeOpts.Strict, "strict", false, "fail the run after writing if any input row was in no bucket, rather than only warning about it")
+	splitCmd.Flags().StringVar(&checksumAlgo, "checksum", "", "hash every output file as it is written, sha256 or crc32c, recording the digests in the manifest and, for sha256, in <prefix>.sha256sums")
+	splitCmd.Flags().BoolVar(&archiveOutputs, "archive", false, "write every output and the manifest as members of one <prefix>.tar instead of separate files")
+	splitCmd.Flags().BoolVar(&seekIndex, "seek-index", false, "record every row's byte offset in the scan, so the write pass can seek past rows it doesn't need to write, such as those a --resume already wrote")
+	splitCmd.Flags().BoolVar(&checkpointSplit, "checkpoint", false, "save the plan and each output's progress in <prefix>split.checkpoint so a failed or interrupted split can be resumed")
+	splitCmd.Flags().BoolVar(&resumeSplit, "resume", false, "continue a split from its checkpoint instead of starting over, with the same arguments as the first run")
+	splitCmd.Flags().BoolVar(&checkOutputs, "check-outputs", false, "after packing, verify every output file can be created and fits on disk, then exit without writing")
+}
+
+func main() {
+	rootCmd.AddCommand(splitCmd)
+	rootCmd.AddCommand(inspectCmd)
+	rootCmd.AddCommand(lintCmd)
+	rootCmd.AddCommand(explainCmd)
+	rootCmd.AddCommand(mergeSortedCmd)
+	rootCmd.AddCommand(splitOnChangeCmd)
+	rootCmd.AddCommand(splitRatioCmd)
+	rootCmd.AddCommand(reportSkewCmd)
+	rootCmd.AddCommand(histogramCmd)
+	rootCmd.AddCommand(mergeCmd)
+	rootCmd.AddCommand(sampleCmd)
+	rootCmd.AddCommand(rebalanceCmd)
+	rootCmd.AddCommand(verifyCmd)
+
+	if err := rootCmd.ExecuteContext(signalContext()); err != nil {
+		logError("", "%v", err)
+		os.Exit(1)
+	}
+}
+
+func scan(ctx context.Context, filename string, opts ScanOptions) []LineMeta {
+	return scanTo(ctx, filename, opts, nil)
+}
+
+// scanTo is scan handing each row's meta to emit instead of collecting them, unless emit is nil
+func scanTo(ctx context.Context, filename string, opts ScanOptions, emit func(LineMeta)) []LineMeta {
+	start := time.Now()
+	logInfo("meta scan", "scanning file for line sizes...")
+	f, err := os.Open(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	if canScanParallel(f, filename, opts, emit) {
+		if metas, ok := scanParallel(ctx, f, opts, start); ok {
+			return metas
+		}
+	}
+	in, err := inputReader(f, filename)
+	if err != nil {
+		logError("", "%v", err)
+		os.Exit(1)
+	}
+
+	var r RecordReader
+	if opts.Mmap && isGzipPath(filename) {
+		logInfo("meta scan", "--mmap can't read compressed input, reading it normally")
+	} else if opts.Mmap && opts.Format == "csv" {
+		mr, err := newMmapRecordReader(f, opts.Size)
+		if err != nil {
+			logInfo("meta scan", "mmap unavailable (%v), falling back to buffered reads", err)
+		} else {
+			defer mr.Close()
+			mr.sizeOnly = sizeOnlyScan(opts)
+			r = mr
+		}
+	} else if opts.Mmap {
+		logInfo("meta scan", "--mmap only applies to csv input, reading %s normally", opts.Format)
+	}
+	if r == nil {
+		r, err = newRecordReader(opts.Format, in, opts.Size)
+		if err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+	}
+	return scanRecordsTo(ctx, r, opts, start, emit)
+}
+
+// scanRecords collects the size of every data row r yields, header first
+func scanRecords(ctx context.Context, r RecordReader, opts ScanOptions, start time.Time) []LineMeta {
+	return scanRecordsTo(ctx, r, opts, start, nil)
+}
+
+// scanRecordsTo is scanRecords handing each row's meta to emit instead of collecting them, unless emit is nil
+func scanRecordsTo(ctx context.Context, r RecordReader, opts ScanOptions, start time.Time, emit func(LineMeta)) []LineMeta {
+	// collecting here rather than in an emit closure keeps metas off the heap: a closure's copy is write-barriered
+	// on every append, so each time the slice grows during a GC the old backing array survives that cycle too
+	metas := []LineMeta{}
+	scanned, highest := 0, 0
+	line := 0
+	var offsets []int64
+	offsetsOf, _ := r.(offsetReader)
+	if opts.RowOffsets == nil {
+		offsetsOf = nil
+	}
+
+	// Skip header, the first of its rows names the columns
+	var header []string
+	if offsetsOf != nil {
+		// without a header, offset 0 is also where data row 1 starts
+		offsets = append(offsets, offsetsOf.InputOffset())
+	}
+	for i := 0; i < headerCount(opts.Format); i++ {
+		record, _, err := r.Read()
+		if err != nil && !errors.Is(err, ErrBadSize) {
+			logError("", "reading header: %v", err)
+			exit(1)
+		}
+		if i == 0 {
+			header = record
+		}
+	}
+
+	line++
+
+	rows, err := newRowScan(opts, header)
+	if err != nil {
+		logError("", "%v", err)
+		exit(1)
+	}
+	rep := newScanReport(opts)
+	rows.report = rep.report
+	prog := newProgress("[meta scan]", 0)
+	for {
+		if line%cancelCheckEvery == 0 && ctx.Err() != nil {
+			exitCancelled("meta scan")
+		}
+		prog.update(line)
+		if offsetsOf != nil {
+			offsets = append(offsets, offsetsOf.InputOffset())
+		}
+		record, size, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && !errors.Is(err, ErrBadSize) {
+			logError("meta scan", "reading data row %d: %v", line, err)
+			exit(1)
+		}
+		meta, ok := rows.add(line, record, size, err)
+		line++
+		if !ok {
+			continue
+		}
+
+		if emit != nil {
+			emit(meta)
+		} else {
+			metas = append(metas, meta)
+		}
+		scanned++
+		highest = meta.LineNumber
+
+	}
+	prog.done()
+	if offsetsOf != nil {
+		*opts.RowOffsets = offsets
+	}
+	if opts.GroupKeys != nil {
+		*opts.GroupKeys = rows.groupNames
+	}
+	rows.fitted = fittedRows(r)
+	rows.printSummary(rep, start, scanned, highest, line)
+	return metas
+}
+
+// rowScan turns the records a scan reads into metas, handing what it finds wrong with a row to report
+type rowScan struct {
+	opts        ScanOptions
+	groupColumn int
+	groupIDs    map[string]int
+	groupNames  []string // indexed by group id, id 0 means ungrouped
+	groupSizes  []int64
+	filtered    int
+	empty       int
+	zeroed      int
+	fitted      int // rows --lenient padded or truncated, counted by the reader
+	report      func(scanEvent)
+}
+
+// newRowScan resolves the filter and group columns against header
+func newRowScan(opts ScanOptions, header []string) (*rowScan, error) {
+	s := &rowScan{opts: opts, groupColumn: -1, groupIDs: map[string]int{}, groupNames: []string{""}, groupSizes: []int64{0}}
+	if opts.Filter != nil {
+		if err := opts.Filter.resolve(header); err != nil {
+			return nil, err
+		}
+	}
+	if opts.GroupColumn != "" {
+		var err error
+		if s.groupColumn, err = resolveColumn(header, opts.GroupColumn); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// add handles the record read as line, whose size failed to parse if err is set, returning its meta and whether it is packed
+func (s *rowScan) add(line int, record []string, size int64, err error) (LineMeta, bool) {
+	if s.opts.SkipEmptyRows && isEmptyRow(record) {
+		s.empty++
+		return LineMeta{}, false
+	}
+	if s.opts.Filter != nil && !s.opts.Filter.match(record) {
+		s.filtered++
+		return LineMeta{}, false
+	}
+	if err != nil {
+		s.report(scanEvent{kind: eventBadSize, line: line, record: record, err: err})
+		if s.opts.OnBadSize != badSizeZero {
+			return LineMeta{}, false
+		}
+		// packed like any other row, so it still lands in exactly one output
+		s.zeroed++
+		size = 0
+	}
+
+	if s.opts.ValidateUTF8 {
+		if field := invalidUTF8Field(record); field >= 0 {
+			s.report(scanEvent{kind: eventInvalidUTF8, line: line, field: field})
+		}
+	}
+
+	meta := LineMeta{LineNumber: line, Size: size}
+	if s.groupColumn >= 0 {
+		if s.groupColumn >= len(record) {
+			s.report(scanEvent{kind: eventShortGroup, line: line, field: len(record)})
+			return LineMeta{}, false
+		}
+		meta.Group = s.group(record[s.groupColumn])
+		s.groupSizes[meta.Group] += size
+	}
+	return meta, true
+}
+
+// group is the id of the group called key, numbered in the order groups are first seen
+func (s *rowScan) group(key string) int {
+	id, ok := s.groupIDs[key]
+	if !ok {
+		id = len(s.groupNames)
+		s.groupIDs[key] = id
+		s.groupNames = append(s.groupNames, key)
+		s.groupSizes = append(s.groupSizes, 0)
+	}
+	return id
+}
+
+// printSummary reports a finished scan that read lines lines, header included
+func (s *rowScan) printSummary(rep *scanReport, start time.Time, scanned, highest, line int) {
+	end := time.Now()
+	logInfo("meta scan", "scan finished %s lines in %s", FormatNumber(int64(scanned)), end.Sub(start))
+	logInfo("meta scan", "parse errors: %s", FormatNumber(int64(rep.errs.count+s.zeroed)))
+	if s.opts.Filter != nil {
+		logInfo("meta scan", "rows filtered out: %s", FormatNumber(int64(s.filtered)))
+	}
+	if s.opts.SkipEmptyRows {
+		logInfo("meta scan", "empty rows skipped: %s", FormatNumber(int64(s.empty)))
+	}
+	if s.fitted > 0 {
+		logWarn("meta scan", "rows padded or truncated to the header's field count: %s", FormatNumber(int64(s.fitted)))
+	}
+	if s.opts.OnBadSize == badSizeZero {
+		logInfo("meta scan", "rows with a bad size packed as size 0: %s", FormatNumber(int64(s.zeroed)))
+	}
+	if s.opts.ValidateUTF8 {
+		logInfo("meta scan", "rows with invalid UTF-8: %s", FormatNumber(int64(rep.invalidUTF8)))
+	}
+	logInfo("meta scan", "highest line number: %s", FormatNumber(int64(highest)))
+	if hasHeader(s.opts.Format) {
+		logInfo("meta scan", "total lines processed (including header): %s", FormatNumber(int64(line-1+headerCount(s.opts.Format))))
+	} else {
+		logInfo("meta scan", "total lines processed: %s", FormatNumber(int64(line-1)))
+	}
+	if s.groupColumn >= 0 {
+		largest := 1
+		for id := range s.groupSizes {
+			if s.groupSizes[id] > s.groupSizes[largest] {
+				largest = id
 			}
 		}
-
-		fmt.Printf("Total lines: %d, Total size: %sMB\n", lineCount, FormatNumber(totalSize / (1024 * 1024)))
-	},
+		logInfo("meta scan", "groups: %s, largest group: %q with size %s", FormatNumber(int64(len(s.groupNames)-1)), s.groupNames[largest], displaySize(s.groupSizes[largest], FormatNumber(s.groupSizes[largest])))
+	}
+}
+
+// the kinds of scanEvent
+const (
+	eventBadSize = iota
+	eventInvalidUTF8
+	eventShortGroup
+)
+
+// scanEvent is something wrong with one row of a scan
+type scanEvent struct {
+	kind   int
+	line   int
+	record []string
+	err    error
+	field  int // the offending field for invalid UTF-8, the row's field count for a missing group
+}
+
+// scanReport prints the events of a scan in line order, counting unusable rows against --max-scan-errors
+type scanReport struct {
+	onBadSize   string
+	errs        *scanErrors
+	invalidUTF8 int
+}
+
+func newScanReport(opts ScanOptions) *scanReport {
+	return &scanReport{onBadSize: opts.OnBadSize, errs: newScanErrors(opts.MaxErrors)}
+}
+
+func (r *scanReport) report(e scanEvent) {
+	switch e.kind {
+	case eventBadSize:
+		switch r.onBadSize {
+		case badSizeFail:
+			logError("meta scan", "bad size for line %d: %v (record: %v)", e.line, e.err, e.record)
+			exit(1)
+		case badSizeZero:
+			logInfo("meta scan", "Error parsing size for line %d: %v (record: %v), counting it as size 0", e.line, e.err, e.record)
+		default:
+			logInfo("meta scan", "Error parsing size for line %d: %v (record: %v)", e.line, e.err, e.record)
+			r.errs.add(e.line)
+		}
+	case eventInvalidUTF8:
+		r.invalidUTF8++
+		if r.invalidUTF8 <= scanErrorSamples {
+			logInfo("meta scan", "invalid UTF-8 in line %d, field %d", e.line, e.field)
+		}
+	case eventShortGroup:
+		logInfo("meta scan", "Error reading group for line %d: row has %d fields", e.line, e.field)
+		r.errs.add(e.line)
+	}
+}
+
+// scanErrorSamples is how many offending line numbers are kept for the abort message
+const scanErrorSamples = 5
+
+// scanErrors counts rows a scan could not use and aborts the run once the configured threshold is reached
+type scanErrors struct {
+	max   int
+	count int
+	first []int
+}
+
+func newScanErrors(max int) *scanErrors {
+	return &scanErrors{max: max}
+}
+
+func (e *scanErrors) add(line int) {
+	e.count++
+	if len(e.first) < scanErrorSamples {
+		e.first = append(e.first, line)
+	}
+	if e.max > 0 && e.count >= e.max {
+		logError("", "aborting after %d unusable rows (--max-scan-errors %d), first offending lines: %v", e.count, e.max, e.first)
+		exit(1)
+	}
+}
+
+// sizeColumn is the default index of the field holding each row's size in bytes
+const sizeColumn = 2
+
+// resolveColumn turns a column spec into a field index, accepting either a zero-based index or a header name
+func resolveColumn(header []string, spec string) (int, error) {
+	if i, err := strconv.Atoi(spec); err == nil {
+		// an input without a header has no column count to check against, rows too short are reported as they're read
+		if i < 0 || header != nil && i >= len(header) {
+			return 0, fmt.Errorf("column index %d out of range, header has %d columns", i, len(header))
+		}
+		return i, nil
+	}
+	for i, name := range header {
+		if name == spec {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("column %q not found in header", spec)
+}
+
+// relativeScale is the fixed point factor relative weights are stored with, so 12.5 becomes 12,500,000
+const relativeScale = 1_000_000
+
+// SizeSpec describes how a record's size is read from its fields
+type SizeSpec struct {
+	// Bytes measures each record as the bytes it takes up in an output file, delimiters and quoting included, instead of reading a size column
+	Bytes bool
+	// Lines weighs every record as 1, so packing balances row counts and ignores sizes
+	Lines bool
+	// Relative reads the size column as a non-negative decimal weight, such as a percentage, rather than whole bytes. Weights are stored scaled by relativeScale
+	Relative bool
+	// Column is the size column's name or zero-based index, sizeColumn when empty. Names only take effect once Resolve has seen the header
+	Column string
+	// Field is the dotted path to the size in each JSON object, e.g. meta.bytes, for jsonl input
+	Field string
+	// Expr computes each record's size from several columns, such as "col2 + 8*col4", instead of reading one. sizeSpecFromFlags compiles it
+	Expr string
+
+	resolved bool
+	index    int
+	expr     []exprOp
+}
+
+// Resolve looks Column up in the header row and returns the spec bound to that field index
+func (s SizeSpec) Resolve(header []string) (SizeSpec, error) {
+	if s.expr != nil {
+		var err error
+		s.expr, err = resolveSizeExpr(s.expr, header)
+		return s, err
+	}
+	if s.Bytes || s.Lines || s.Column == "" {
+		return s, nil
+	}
+	index, err := resolveColumn(header, s.Column)
+	if err != nil {
+		return s, fmt.Errorf("size column: %w", err)
+	}
+	s.resolved, s.index = true, index
+	return s, nil
+}
+
+// sizeSpecFromFlags applies --size-mode, --size and --by to spec
+func sizeSpecFromFlags(spec SizeSpec, mode string, source string, by string) (SizeSpec, error) {
+	switch mode {
+	case "absolute":
+	case "relative":
+		spec.Relative = true
+	default:
+		return spec, fmt.Errorf("unknown --size-mode %q, expected absolute or relative", mode)
+	}
+	switch source {
+	case "column":
+	case "bytes":
+		if spec.Relative {
+			return spec, fmt.Errorf("--size bytes cannot be combined with --size-mode relative")
+		}
+		if spec.Column != "" {
+			return spec, fmt.Errorf("--size bytes measures rows itself, it cannot be combined with --size-column")
+		}
+		spec.Bytes = true
+	default:
+		return spec, fmt.Errorf("unknown --size %q, expected column or bytes", source)
+	}
+	switch by {
+	case "size":
+	case "lines":
+		if spec.Relative || spec.Bytes || spec.Column != "" || spec.Expr != "" {
+			return spec, fmt.Errorf("--by lines ignores row sizes, it cannot be combined with --size-column, --size-expr, --size-mode or --size")
+		}
+		spec.Lines = true
+	default:
+		return spec, fmt.Errorf("unknown --by %q, expected size or lines", by)
+	}
+	if spec.Expr != "" {
+		switch {
+		case spec.Column != "":
+			return spec, fmt.Errorf("--size-expr computes sizes from the columns it names, it cannot be combined with --size-column")
+		case spec.Bytes:
+			return spec, fmt.Errorf("--size-expr cannot be combined with --size bytes")
+		case spec.Relative:
+			return spec, fmt.Errorf("--size-expr works in whole numbers, it cannot be combined with --size-mode relative")
+		}
+		var err error
+		if spec.expr, err = parseSizeExpr(spec.Expr); err != nil {
+			return spec, err
+		}
+	}
+	return spec, nil
 }
 
-func main() {
-	rootCmd.AddCommand(splitCmd)
-	rootCmd.AddCommand(inspectCmd)
-
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+// column is the field index sizes are read from
+func (s SizeSpec) column() int {
+	if s.resolved {
+		return s.index
+	}
+	if i, err := strconv.Atoi(s.Column); err == nil {
+		return i
 	}
+	return sizeColumn
 }
 
-func scan(filename string) []LineMeta {
-	start := time.Now()
-	fmt.Println("[meta scan] scanning file for line sizes...")
-	f, err := os.Open(filename)
+// Parse extracts the record's size
+func (s SizeSpec) Parse(record []string) (int64, error) {
+	if s.Lines {
+		return 1, nil
+	}
+	if s.Bytes {
+		return recordBytes(record), nil
+	}
+	if s.expr != nil {
+		return evalSizeExpr(s.expr, record)
+	}
+	col := s.column()
+	if len(record) <= col {
+		return 0, fmt.Errorf("row has %d fields, no size column at index %d", len(record), col)
+	}
+	if !s.Relative {
+		return strconv.ParseInt(record[col], 10, 64)
+	}
+
+	weight, err := strconv.ParseFloat(record[col], 64)
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
-	defer f.Close()
+	if weight < 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+		return 0, fmt.Errorf("relative weight %q must be a non-negative number", record[col])
+	}
+	return int64(math.Round(weight * relativeScale)), nil
+}
 
-	r := csv.NewReader(bufio.NewReader(f))
-	metas := []LineMeta{}
-	line := 0
+func parseSize(record []string) (int64, error) {
+	return SizeSpec{}.Parse(record)
+}
 
-	// Skip header
-	_, err = r.Read()
-	if err != nil {
-		panic(err)
+// checkRelativeWeights warns when relative weights don't look like percentages summing to roughly 100
+func checkRelativeWeights(metas []LineMeta) {
+	total := int64(0)
+	for _, meta := range metas {
+		total += meta.Size
 	}
+	checkRelativeTotal(total)
+}
 
-	line++
+// checkRelativeTotal is checkRelativeWeights for weights already summed
+func checkRelativeTotal(total int64) {
+	sum := float64(total) / relativeScale
+	if math.Abs(sum-100) > 1 {
+		logWarn("", "relative weights sum to %.4f rather than ~100, shares are normalized to the actual total", sum)
+	}
+}
 
-	parseErrors := 0
-	for {
-		record, err := r.Read()
-		if err != nil {
-			break
+// printBucketShares reports each bucket's share of the total weight as a percentage
+func printBucketShares(buckets []FileBucket) {
+	total := int64(0)
+	for _, bucket := range buckets {
+		total += bucket.TotalSize
+	}
+	if quietBuckets {
+		return
+	}
+	for i, bucket := range buckets {
+		share := 0.0
+		if total > 0 {
+			share = float64(bucket.TotalSize) / float64(total) * 100
 		}
+		fmt.Fprintf(reportOut, "Bucket %d: Share = %.4f%%, Lines = %s\n", i+1, share, FormatNumber(int64(bucket.Lines)))
+	}
+}
 
-		// DEBUG: Check if we can parse the size
-		size, parseErr := strconv.ParseInt(record[2], 10, 64)
-		if parseErr != nil {
-			fmt.Printf("[meta scan] Error parsing size for line %d: %v (record: %v)\n", line, parseErr, record)
-			parseErrors++
-			line++
-			continue
-		}
+// bucketSortKey resolves a --sort-output-by value, returning nil for "none" which keeps packing order
+func bucketSortKey(by string) (func(b FileBucket) int64, error) {
+	switch by {
+	case "none":
+		return nil, nil
+	case "size":
+		return func(b FileBucket) int64 { return b.TotalSize }, nil
+	case "count":
+		return func(b FileBucket) int64 { return int64(b.Lines) }, nil
+	}
+	return nil, fmt.Errorf("unknown --sort-output-by %q, expected size, count or none", by)
+}
 
-		metas = append(metas, LineMeta{LineNumber: line, Size: size})
-		line++
+// sortBuckets renumbers buckets and their lines in place so file 1 holds the largest bucket by key
+func sortBuckets(buckets []FileBucket, assign Assignment, key func(b FileBucket) int64) {
+	order := make([]int, len(buckets))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return key(buckets[order[i]]) > key(buckets[order[j]])
+	})
+	sorted := make([]FileBucket, len(buckets))
+	for i, from := range order {
+		sorted[i] = buckets[from]
+	}
+	copy(buckets, sorted)
+	assign.Renumber(order)
+}
 
-		if line % 1000000 == 0 {
-			fmt.Printf("[meta scan] %d lines...\n", line)
-		}
+// displaySize renders a byte count for output. --human scales it with FormatBytes and --bytes prints the raw integer, otherwise the call site's usual formatting is kept
+func displaySize(n int64, usual string) string {
+	switch {
+	case humanSizes:
+		return FormatBytes(n)
+	case rawBytes:
+		return strconv.FormatInt(n, 10)
 	}
+	return usual
+}
 
-	end := time.Now()
-	fmt.Printf("[meta scan] scan finished %d lines in %s\n", len(metas), end.Sub(start))
-	fmt.Printf("[meta scan] parse errors: %d\n", parseErrors)
-	fmt.Printf("[meta scan] highest line number: %d\n", metas[len(metas)-1].LineNumber)
-	fmt.Printf("[meta scan] total lines processed (including header): %d\n", line)
+// maxBuckets caps the bucket count, well past any sensible split but low enough that a typo can't OOM on allocating the buckets or run out of file descriptors
+const maxBuckets = 1 << 20
 
-	return metas
+// parseBucketCount validates a <buckets> argument before any work is done
+func parseBucketCount(arg string) (int, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, fmt.Errorf("buckets must be an integer, got %q", arg)
+	}
+	if n < 1 {
+		return 0, fmt.Errorf("buckets must be at least 1, got %d", n)
+	}
+	if n > maxBuckets {
+		return 0, fmt.Errorf("buckets must be at most %d, got %d", maxBuckets, n)
+	}
+	return n, nil
 }
 
-func binpack(metas []LineMeta, bucketsN int) []FileBucket {
-	start := time.Now()
-	fmt.Println("[binpack] sorting line metas by size...")
-	sort.Slice(metas, func (i, j int) bool {
-		return metas[i].Size > metas[j].Size
-	})
+// bucketCountFlagError explains the flag error cobra gives for a negative bucket count, which it takes for a shorthand flag
+func bucketCountFlagError(cmd *cobra.Command, err error) error {
+	if _, arg, ok := strings.Cut(err.Error(), "unknown shorthand flag: "); ok {
+		if _, value, ok := strings.Cut(arg, " in "); ok {
+			if n, convErr := strconv.Atoi(value); convErr == nil {
+				return fmt.Errorf("buckets must be at least 1, got %d", n)
+			}
+		}
+	}
+	return err
+}
 
-	buckets := make([]FileBucket, bucketsN)
-	for i := range buckets {
-		buckets[i].LineNums = make(map[int]struct{})
+// checkEmptyBuckets warns about a split into more buckets than there are data rows, which leaves outputs holding just a header, and fails instead unless allowEmpty
+func checkEmptyBuckets(bucketsN, rows int, allowEmpty bool) error {
+	if bucketsN <= rows {
+		return nil
 	}
+	if !allowEmpty {
+		return fmt.Errorf("%d buckets but only %d data rows, %d outputs would be empty: pass at most %d buckets", bucketsN, rows, bucketsN-rows, max(rows, 1))
+	}
+	logWarn("binpack", "%s buckets but only %s data rows, at least %s outputs will have no rows", FormatNumber(int64(bucketsN)), FormatNumber(int64(rows)), FormatNumber(int64(bucketsN-rows)))
+	return nil
+}
 
+// pack runs binpack.Pack and reports the resulting buckets
+func pack(ctx context.Context, metas []LineMeta, bucketsN int, opts PackOptions) ([]FileBucket, Assignment, error) {
+	start := time.Now()
+	if opts.Shuffle {
+		logInfo("binpack", "shuffling line metas...")
+	} else {
+		logInfo("binpack", "sorting line metas by size...")
+	}
+	grouped := len(metas) > 0 && metas[0].Group != 0
+	var largestGroup, total int64
+	if grouped {
+		largestGroup = largestPackItem(metas)
+	}
 	for _, meta := range metas {
-		minIndex := 0
-		for i := 1; i < bucketsN; i++ {
-			if buckets[i].TotalSize < buckets[minIndex].TotalSize {
-				minIndex = i
-			}
-		}
-		buckets[minIndex].TotalSize += meta.Size
-		buckets[minIndex].LineNums[meta.LineNumber] = struct{}{} // go does not have a Set data structure ;(
+		total += meta.Size
+	}
+
+	buckets, assign, err := binpack.PackContext(ctx, metas, bucketsN, opts)
+	if ctx.Err() != nil {
+		exitCancelled("binpack")
+	}
+	if err != nil {
+		return nil, nil, err
 	}
 	end := time.Now()
-	fmt.Printf("[binpack] binpacking finished in %s\n", end.Sub(start))
+	logInfo("binpack", "binpacking finished in %s", end.Sub(start))
+	printBuckets(buckets)
+	if grouped {
+		// once the largest group outweighs an even share the imbalance is unavoidable
+		share := float64(total) / float64(bucketsN)
+		logInfo("binpack", "largest group: %s (%.2f%% of an even bucket share)", displaySize(largestGroup, FormatNumber(largestGroup)), float64(largestGroup)/share*100)
+	}
+	printLineTotals(buckets, len(metas))
+
+	return buckets, assign, nil
+}
+
+// printBuckets reports every bucket's size and row count and how evenly they came out
+func printBuckets(buckets []FileBucket) {
+	sizes := make([]float64, len(buckets))
+	counts := make([]float64, len(buckets))
 	for i, bucket := range buckets {
-		fmt.Printf("Bucket %d: Total Size = %d, Lines = %d\n", i+1, bucket.TotalSize, len(bucket.LineNums))
+		if !quietBuckets {
+			fmt.Fprintf(reportOut, "Bucket %d: Total Size = %s, Lines = %s\n", i+1, displaySize(bucket.TotalSize, FormatNumber(bucket.TotalSize)), FormatNumber(int64(bucket.Lines)))
+		}
+		sizes[i] = float64(bucket.TotalSize)
+		counts[i] = float64(bucket.Lines)
 	}
+	logInfo("binpack", "size spread: %.2f%%, count spread: %.2f%%", MaxDeviation(sizes)*100, MaxDeviation(counts)*100)
+	imbalance := binpack.Imbalance(buckets)
+	logInfo("binpack", "imbalance (largest minus smallest bucket): %s", displaySize(imbalance, FormatNumber(imbalance)))
+	printBalance(binpack.Summarize(buckets))
+}
+
+// printBalance reports the spread of bucket sizes, so a user can tell whether more buckets or another strategy would help
+func printBalance(b binpack.Balance) {
+	mean := int64(math.Round(b.Mean))
+	stddev := int64(math.Round(b.StdDev))
+	logInfo("binpack", "bucket sizes: min %s, max %s, mean %s, stddev %s, largest %.2f%% above mean", displaySize(b.Min, FormatNumber(b.Min)), displaySize(b.Max, FormatNumber(b.Max)), displaySize(mean, FormatNumber(mean)), displaySize(stddev, FormatNumber(stddev)), b.Imbalance*100)
+}
 
+// printLineTotals cross-checks the rows in the buckets against the rows scanned
+func printLineTotals(buckets []FileBucket, scanned int) {
 	// DEBUG: Check total lines in all buckets
 	totalLinesInBuckets := 0
 	for _, bucket := range buckets {
-		totalLinesInBuckets += len(bucket.LineNums)
+		totalLinesInBuckets += bucket.Lines
 	}
-	fmt.Printf("[binpack] total lines across all buckets: %d\n", totalLinesInBuckets)
-	fmt.Printf("[binpack] original metas count: %d\n", len(metas))
-
-	return buckets
+	logDebug("binpack", "total lines across all buckets: %s", FormatNumber(int64(totalLinesInBuckets)))
+	logDebug("binpack", "original metas count: %s", FormatNumber(int64(scanned)))
 }
 
+// RecordData is one row on its way to a writer. lineNum is the data row number, which the writer checks order with and records in checkpoint watermarks
 type RecordData struct {
 	record []string
 	lineNum int
+	bucket  int // the output the row goes to, among those of the writer it was sent to
+}
+
+// defaultChannelBuffer is --channel-buffer's default, and what commands without the flag queue per writer
+const defaultChannelBuffer = 10000
+
+// recordDataCost is what one channel slot takes up, allocated for every slot of every writer's channel up front
+const recordDataCost = int64(unsafe.Sizeof(RecordData{}))
+
+// BucketStats is what each writer routine accumulates about the records it wrote
+type BucketStats struct {
+	ContentHash  uint64
+	WrittenBytes int64 // bytes that reached the output, header included
+	RowGroupOffsets []int64 // byte offset of the first row of each row group
+	OutOfOrder      [2]int  // with PreserveOrder, the first line that arrived after a later one, and that later line
+	LastLine        int     // data row number of the last row written
+	Checksum        string  // with --checksum, "<algo>:<hex>" digest of the output file's bytes
+}
+
+// countingWriter counts the bytes passed through to the underlying writer
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.n += int64(n)
+	return n, err
 }
 
-func writerRoutine(ch <- chan RecordData, w *csv.Writer, done chan<- struct{}) {
+// bucketOutput is one output as the writer routine that owns it sees it. commit, if not nil, flushes the writer and records the watermark
+type bucketOutput struct {
+	w      RecordWriter
+	stats  *BucketStats
+	commit func()
+	rows   int
+}
+
+// writerRoutine writes the records of the outputs first, first+stride, first+2*stride and so on, each of which no other routine touches.
+// Every output is committed every checkpointEvery rows and once at the end
+func writerRoutine(ch <- chan RecordData, outs []*bucketOutput, first, stride int, opts WriteOptions, done chan<- struct{}) {
 	for rec := range ch {
+		out := outs[rec.bucket]
+		w, stats := out.w, out.stats
+		if opts.PreserveOrder && rec.lineNum <= stats.LastLine && stats.OutOfOrder[0] == 0 {
+			stats.OutOfOrder = [2]int{rec.lineNum, stats.LastLine}
+		}
+		stats.LastLine = rec.lineNum
+		if opts.FixUTF8 {
+			fixUTF8(rec.record)
+		}
+		if opts.RowGroupSize > 0 && out.rows%opts.RowGroupSize == 0 {
+			// flushing first puts everything before this row on disk, so the written byte count is exactly where the group starts
+			w.Flush()
+			stats.RowGroupOffsets = append(stats.RowGroupOffsets, stats.WrittenBytes)
+		}
+		out.rows++
 		w.Write(rec.record)
+		if opts.ContentHash {
+			// summing keeps the hash order-independent like XOR would, but duplicate rows don't cancel each other out
+			stats.ContentHash += rowHash(rec.record)
+		}
+		if out.commit != nil && out.rows%checkpointEvery == 0 {
+			out.commit()
+		}
+	}
+	for b := first; b < len(outs); b += stride {
+		outs[b].w.Flush()
+		if outs[b].commit != nil {
+			outs[b].commit()
+		}
 	}
-	w.Flush()
 	done <- struct{}{}
 }
 
-func write(input string, prefix string, buckets []FileBucket) {
-	fmt.Println("[write] writing output files...")
+// invalidUTF8Field returns the index of the first field that isn't valid UTF-8, or -1
+func invalidUTF8Field(record []string) int {
+	for i, field := range record {
+		if !utf8.ValidString(field) {
+			return i
+		}
+	}
+	return -1
+}
+
+// fixUTF8 replaces invalid UTF-8 sequences in place
+func fixUTF8(record []string) {
+	for i, field := range record {
+		if !utf8.ValidString(field) {
+			record[i] = strings.ToValidUTF8(field, string(utf8.RuneError))
+		}
+	}
+}
+
+// rowHash hashes a record's fields, length-prefixing each so ["ab","c"] and ["a","bc"] differ
+func rowHash(record []string) uint64 {
+	h := fnv.New64a()
+	var n [8]byte
+	for _, field := range record {
+		binary.LittleEndian.PutUint64(n[:], uint64(len(field)))
+		h.Write(n[:])
+		h.Write([]byte(field))
+	}
+	return h.Sum64()
+}
+
+func write(ctx context.Context, input string, prefix string, buckets []FileBucket, assign Assignment, opts WriteOptions) {
+	logInfo("write", "writing output files...")
 	f, err := os.Open(input)
 	if err != nil {
 		panic(err)
 	}
+	if opts.InputStat != nil {
+		stat, err := f.Stat()
+		if err != nil {
+			panic(err)
+		}
+		if stat.Size() != opts.InputStat.Size() || !stat.ModTime().Equal(opts.InputStat.ModTime()) {
+			logError("", "input changed between passes: size %d -> %d, modified %s -> %s", opts.InputStat.Size(), stat.Size(), opts.InputStat.ModTime(), stat.ModTime())
+			os.Exit(1)
+		}
+	}
+
+	in, err := inputReader(f, input)
+	if err != nil {
+		logError("", "%v", err)
+		os.Exit(1)
+	}
+	isOversized := func(line int) bool {
+		_, found := slices.BinarySearch(opts.Oversized, line)
+		return found
+	}
+	// rows a resumed run already wrote are passed over. Rows with no bucket are read to tell filtered, empty and bad-size ones apart, and oversized ones are written aside
+	wanted := func(line int) bool {
+		b, ok := assign.Bucket(line)
+		if !ok {
+			return true
+		}
+		return opts.Resume == nil || line > opts.Resume[b].LastLine
+	}
+	var seeker *rowSeeker
+	if opts.RowOffsets != nil {
+		share := neededShare(opts.RowOffsets, opts.ExpectedRecords, wanted)
+		if share < seekIndexThreshold {
+			seeker = &rowSeeker{f: f, br: bufio.NewReader(f)}
+			in = seeker.br
+			logInfo("write", "%.1f%% of the input needs writing, seeking past the rest", share*100)
+		} else {
+			logInfo("write", "%.1f%% of the input needs writing, reading it straight through", share*100)
+		}
+	}
+	r, err := newRecordReader(opts.Format, in, opts.Size)
+	if err != nil {
+		logError("", "%v", err)
+		os.Exit(1)
+	}
+	writers := make([]RecordWriter, len(buckets))
+	files := make([]io.WriteCloser, len(buckets))
+	stats := make([]BucketStats, len(buckets))
+
+	// past --max-open-files the outputs are shared out among that many writers, and closed and reopened so no more are open at once
+	workers := len(buckets)
+	openFiles = nil
+	if opts.MaxOpenFiles > 0 && len(buckets) > opts.MaxOpenFiles {
+		workers = opts.MaxOpenFiles
+		openFiles = newFileCache(workers)
+		logInfo("write", "%d outputs over --max-open-files %d, writing them from %d writers that keep at most %d open", len(buckets), opts.MaxOpenFiles, workers, workers)
+	}
+
+	newOutput, err := openOutputs(prefix)
+	if err != nil {
+		logError("", "%v", err)
+		os.Exit(1)
+	}
+	// local outputs keep their temporary names until every one is complete, an append adds to the finished files in place
+	tempOutputs := !archiveOutputs && !isS3Prefix(prefix) && opts.Append == nil
+	writtenPath := func(i int) string {
+		if tempOutputs {
+			return tempOutputPath(prefix, i)
+		}
+		return outputPath(prefix, i)
+	}
+	if tempOutputs && opts.Checkpoint == nil {
+		// a checkpointed split keeps them for --resume instead
+		atExit(func() {
+			for i := range buckets {
+				os.Remove(tempOutputPath(prefix, i))
+			}
+		})
+	}
 
-	r := csv.NewReader(bufio.NewReader(f))
-	writers := make([]*csv.Writer, len(buckets))
-	files := make([]*os.File, len(buckets))
+	var rejects, oversized *sideRows
+	if opts.RejectFile {
+		if rejects, err = openSideRows(prefix, rejectedName, opts.Format); err != nil {
+			logError("", "%v", err)
+			exit(1)
+		}
+	}
+	if opts.Oversized != nil {
+		if oversized, err = openSideRows(prefix, oversizedName, opts.Format); err != nil {
+			logError("", "%v", err)
+			exit(1)
+		}
+	}
+	filteredLines, emptyLines, badSizeLines := 0, 0, 0
 
+	preallocated := make([]bool, len(buckets))
 	for i := range writers {
-		file, err := os.Create(fmt.Sprintf("%s%d.csv", prefix, i + 1))
-		if err != nil {
-			panic(err)
+		var file io.WriteCloser
+		if opts.Resume != nil && opts.Resume[i].Bytes > 0 {
+			mark := opts.Resume[i]
+			local, err := reopenOutput(writtenPath(i), mark.Bytes)
+			if err != nil {
+				logError("resume", "%v", err)
+				exit(1)
+			}
+			file = openFiles.adopt(local)
+			stats[i] = BucketStats{WrittenBytes: mark.Bytes, ContentHash: mark.ContentHash, LastLine: mark.LastLine}
+		} else if opts.Append != nil {
+			local, err := appendOutput(outputPath(prefix, i), opts.Append.Stats[i].WrittenBytes)
+			if err != nil {
+				logError("append", "%v", err)
+				exit(1)
+			}
+			file = openFiles.adopt(local)
+			stats[i] = opts.Append.Stats[i]
+		} else if file, err = newOutput(i); err != nil {
+			logError("write", "%v", err)
+			exit(1)
 		}
 		files[i] = file
-		writers[i] = csv.NewWriter(file)
-	}
+		writers[i] = newRecordWriter(opts.Format, countingWriter{w: file, n: &stats[i].WrittenBytes})
 
-	// memoize line to bucket for fast O(1) lookup
-	lineToBucket := make(map[int]int)
-	for i, bucket := range buckets {
-		for lineNum := range bucket.LineNums {
-			lineToBucket[lineNum] = i
+		if opts.Preallocate && stats[i].WrittenBytes > 0 {
+			logInfo("write", "%s: resumed, skipping preallocation", outputPath(prefix, i))
+		} else if opts.Preallocate && compressOutputs {
+			logInfo("write", "%s: compressed size isn't known up front, skipping preallocation", outputPath(prefix, i))
+		} else if opts.Preallocate {
+			local, ok := localOutput(file)
+			if !ok {
+				logInfo("write", "%s: not a local file, skipping preallocation", outputPath(prefix, i))
+				continue
+			}
+			if err := preallocate(local, buckets[i].TotalSize); err != nil {
+				logInfo("write", "%s: preallocation failed: %v", outputPath(prefix, i), err)
+				continue
+			}
+			preallocated[i] = true
+			logInfo("write", "%s: preallocated %s", outputPath(prefix, i), displaySize(buckets[i].TotalSize, FormatNumber(buckets[i].TotalSize)+" bytes"))
 		}
 	}
 
 	// DEBUG: Print mapping info
-	fmt.Printf("[write] total lines in lineToBucket: %d\n", len(lineToBucket))
+	assigned := 0
+	for _, bucket := range buckets {
+		assigned += bucket.Lines
+	}
+	logDebug("write", "total lines in assignment: %s", FormatNumber(int64(assigned)))
 
-	channels := make([]chan RecordData, len(buckets))
-	done := make(chan struct{}, len(buckets))
+	slots := recordDataCost * int64(opts.ChannelBuffer) * int64(workers)
+	logInfo("write", "queueing up to %d rows per writer, %s of channel slots", opts.ChannelBuffer, displaySize(slots, FormatBytes(slots)))
+	channels := make([]chan RecordData, workers)
+	done := make(chan struct{}, workers)
+	cancelled := false
+	// failure is why the rows written don't reconcile with the input, reported once the outputs are closed
+	var failure string
+	// inputRows is how many data rows the input held, known once the write pass is through
+	inputRows := 0
 
 	defer func(){
 		for _, ch := range channels {
 			close(ch)
 		}
 
-		for i := 0; i < len(buckets); i++ {
+		for range channels {
 			<-done
 		}
 
+		// with a checkpoint the outputs and their watermarks are kept for --resume, the writers committed them as they finished
+		if cancelled && opts.Checkpoint != nil {
+			f.Close()
+			for _, file := range files {
+				file.Close()
+			}
+			// a resumed run reads every filtered row again and writes the file from the start
+			rejects.discard()
+			logInfo("write", "progress saved in %s, run the same split with --resume to continue", opts.Checkpoint.path)
+			exitCancelled("write")
+		}
+
+		// an interrupted append leaves the outputs as they were before it
+		if cancelled && opts.Append != nil {
+			f.Close()
+			opts.Append.rollback(files, prefix)
+			rejects.discard()
+			oversized.discard()
+			exitCancelled("write")
+		}
+
+		// an interrupted split leaves no outputs behind rather than files missing an unknown number of rows
+		if cancelled {
+			f.Close()
+			for i, file := range files {
+				if err := discardOutput(file, writtenPath(i)); err != nil {
+					logInfo("write", "could not discard partial output %s: %v", writtenPath(i), err)
+				}
+			}
+			logInfo("write", "discarded %d partial output files", len(files))
+			rejects.discard()
+			oversized.discard()
+			exitCancelled("write")
+		}
+
 		for _, w := range writers {
 			if err := w.Error(); err != nil {
-				fmt.Printf("Error writing to file: %v\n", err)
-				os.Exit(1)
+				logError("", "writing to file: %v", err)
+				exit(1)
 			}
 		}
 
@@ -260,66 +1869,322 @@ func write(input string, prefix string, buckets []FileBucket) {
 		for _, w := range writers {
 			w.Flush()
 			if err := w.Error(); err != nil {
-				fmt.Printf("Error flushing writer: %v\n", err)
-				os.Exit(1)
+				logError("", "flushing writer: %v", err)
+				exit(1)
 			}
 		}
 
-		for _, file := range files {
+		if err := rejects.close("filtered-out"); err != nil {
+			logError("", "%v", err)
+			exit(1)
+		}
+		if err := oversized.close("oversized"); err != nil {
+			logError("", "%v", err)
+			exit(1)
+		}
+
+		for i, file := range files {
+			// drop whatever part of the reservation wasn't written
+			if preallocated[i] {
+				local, _ := localOutput(file)
+				if err := local.Truncate(stats[i].WrittenBytes); err != nil {
+					logError("", "truncating file: %v", err)
+					exit(1)
+				}
+			}
 			if err := file.Close(); err != nil {
-				fmt.Printf("Error closing file: %v\n", err)
-				os.Exit(1)
+				logError("", "closing file: %v", err)
+				exit(1)
+			}
+			if outputSums != nil {
+				stats[i].Checksum = outputSums.sum(i)
+			}
+		}
+		if tempOutputs {
+			if err := renameOutputs(prefix, len(files)); err != nil {
+				logError("", "renaming the finished outputs: %v", err)
+				exit(1)
+			}
+		}
+		if opts.AfterWriteHook != "" {
+			hooks := newHookRunner(opts.AfterWriteHook, len(files), opts.HookConcurrency)
+			for i := range files {
+				hooks.start(i, outputPath(prefix, i), stats[i].WrittenBytes)
+			}
+			if failed := hooks.wait(prefix); failed > 0 && !opts.IgnoreHookErrors {
+				logError("", "%d of %d after-write hooks failed", failed, len(files))
+				exit(1)
+			}
+		}
+
+		if opts.CountWritten {
+			logical, written := int64(0), int64(0)
+			for i := range stats {
+				logInfo("write", "%s: logical size %s, written bytes %s", outputPath(prefix, i), displaySize(buckets[i].TotalSize, FormatNumber(buckets[i].TotalSize)), displaySize(stats[i].WrittenBytes, FormatNumber(stats[i].WrittenBytes)))
+				logical += buckets[i].TotalSize
+				written += stats[i].WrittenBytes
+			}
+			discrepancy := FormatNumber(written - logical)
+			if written >= logical {
+				discrepancy = "+" + discrepancy
+			}
+			logInfo("write", "total logical size %s, total written bytes %s, discrepancy %s", displaySize(logical, FormatNumber(logical)), displaySize(written, FormatNumber(written)), displaySize(written-logical, discrepancy))
+		}
+
+		if opts.RowGroupSize > 0 {
+			for i := range stats {
+				logInfo("write", "%s: %d row groups of %d rows starting at byte offsets %v", outputPath(prefix, i), len(stats[i].RowGroupOffsets), opts.RowGroupSize, stats[i].RowGroupOffsets)
+			}
+		}
+
+		if opts.ContentHash {
+			for i := range stats {
+				logInfo("write", "%s content hash: %016x", outputPath(prefix, i), stats[i].ContentHash)
+			}
+		}
+
+		if opts.PreserveOrder {
+			for i := range stats {
+				if line := stats[i].OutOfOrder; line[0] != 0 {
+					logError("", "%s got line %d after line %d, rows are out of input order", outputPath(prefix, i), line[0], line[1])
+					exit(1)
+				}
+			}
+			logInfo("write", "every output lists its rows in input order")
+		}
+
+		if err := writeManifest(prefix, buckets, assign, stats, inputRows, opts); err != nil {
+			logError("", "writing manifest: %v", err)
+			exit(1)
+		}
+		if outputSums != nil && outputSums.algo == "sha256" {
+			if err := writeChecksums(prefix, stats); err != nil {
+				logError("", "writing checksums: %v", err)
+				exit(1)
 			}
 		}
+		// the outputs are kept for inspecting what went wrong, the exit status marks them unusable
+		if failure != "" {
+			logError("", "%v", failure)
+			exit(1)
+		}
 	}()
 
+	outs := make([]*bucketOutput, len(buckets))
+	for i := range outs {
+		outs[i] = &bucketOutput{w: writers[i], stats: &stats[i]}
+		if opts.Checkpoint != nil {
+			w, file, stats := writers[i], files[i].(syncedOutput), &stats[i]
+			outs[i].commit = func() {
+				// the watermark may only claim rows that are safely in the file, or a resumed run would lose them
+				w.Flush()
+				if err := w.Error(); err != nil {
+					return
+				}
+				if err := file.Sync(); err != nil {
+					logError("checkpoint", "syncing %s: %v", file.Name(), err)
+					exit(1)
+				}
+				if err := opts.Checkpoint.commit(watermark{Bucket: i, LastLine: stats.LastLine, Bytes: stats.WrittenBytes, ContentHash: stats.ContentHash}); err != nil {
+					logError("checkpoint", "recording progress: %v", err)
+					exit(1)
+				}
+			}
+		}
+	}
 	for i := range channels {
-		channels[i] = make(chan RecordData, 10000) // buffered channel
-		go writerRoutine(channels[i], writers[i], done)
+		channels[i] = make(chan RecordData, opts.ChannelBuffer)
+		go writerRoutine(channels[i], outs, i, workers, opts, done)
 	}
 
 	lineNum := 0
+	headersLeft := headerCount(opts.Format)
+	if headersLeft == 0 {
+		// no header to copy into every output, the first record is data row 1
+		lineNum = 1
+	}
 	totalLinesRead := 0
 	skippedLines := 0
+	resumedLines := 0
+	routedLines := 0
+	oversizedLines := 0
+	outOfRange := 0
 
+	// the scan found the highest data row, so the write pass knows how far it has to go
+	prog := newProgress("[write]", opts.ExpectedRecords)
 	for {
-		record, err := r.Read()
-		if err != nil {
+		if lineNum%cancelCheckEvery == 0 && ctx.Err() != nil {
+			cancelled = true
+			return
+		}
+		prog.update(lineNum)
+		if seeker != nil && lineNum > 0 {
+			// jump to the next row to write instead of parsing the ones in between
+			for lineNum <= opts.ExpectedRecords && !wanted(lineNum) {
+				resumedLines++
+				lineNum++
+			}
+			if lineNum > opts.ExpectedRecords {
+				break
+			}
+			if err := seeker.to(opts.RowOffsets[lineNum]); err != nil {
+				logError("write", "seeking to data row %d: %v", lineNum, err)
+				exit(1)
+			}
+		}
+		// a bad size only matters to scan, which already left the row out of every bucket. Here it tells why the row has none
+		record, _, err := r.Read()
+		if err == io.EOF {
 			break
 		}
+		if err != nil && !errors.Is(err, ErrBadSize) {
+			logError("write", "reading data row %d: %v", lineNum, err)
+			exit(1)
+		}
+		if seeker != nil {
+			seeker.pos = opts.RowOffsets[lineNum+1]
+		}
 		totalLinesRead++
+		if totalLinesRead == 1 && opts.Columns != nil {
+			// the first header row names the columns, without a header the first row read gives the field count
+			if err := opts.Columns.resolve(record); err != nil {
+				logError("", "%v", err)
+				exit(1)
+			}
+		}
 
 		if lineNum == 0 {
-			for _, w := range writers {
-				w.Write(record)
+			// every header row is copied to every output, data row 1 follows the last of them
+			row := headerCount(opts.Format) - headersLeft
+			headersLeft--
+			if headersLeft == 0 {
+				lineNum++
 			}
-			lineNum++
+			if opts.FixUTF8 {
+				fixUTF8(record)
+			}
+			projected := opts.Columns.apply(record)
+			if opts.Append != nil && !slices.Equal(projected, opts.Append.Header[row]) {
+				logError("append", "the input's header row %d %v doesn't match the outputs' %v", row+1, projected, opts.Append.Header[row])
+				exit(1)
+			}
+			for i, w := range writers {
+				// a resumed output already starts with the header
+				if stats[i].WrittenBytes == 0 {
+					w.Write(projected)
+				}
+			}
+			if opts.Filter != nil && row == 0 {
+				if err := opts.Filter.resolve(record); err != nil {
+					logError("", "%v", err)
+					exit(1)
+				}
+			}
+			rejects.header(record)
+			oversized.header(record)
 			continue
 		}
 
-		bucketIndex, ok := lineToBucket[lineNum]
+		bucketIndex, ok := assign.Bucket(lineNum)
+		if !ok && opts.SkipEmptyRows && isEmptyRow(record) {
+			emptyLines++
+			lineNum++
+			continue
+		}
+		if !ok && isOversized(lineNum) {
+			oversized.write(record)
+			oversizedLines++
+			lineNum++
+			continue
+		}
+		if !ok && opts.Filter != nil && !opts.Filter.match(record) {
+			filteredLines++
+			rejects.write(record)
+			lineNum++
+			continue
+		}
+		if !ok && errors.Is(err, ErrBadSize) {
+			badSizeLines++
+			lineNum++
+			continue
+		}
 		if !ok {
-			fmt.Printf("Warning: line %d not found in any bucket, skipping...\n", lineNum)
+			logWarn("", "line %d not found in any bucket, skipping...", lineNum)
 			skippedLines++
 			lineNum++
 			continue
 		}
-		if bucketIndex >= 0 && bucketIndex < len(channels) {
-			channels[bucketIndex] <- RecordData{record: record, lineNum: lineNum}
-		} else {
-			fmt.Printf("Error: bucket index %d out of range for line %d\n", bucketIndex, lineNum)
-			os.Exit(1)
+		if opts.Resume != nil && bucketIndex >= 0 && bucketIndex < len(buckets) && lineNum <= opts.Resume[bucketIndex].LastLine {
+			resumedLines++
+			lineNum++
+			continue
 		}
-
-		if lineNum % 1000000 == 0 {
-			fmt.Printf("[write] %d lines written...\n", lineNum)
+		if bucketIndex >= 0 && bucketIndex < len(buckets) {
+			// this loop reads the input in order and each bucket has one FIFO channel drained by one writer, so rows keep their input order within a file
+			channels[bucketIndex%workers] <- RecordData{record: opts.Columns.apply(record), lineNum: lineNum, bucket: bucketIndex}
+			routedLines++
+		} else {
+			logError("", "bucket index %d out of range for line %d", bucketIndex, lineNum)
+			outOfRange++
 		}
 
 		lineNum++
 	}
+	prog.done()
+
+	if lineNum-1 < opts.ExpectedRecords {
+		logError("", "input changed between passes: expected %s records, read %s", FormatNumber(int64(opts.ExpectedRecords)), FormatNumber(int64(lineNum-1)))
+		exit(1)
+	}
+
+	logInfo("write", "total lines read from file: %s", FormatNumber(int64(totalLinesRead)))
+	logInfo("write", "total data lines processed: %s", FormatNumber(int64(lineNum-1)))
+	logInfo("write", "skipped lines: %s", FormatNumber(int64(skippedLines)))
+	if opts.Filter != nil {
+		logInfo("write", "filtered lines: %s", FormatNumber(int64(filteredLines)))
+	}
+	if opts.SkipEmptyRows {
+		logInfo("write", "empty lines skipped: %s", FormatNumber(int64(emptyLines)))
+	}
+	if badSizeLines > 0 {
+		logInfo("write", "lines the scan left out for a bad size: %s", FormatNumber(int64(badSizeLines)))
+	}
+	if opts.Resume != nil {
+		logInfo("write", "lines already written before resuming: %s", FormatNumber(int64(resumedLines)))
+	}
+	inputRows = lineNum - 1
+	failure = reconcile(lineNum-1, assign, rowCounts{routed: routedLines + resumedLines, skipped: skippedLines, filtered: filteredLines, empty: emptyLines, badSize: badSizeLines, oversized: oversizedLines, outOfRange: outOfRange}, opts.Strict)
+	if failure == "" {
+		logInfo("write", "all files written successfully")
+	}
+}
+
+// rowCounts is where the write pass sent the data rows it went through
+type rowCounts struct {
+	routed, skipped, filtered, empty, badSize, oversized, outOfRange int
+}
 
-	fmt.Printf("[write] total lines read from file: %d\n", totalLinesRead)
-	fmt.Printf("[write] total data lines processed: %d\n", lineNum-1)
-	fmt.Printf("[write] skipped lines: %d\n", skippedLines)
-	fmt.Println("[write] all files written successfully\n")
+// reconcile checks that every data row the write pass went through was accounted for once, and returns why the run has to fail, if it does.
+// A row the assignment has no bucket for is only fatal with strict, a row mapped to a bucket that doesn't exist always is
+func reconcile(rows int, assign Assignment, c rowCounts, strict bool) string {
+	routed, skipped, outOfRange := c.routed, c.skipped, c.outOfRange
+	mapped := 0
+	for line := 1; line <= rows; line++ {
+		if _, ok := assign.Bucket(line); ok {
+			mapped++
+		}
+	}
+	logInfo("write", "reconciliation: %s data rows, %s routed to buckets, %s filtered out, %s empty, %s with a bad size, %s oversized, %s skipped, %s out of range", FormatNumber(int64(rows)), FormatNumber(int64(routed)), FormatNumber(int64(c.filtered)), FormatNumber(int64(c.empty)), FormatNumber(int64(c.badSize)), FormatNumber(int64(c.oversized)), FormatNumber(int64(skipped)), FormatNumber(int64(outOfRange)))
+	unaccounted := rows - routed - c.filtered - c.empty - c.badSize - c.oversized - skipped - outOfRange
+	switch {
+	case outOfRange > 0:
+		return fmt.Sprintf("%s rows were assigned to buckets that don't exist", FormatNumber(int64(outOfRange)))
+	case unaccounted != 0:
+		return fmt.Sprintf("%s of %s data rows are unaccounted for", FormatNumber(int64(unaccounted)), FormatNumber(int64(rows)))
+	case strict && routed+outOfRange != mapped:
+		return fmt.Sprintf("--strict: the assignment maps %s rows to buckets but %s were routed", FormatNumber(int64(mapped)), FormatNumber(int64(routed)))
+	case strict && skipped > 0:
+		return fmt.Sprintf("--strict: %s rows were in no bucket and left out of every output", FormatNumber(int64(skipped)))
+	}
+	return ""
 }
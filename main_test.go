@@ -0,0 +1,2171 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"binpacking/binpack"
+)
+
+// TestMain runs the command line instead of the tests when runBinpacking starts the test binary again, so a test can check what a command prints and how it exits
+func TestMain(m *testing.M) {
+	if os.Getenv("BINPACKING_RUN_MAIN") == "1" {
+		if n, err := strconv.Atoi(os.Getenv("BINPACKING_FAIL_WRITE")); err == nil {
+			onOutputWrite(n, func() error { return errors.New("injected write failure") })
+		}
+		if n, err := strconv.Atoi(os.Getenv("BINPACKING_CRASH_WRITE")); err == nil {
+			// exits on the spot, without the cleanups a failed run gets
+			onOutputWrite(n, func() error {
+				os.Exit(2)
+				return nil
+			})
+		}
+		if n, err := strconv.Atoi(os.Getenv("BINPACKING_INTERRUPT_WRITE")); err == nil {
+			onOutputWrite(n, func() error {
+				self, _ := os.FindProcess(os.Getpid())
+				self.Signal(os.Interrupt)
+				// give the signal time to cancel the run before the write carries on
+				time.Sleep(100 * time.Millisecond)
+				return nil
+			})
+		}
+		main()
+		os.Exit(0)
+	}
+	if os.Getenv("BINPACKING_SCAN_FAILING_READER") == "1" {
+		scanFailingReader()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// errAfterReader reads from r until n bytes have been read, then fails with err
+type errAfterReader struct {
+	r   io.Reader
+	n   int
+	err error
+}
+
+func (e *errAfterReader) Read(p []byte) (int, error) {
+	if e.n <= 0 {
+		return 0, e.err
+	}
+	if len(p) > e.n {
+		p = p[:e.n]
+	}
+	n, err := e.r.Read(p)
+	e.n -= n
+	return n, err
+}
+
+// scanFailingReader scans a csv whose reader fails halfway through, which has to stop the process rather than end the scan early
+func scanFailingReader() {
+	var b strings.Builder
+	b.WriteString("id,name,size\n")
+	for i := 1; i <= 200; i++ {
+		fmt.Fprintf(&b, "%d,n%d,%d\n", i, i, i)
+	}
+	in := &errAfterReader{r: strings.NewReader(b.String()), n: b.Len() / 2, err: errors.New("disk went away")}
+	opts := ScanOptions{Format: "csv"}
+	r, err := newRecordReader(opts.Format, in, opts.Size)
+	if err != nil {
+		panic(err)
+	}
+	metas := scanRecords(context.Background(), r, opts, time.Now())
+	fmt.Printf("scanned %d rows\n", len(metas))
+}
+
+// hookedOutput is an output that calls hook on its nth write, and from then on fails every write if the hook returns an error
+type hookedOutput struct {
+	io.WriteCloser
+	n    int
+	hook func() error
+	err  error
+}
+
+func (h *hookedOutput) Write(p []byte) (int, error) {
+	if h.n--; h.n == 0 {
+		h.err = h.hook()
+	}
+	if h.err != nil {
+		return 0, h.err
+	}
+	return h.WriteCloser.Write(p)
+}
+
+// Sync and Name let a checkpointed split commit the output underneath
+func (h *hookedOutput) Sync() error  { return h.WriteCloser.(syncedOutput).Sync() }
+func (h *hookedOutput) Name() string { return h.WriteCloser.(syncedOutput).Name() }
+
+// onOutputWrite has the first bucket's output call hook on its nth write, after the earlier ones reached the file
+func onOutputWrite(n int, hook func() error) {
+	open := openOutputs
+	openOutputs = func(prefix string) (OutputFactory, error) {
+		factory, err := open(prefix)
+		if err != nil {
+			return nil, err
+		}
+		return func(bucket int) (io.WriteCloser, error) {
+			file, err := factory(bucket)
+			if err != nil || bucket != 0 {
+				return file, err
+			}
+			return &hookedOutput{WriteCloser: file, n: n, hook: hook}, nil
+		}, nil
+	}
+}
+
+// runBinpacking runs binpacking with args in a child process and returns its stdout, stderr and exit code
+func runBinpacking(t *testing.T, args ...string) (string, string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "BINPACKING_RUN_MAIN=1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatal(err)
+	}
+	return stdout.String(), stderr.String(), cmd.ProcessState.ExitCode()
+}
+
+// TestSplitKeepsEveryRowOnce splits a small CSV into 2 buckets and checks the outputs hold exactly the input's data rows, each under the header once
+func TestSplitKeepsEveryRowOnce(t *testing.T) {
+	nameTemplate = "{prefix}{index}.{ext}"
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.csv")
+	var want []string
+	var b strings.Builder
+	b.WriteString("id,name,size\n")
+	for i := 1; i <= 25; i++ {
+		row := fmt.Sprintf("%d,n%d,%d", i, i, (i*37)%50+1)
+		want = append(want, row)
+		b.WriteString(row + "\n")
+	}
+	if err := os.WriteFile(input, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	metas := scan(ctx, input, ScanOptions{Format: "csv"})
+	if len(metas) != len(want) {
+		t.Fatalf("scan found %d rows, want %d", len(metas), len(want))
+	}
+	// the scan numbers data rows from 1, after the header, and write must route the same numbers
+	for _, meta := range metas {
+		if meta.LineNumber < 1 || meta.LineNumber > len(want) {
+			t.Fatalf("scan numbered a row %d, want 1 to %d", meta.LineNumber, len(want))
+		}
+	}
+	setOutputCount(2)
+	buckets, assign, err := pack(ctx, metas, 2, PackOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := filepath.Join(dir, "out")
+	write(ctx, input, prefix, buckets, assign, WriteOptions{Format: "csv", ChannelBuffer: defaultChannelBuffer, ExpectedRecords: len(want), Strict: true})
+
+	var got []string
+	for i := range buckets {
+		data, err := os.ReadFile(outputPath(prefix, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		if lines[0] != "id,name,size" {
+			t.Errorf("%s starts with %q, want the header", outputPath(prefix, i), lines[0])
+		}
+		if len(lines)-1 != buckets[i].Lines {
+			t.Errorf("%s has %d data rows, the packing put %d there", outputPath(prefix, i), len(lines)-1, buckets[i].Lines)
+		}
+		for _, line := range lines[1:] {
+			if line == "id,name,size" {
+				t.Errorf("%s has the header again as a data row", outputPath(prefix, i))
+			}
+			got = append(got, line)
+		}
+	}
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("outputs hold rows %v, want %v", got, want)
+	}
+}
+
+// writeCSV writes an id,name,size input with rows data rows into dir and returns its path and the data rows
+func writeCSV(t testing.TB, dir string, rows int) (string, []string) {
+	t.Helper()
+	input := filepath.Join(dir, "in.csv")
+	var want []string
+	var b strings.Builder
+	b.WriteString("id,name,size\n")
+	for i := 1; i <= rows; i++ {
+		row := fmt.Sprintf("%d,n%d,%d", i, i, (i*37)%50+1)
+		want = append(want, row)
+		b.WriteString(row + "\n")
+	}
+	if err := os.WriteFile(input, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return input, want
+}
+
+// splitCSV runs the scan, binpack and write passes of split for a csv input into n buckets, with opts' Format and buffer filled in
+func splitCSV(t *testing.T, input string, prefix string, n int, opts WriteOptions) []FileBucket {
+	t.Helper()
+	nameTemplate = "{prefix}{index}.{ext}"
+	ctx := context.Background()
+	metas := scan(ctx, input, ScanOptions{Format: "csv"})
+	setOutputCount(n)
+	buckets, assign, err := pack(ctx, metas, n, PackOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Format = "csv"
+	opts.ChannelBuffer = defaultChannelBuffer
+	opts.ExpectedRecords = len(metas)
+	write(ctx, input, prefix, buckets, assign, opts)
+	return buckets
+}
+
+// TestMergeRestoresInputOrder merges a split's outputs with --restore-order and checks the merged file is the input again, byte for byte
+func TestMergeRestoresInputOrder(t *testing.T) {
+	dir := t.TempDir()
+	input, _ := writeCSV(t, dir, 40)
+	prefix := filepath.Join(dir, "out")
+	splitCSV(t, input, prefix, 3, WriteOptions{})
+
+	mergeRestoreOrder = true
+	defer func() { mergeRestoreOrder = false }()
+	merged := filepath.Join(dir, "merged.csv")
+	if err := merge(prefix, merged); err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("merged file is\n%s\nwant the input\n%s", got, want)
+	}
+}
+
+// TestMergeKeepsEveryRow merges a split's outputs file by file and checks the merged file has the header once and the input's data rows
+func TestMergeKeepsEveryRow(t *testing.T) {
+	dir := t.TempDir()
+	input, want := writeCSV(t, dir, 40)
+	prefix := filepath.Join(dir, "out")
+	splitCSV(t, input, prefix, 3, WriteOptions{})
+
+	merged := filepath.Join(dir, "merged.csv")
+	if err := merge(prefix, merged); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if lines[0] != "id,name,size" {
+		t.Errorf("merged file starts with %q, want the header", lines[0])
+	}
+	got := slices.Sorted(slices.Values(lines[1:]))
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("merged file holds rows %v, want %v", got, want)
+	}
+}
+
+func TestParseBucketCountRejects(t *testing.T) {
+	for _, tc := range []struct {
+		arg  string
+		want string
+	}{
+		{"0", "buckets must be at least 1, got 0"},
+		{"-3", "buckets must be at least 1, got -3"},
+		{"1048577", "buckets must be at most 1048576, got 1048577"},
+		{"99999999999999999999", `buckets must be an integer, got "99999999999999999999"`},
+		{"two", `buckets must be an integer, got "two"`},
+	} {
+		if _, err := parseBucketCount(tc.arg); err == nil || err.Error() != tc.want {
+			t.Errorf("parseBucketCount(%q) returned %v, want %q", tc.arg, err, tc.want)
+		}
+	}
+	if n, err := parseBucketCount("1048576"); err != nil || n != maxBuckets {
+		t.Errorf("parseBucketCount(%q) = %d, %v, want the maximum accepted", "1048576", n, err)
+	}
+}
+
+// TestSplitRejectsBucketCount runs split with bucket counts it must refuse before reading the input, a negative one included, which cobra would take for a flag
+func TestSplitRejectsBucketCount(t *testing.T) {
+	dir := t.TempDir()
+	input, _ := writeCSV(t, dir, 5)
+	prefix := filepath.Join(dir, "out")
+	for _, tc := range []struct {
+		buckets string
+		want    string
+	}{
+		{"0", "buckets must be at least 1, got 0"},
+		{"-2", "buckets must be at least 1, got -2"},
+		{"5000000", "buckets must be at most 1048576, got 5000000"},
+	} {
+		_, stderr, code := runBinpacking(t, "split", input, tc.buckets, prefix)
+		if code != 1 {
+			t.Errorf("split into %s buckets exited %d, want 1", tc.buckets, code)
+		}
+		if !strings.Contains(stderr, tc.want) {
+			t.Errorf("split into %s buckets printed %q, want %q", tc.buckets, stderr, tc.want)
+		}
+		if matches, _ := filepath.Glob(prefix + "*"); len(matches) > 0 {
+			t.Errorf("split into %s buckets left %v", tc.buckets, matches)
+		}
+	}
+}
+
+// TestFailedWriteLeavesNoOutputs makes one output fail partway through the write pass and checks the split leaves neither final nor temporary outputs behind
+func TestFailedWriteLeavesNoOutputs(t *testing.T) {
+	dir := t.TempDir()
+	input, _ := writeCSV(t, dir, 5000)
+	prefix := filepath.Join(dir, "out")
+	t.Setenv("BINPACKING_FAIL_WRITE", "3")
+	_, stderr, code := runBinpacking(t, "split", input, "2", prefix)
+	if code != 1 {
+		t.Errorf("split with a failing output exited %d, want 1", code)
+	}
+	if !strings.Contains(stderr, "injected write failure") {
+		t.Errorf("split with a failing output printed %q, want the write error", stderr)
+	}
+	for i := range 2 {
+		for _, path := range []string{outputPath(prefix, i), tempOutputPath(prefix, i)} {
+			if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("split with a failing output left %s", path)
+			}
+		}
+	}
+	if matches, _ := filepath.Glob(prefix + "*"); len(matches) > 0 {
+		t.Errorf("split with a failing output left %v", matches)
+	}
+}
+
+// readOutputRows returns the data rows of the n outputs of a split at prefix, in bucket order, checking each starts with header
+func readOutputRows(t *testing.T, prefix string, n int, header string) []string {
+	t.Helper()
+	nameTemplate = "{prefix}{index}.{ext}"
+	setOutputCount(n)
+	var rows []string
+	for i := range n {
+		data, err := os.ReadFile(outputPath(prefix, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		if lines[0] != header {
+			t.Errorf("%s starts with %q, want the header %q", outputPath(prefix, i), lines[0], header)
+		}
+		rows = append(rows, lines[1:]...)
+	}
+	return rows
+}
+
+func TestOnBadSize(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.csv")
+	if err := os.WriteFile(input, []byte("id,name,size\n1,a,10\n2,b,x\n3,c,30\n4,d,\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		mode string
+		code int
+		rows []string // nil when nothing may be written
+		log  string
+	}{
+		{"fail", 1, nil, "bad size for line 2"},
+		{"skip", 0, []string{"1,a,10", "3,c,30"}, "2 with a bad size"},
+		{"zero", 0, []string{"1,a,10", "2,b,x", "3,c,30", "4,d,"}, "counting it as size 0"},
+	} {
+		prefix := filepath.Join(dir, tc.mode)
+		_, stderr, code := runBinpacking(t, "split", input, "2", prefix, "--on-bad-size", tc.mode)
+		if code != tc.code {
+			t.Errorf("--on-bad-size %s exited %d, want %d", tc.mode, code, tc.code)
+		}
+		if !strings.Contains(stderr, tc.log) {
+			t.Errorf("--on-bad-size %s logged %q, want %q", tc.mode, stderr, tc.log)
+		}
+		if tc.rows == nil {
+			if matches, _ := filepath.Glob(prefix + "*"); len(matches) > 0 {
+				t.Errorf("--on-bad-size %s left %v", tc.mode, matches)
+			}
+			continue
+		}
+		got := readOutputRows(t, prefix, 2, "id,name,size")
+		slices.Sort(got)
+		if !slices.Equal(got, tc.rows) {
+			t.Errorf("--on-bad-size %s wrote rows %v, want %v", tc.mode, got, tc.rows)
+		}
+	}
+}
+
+// TestTabDelimitedRoundTrip splits a tab-delimited file and merges it back with --delimiter '\t', checking the outputs stay tab-delimited
+func TestTabDelimitedRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.tsv")
+	var want []string
+	var b strings.Builder
+	b.WriteString("id\tname\tsize\n")
+	for i := 1; i <= 30; i++ {
+		// a comma is ordinary data in a tsv
+		row := fmt.Sprintf("%d\tlast, first %d\t%d", i, i, (i*37)%50+1)
+		want = append(want, row)
+		b.WriteString(row + "\n")
+	}
+	if err := os.WriteFile(input, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prefix := filepath.Join(dir, "out")
+	if _, stderr, code := runBinpacking(t, "split", input, "3", prefix, "--delimiter", `\t`); code != 0 {
+		t.Fatalf("split exited %d: %s", code, stderr)
+	}
+	for _, row := range readOutputRows(t, prefix, 3, "id\tname\tsize") {
+		if fields := strings.Split(row, "\t"); len(fields) != 3 || strings.Contains(row, `"`) {
+			t.Errorf("output row %q is not the 3 tab-separated fields of an input row", row)
+		}
+	}
+
+	merged := filepath.Join(dir, "merged.tsv")
+	if _, stderr, code := runBinpacking(t, "merge", prefix, merged, "--delimiter", `\t`); code != 0 {
+		t.Fatalf("merge exited %d: %s", code, stderr)
+	}
+	data, err := os.ReadFile(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if lines[0] != "id\tname\tsize" {
+		t.Errorf("merged file starts with %q, want the tab-delimited header", lines[0])
+	}
+	got := slices.Sorted(slices.Values(lines[1:]))
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("merged file holds rows %q, want %q", got, want)
+	}
+}
+
+// TestScanReportsReadError checks a read error partway through the input fails the scan with the row it stopped at, instead of ending it as if the input ended there
+func TestScanReportsReadError(t *testing.T) {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "BINPACKING_SCAN_FAILING_READER=1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Fatalf("scan of a failing reader ended with %v and printed %q, want exit code 1", err, stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "reading data row") || !strings.Contains(stderr.String(), "disk went away") {
+		t.Errorf("scan of a failing reader logged %q, want the row it failed at and the read error", stderr.String())
+	}
+}
+
+func TestOutputPathPadding(t *testing.T) {
+	nameTemplate = "{prefix}{index}.{ext}"
+	defer func() {
+		noPadIndex = false
+		setOutputCount(1)
+	}()
+	for _, tc := range []struct {
+		buckets int
+		noPad   bool
+		want    []string // the first and last outputs
+	}{
+		{1, false, []string{"out1.csv", "out1.csv"}},
+		{9, false, []string{"out1.csv", "out9.csv"}},
+		{10, false, []string{"out01.csv", "out10.csv"}},
+		{99, false, []string{"out01.csv", "out99.csv"}},
+		{100, false, []string{"out001.csv", "out100.csv"}},
+		{100, true, []string{"out1.csv", "out100.csv"}},
+	} {
+		noPadIndex = tc.noPad
+		indexWidth = 0
+		setOutputCount(tc.buckets)
+		got := []string{outputPath("out", 0), outputPath("out", tc.buckets-1)}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%d buckets (no padding %t) are named %v, want %v", tc.buckets, tc.noPad, got, tc.want)
+		}
+		// padded names list in bucket order when sorted as plain strings
+		var names []string
+		for i := range tc.buckets {
+			names = append(names, outputPath("out", i))
+		}
+		if !tc.noPad && !slices.IsSorted(names) {
+			t.Errorf("%d buckets are named %v, which don't sort in bucket order", tc.buckets, names)
+		}
+	}
+}
+
+// TestSplitIsDeterministic runs the same split twice with every built-in strategy and checks the outputs are byte-identical, on sizes with many ties
+func TestSplitIsDeterministic(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.csv")
+	var b strings.Builder
+	b.WriteString("id,name,size\n")
+	for i := 1; i <= 500; i++ {
+		fmt.Fprintf(&b, "%d,n%d,%d\n", i, i, i%7+1)
+	}
+	if err := os.WriteFile(input, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, strategy := range []string{"worst-fit", "best-fit", "kk"} {
+		var runs [2][][]byte
+		for run := range runs {
+			runDir := filepath.Join(dir, fmt.Sprintf("%s-%d", strategy, run))
+			prefix := filepath.Join(runDir, "out")
+			if _, stderr, code := runBinpacking(t, "split", input, "6", "out", "--strategy", strategy, "--output-dir", runDir); code != 0 {
+				t.Fatalf("split --strategy %s exited %d: %s", strategy, code, stderr)
+			}
+			setOutputCount(6)
+			for i := range 6 {
+				data, err := os.ReadFile(outputPath(prefix, i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				runs[run] = append(runs[run], data)
+			}
+		}
+		for i := range runs[0] {
+			if !bytes.Equal(runs[0][i], runs[1][i]) {
+				t.Errorf("split --strategy %s wrote %s differently on the second run", strategy, outputPath("out", i))
+			}
+		}
+	}
+}
+
+func TestSizeColumnByIndexAndName(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.csv")
+	if err := os.WriteFile(input, []byte("name,bytes,id\na,10,1\nb,20,2\nc,5,3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{10, 20, 5}
+	for _, column := range []string{"1", "bytes"} {
+		metas := scan(context.Background(), input, ScanOptions{Format: "csv", Size: SizeSpec{Column: column}})
+		var got []int64
+		for _, meta := range metas {
+			got = append(got, meta.Size)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("--size-column %s read sizes %v, want %v", column, got, want)
+		}
+	}
+}
+
+func TestSizeColumnNotFound(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.csv")
+	if err := os.WriteFile(input, []byte("name,bytes,id\na,10,1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		column string
+		want   string
+	}{
+		{"size", `column "size" not found in header`},
+		{"7", "column index 7 out of range, header has 3 columns"},
+	} {
+		for _, args := range [][]string{
+			{"split", input, "2", filepath.Join(dir, "out"), "-c", tc.column},
+			{"inspect", input, "-c", tc.column},
+		} {
+			_, stderr, code := runBinpacking(t, args...)
+			if code != 1 || !strings.Contains(stderr, tc.want) {
+				t.Errorf("%s --size-column %s exited %d and logged %q, want exit code 1 and %q", args[0], tc.column, code, stderr, tc.want)
+			}
+		}
+	}
+}
+
+// TestGzipSplitRoundTrip splits a gzipped input into --compress outputs and reads them back
+func TestGzipSplitRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	plain, want := writeCSV(t, dir, 300)
+	data, err := os.ReadFile(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "in.csv.gz")
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(input, zipped.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := filepath.Join(dir, "out")
+	if _, stderr, code := runBinpacking(t, "split", input, "3", prefix, "--compress"); code != 0 {
+		t.Fatalf("split exited %d: %s", code, stderr)
+	}
+	var got []string
+	for i := 1; i <= 3; i++ {
+		path := fmt.Sprintf("%s%d.csv.gz", prefix, i)
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		// reading to the end checks the gzip trailer made it to the file
+		out, err := io.ReadAll(zr)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+		if lines[0] != "id,name,size" {
+			t.Errorf("%s starts with %q, want the header", path, lines[0])
+		}
+		got = append(got, lines[1:]...)
+	}
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("outputs hold rows %v, want %v", got, want)
+	}
+}
+
+// TestSizeBytesMatchesOutputs packs by --size bytes, with fields that need quoting, and checks every output's packed size is its data rows' bytes on disk
+func TestSizeBytesMatchesOutputs(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.csv")
+	header := "id,name,note\n"
+	var b strings.Builder
+	b.WriteString(header)
+	for i := 1; i <= 200; i++ {
+		fmt.Fprintf(&b, "%d,%s,\"say \"\"hi\"\", %d\"\n", i, strings.Repeat("n", i%13), i)
+	}
+	if err := os.WriteFile(input, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{"4"}, {"--target-size", "2KB"}} {
+		prefix := filepath.Join(dir, fmt.Sprintf("out%d_", len(args)))
+		cli := append([]string{"split", input}, args...)
+		if _, stderr, code := runBinpacking(t, append(cli, prefix, "--size", "bytes")...); code != 0 {
+			t.Fatalf("split %v exited %d: %s", args, code, stderr)
+		}
+		data, err := os.ReadFile(prefix + manifestName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var manifest manifestDoc
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range manifest.Files {
+			info, err := os.Stat(file.File)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if onDisk := info.Size() - int64(len(header)); file.TotalSize != onDisk {
+				t.Errorf("split %v packed %d bytes into %s, which holds %d bytes of rows", args, file.TotalSize, file.File, onDisk)
+			}
+			if len(args) == 2 && file.TotalSize > 2048 {
+				t.Errorf("split %v put %d bytes of rows in %s, over the target size", args, file.TotalSize, file.File)
+			}
+		}
+	}
+}
+
+// TestOutputsKeepInputOrder checks every output lists its rows in ascending input order, also when --max-open-files has writers share outputs
+func TestOutputsKeepInputOrder(t *testing.T) {
+	for _, maxOpen := range []int{0, 2} {
+		dir := t.TempDir()
+		input, _ := writeCSV(t, dir, 400)
+		prefix := filepath.Join(dir, "out")
+		splitCSV(t, input, prefix, 5, WriteOptions{PreserveOrder: true, MaxOpenFiles: maxOpen})
+		for i := range 5 {
+			data, err := os.ReadFile(outputPath(prefix, i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			last := 0
+			for _, row := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")[1:] {
+				id, err := strconv.Atoi(strings.Split(row, ",")[0])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if id <= last {
+					t.Errorf("with --max-open-files %d, %s lists row %d after row %d", maxOpen, outputPath(prefix, i), id, last)
+				}
+				last = id
+			}
+		}
+	}
+}
+
+// TestInterruptedWriteLeavesNoOutputs interrupts a split partway through the write pass, as Ctrl-C would, and checks it exits non-zero leaving no partial outputs
+func TestInterruptedWriteLeavesNoOutputs(t *testing.T) {
+	dir := t.TempDir()
+	input, _ := writeCSV(t, dir, 50_000)
+	prefix := filepath.Join(dir, "out")
+	t.Setenv("BINPACKING_INTERRUPT_WRITE", "2")
+	_, stderr, code := runBinpacking(t, "split", input, "3", prefix)
+	if code != 1 {
+		t.Errorf("interrupted split exited %d, want 1", code)
+	}
+	if !strings.Contains(stderr, "[write] cancelled") {
+		t.Errorf("interrupted split logged %q, want the write pass cancelled", stderr)
+	}
+	if matches, _ := filepath.Glob(prefix + "*"); len(matches) > 0 {
+		t.Errorf("interrupted split left %v", matches)
+	}
+}
+
+// outputRowCounts returns how many data rows each of the n outputs at prefix holds
+func outputRowCounts(t *testing.T, prefix string, n int) []int {
+	t.Helper()
+	setOutputCount(n)
+	counts := make([]int, n)
+	for i := range n {
+		data, err := os.ReadFile(outputPath(prefix, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[i] = strings.Count(string(data), "\n") - 1
+	}
+	return counts
+}
+
+func TestSplitByLines(t *testing.T) {
+	dir := t.TempDir()
+	input, _ := writeCSV(t, dir, 103)
+	prefix := filepath.Join(dir, "out")
+	if _, stderr, code := runBinpacking(t, "split", input, "4", prefix, "--by", "lines"); code != 0 {
+		t.Fatalf("split exited %d: %s", code, stderr)
+	}
+	// 103 rows over 4 buckets are 26, 26, 26 and 25 whatever the sizes
+	counts := outputRowCounts(t, prefix, 4)
+	slices.Sort(counts)
+	if !slices.Equal(counts, []int{25, 26, 26, 26}) {
+		t.Errorf("--by lines wrote %v rows per output, want 25 or 26 in each", counts)
+	}
+}
+
+func TestSplitMaxLines(t *testing.T) {
+	dir := t.TempDir()
+	input, _ := writeCSV(t, dir, 103)
+	prefix := filepath.Join(dir, "out")
+	if _, stderr, code := runBinpacking(t, "split", input, prefix, "--max-lines", "30"); code != 0 {
+		t.Fatalf("split exited %d: %s", code, stderr)
+	}
+	// 103 rows at most 30 per output need 4 outputs
+	if _, err := os.Stat(fmt.Sprintf("%s5.csv", prefix)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("--max-lines 30 wrote a fifth output for 103 rows")
+	}
+	total := 0
+	for i, n := range outputRowCounts(t, prefix, 4) {
+		if n > 30 {
+			t.Errorf("--max-lines 30 put %d rows in output %d", n, i+1)
+		}
+		total += n
+	}
+	if total != 103 {
+		t.Errorf("--max-lines 30 wrote %d rows, want 103", total)
+	}
+}
+
+func TestJSONLSizeField(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		size int64
+		err  string
+	}{
+		{`{"meta":{"bytes":42},"id":1}`, 42, ""},
+		{`{"meta":{"inner":{"bytes":7}}}`, 0, "no field meta.bytes"},
+		{`{"id":2}`, 0, "no field meta"},
+		{`{"meta":5}`, 0, "meta is not an object"},
+		{`{"meta":{"bytes":"12"}}`, 0, "field meta.bytes is not a number"},
+		{`[1,2]`, 0, "line is not a JSON object"},
+		{`{"meta":`, 0, "invalid JSON"},
+	} {
+		r := newJSONLRecordReader(strings.NewReader(tc.line+"\n"), SizeSpec{Field: "meta.bytes"})
+		record, size, err := r.Read()
+		if !slices.Equal(record, []string{tc.line}) {
+			t.Errorf("read %q as record %q, want the line itself", tc.line, record)
+		}
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("reading the size of %q: %v", tc.line, err)
+		case tc.err == "" && size != tc.size:
+			t.Errorf("read size %d from %q, want %d", size, tc.line, tc.size)
+		case tc.err != "" && (!errors.Is(err, ErrBadSize) || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("reading the size of %q returned %v, want a bad size error saying %q", tc.line, err, tc.err)
+		}
+	}
+}
+
+// TestJSONLSplit splits JSON Lines by a nested size field and by --size bytes, checking the outputs hold the input's lines unchanged and leave out the line without the field
+func TestJSONLSplit(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.jsonl")
+	var lines []string
+	for i := 1; i <= 20; i++ {
+		lines = append(lines, fmt.Sprintf(`{"id":%d,"meta":{"bytes":%d}}`, i, i*10))
+	}
+	missing := `{"id":21,"meta":{}}`
+	if err := os.WriteFile(input, []byte(strings.Join(append(lines, missing), "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		sizeArgs []string
+		want     []string
+	}{
+		{[]string{"--size-field", "meta.bytes"}, lines},
+		{[]string{"--size", "bytes"}, append(slices.Clone(lines), missing)},
+	} {
+		prefix := filepath.Join(dir, tc.sizeArgs[0][2:]+"_")
+		args := append([]string{"split", input, "3", prefix, "--format", "jsonl"}, tc.sizeArgs...)
+		if _, stderr, code := runBinpacking(t, args...); code != 0 {
+			t.Fatalf("split %v exited %d: %s", tc.sizeArgs, code, stderr)
+		}
+		var got []string
+		for i := 1; i <= 3; i++ {
+			data, err := os.ReadFile(fmt.Sprintf("%s%d.jsonl", prefix, i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")...)
+		}
+		slices.Sort(got)
+		want := slices.Sorted(slices.Values(tc.want))
+		if !slices.Equal(got, want) {
+			t.Errorf("split %v wrote lines %q, want %q", tc.sizeArgs, got, want)
+		}
+	}
+}
+
+func TestSizeDistribution(t *testing.T) {
+	// 1 to 100, shuffled
+	var sizes []int64
+	for i := int64(0); i < 100; i++ {
+		sizes = append(sizes, (i*37)%100+1)
+	}
+	d := sizeDistribution(sizes, 10, false)
+	want := map[string]int64{"p50": 50, "p90": 90, "p99": 99, "max": 100}
+	for name, size := range want {
+		if d.Percentiles[name] != size {
+			t.Errorf("%s is %d, want %d", name, d.Percentiles[name], size)
+		}
+	}
+	if d.Rows != 100 || d.Min != 1 || d.Max != 100 || d.TotalSize != 5050 || d.Mean != 50.5 {
+		t.Errorf("got %d rows, min %d, max %d, total %d, mean %g, want 100, 1, 100, 5050 and 50.5", d.Rows, d.Min, d.Max, d.TotalSize, d.Mean)
+	}
+	if len(d.Bins) != 10 {
+		t.Fatalf("got %d bins, want 10", len(d.Bins))
+	}
+	for i, bin := range d.Bins {
+		if bin.From != int64(i*10+1) || bin.To != int64(i*10+11) || bin.Count != 10 {
+			t.Errorf("bin %d is %+v, want [%d, %d) holding 10 rows", i, bin, i*10+1, i*10+11)
+		}
+	}
+}
+
+func TestSizeDistributionLogBins(t *testing.T) {
+	var sizes []int64
+	for i := int64(1); i <= 1000; i++ {
+		sizes = append(sizes, i)
+	}
+	d := sizeDistribution(sizes, 3, true)
+	want := []SizeBin{{1, 11, 10}, {11, 101, 90}, {101, 1001, 900}}
+	if !slices.Equal(d.Bins, want) {
+		t.Errorf("got log bins %v, want %v", d.Bins, want)
+	}
+}
+
+func TestHistogramJSON(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.csv")
+	var b strings.Builder
+	b.WriteString("id,name,size\n")
+	for i := 1; i <= 100; i++ {
+		fmt.Fprintf(&b, "%d,n%d,%d\n", i, i, i)
+	}
+	if err := os.WriteFile(input, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, code := runBinpacking(t, "histogram", input, "--json")
+	if code != 0 {
+		t.Fatalf("histogram exited %d: %s", code, stderr)
+	}
+	var d SizeDistribution
+	if err := json.Unmarshal([]byte(stdout), &d); err != nil {
+		t.Fatalf("histogram --json printed %q: %v", stdout, err)
+	}
+	if d.Rows != 100 || d.Percentiles["p90"] != 90 || d.Percentiles["max"] != 100 {
+		t.Errorf("histogram --json reported %d rows with p90 %d and max %d, want 100, 90 and 100", d.Rows, d.Percentiles["p90"], d.Percentiles["max"])
+	}
+}
+
+// TestResumeAfterCrash kills a checkpointed split past its first watermark, resumes it and checks the outputs are what an uninterrupted split writes, with no row lost or written twice around the crash
+func TestResumeAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	input, _ := writeCSV(t, dir, 200_000)
+	wantDir, prefix := filepath.Join(dir, "want"), filepath.Join(dir, "out")
+	if err := os.Mkdir(wantDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, stderr, code := runBinpacking(t, "split", input, "2", filepath.Join(wantDir, "out")); code != 0 {
+		t.Fatalf("split exited %d: %s", code, stderr)
+	}
+
+	// each output takes about 100k rows in 4KB writes, the first watermark comes at 65536 rows
+	t.Setenv("BINPACKING_CRASH_WRITE", "350")
+	if _, stderr, code := runBinpacking(t, "split", input, "2", prefix, "--checkpoint"); code != 2 {
+		t.Fatalf("crashing split exited %d, want 2: %s", code, stderr)
+	}
+	if _, err := os.Stat(checkpointPath(prefix)); err != nil {
+		t.Fatalf("crashed split left no checkpoint: %v", err)
+	}
+	os.Unsetenv("BINPACKING_CRASH_WRITE")
+	_, stderr, code := runBinpacking(t, "split", input, "2", prefix, "--checkpoint", "--resume")
+	if code != 0 {
+		t.Fatalf("resumed split exited %d: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "written up to line") {
+		t.Errorf("resumed split logged %q, want it to carry on from a watermark", stderr)
+	}
+	for i := range 2 {
+		got, err := os.ReadFile(prefix + strconv.Itoa(i+1) + ".csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := os.ReadFile(filepath.Join(wantDir, "out"+strconv.Itoa(i+1)+".csv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("resumed output %d has %d bytes, differing from the %d an uninterrupted split writes", i+1, len(got), len(want))
+		}
+	}
+	if _, err := os.Stat(checkpointPath(prefix)); err == nil {
+		t.Error("finished split kept its checkpoint")
+	}
+}
+
+// TestArchiveSplit unpacks the tar an --archive split writes and checks it holds every output and the manifest, and nothing is left beside it
+func TestArchiveSplit(t *testing.T) {
+	dir := t.TempDir()
+	input, want := writeCSV(t, dir, 300)
+	prefix := filepath.Join(dir, "out")
+	if _, stderr, code := runBinpacking(t, "split", input, "3", prefix, "--archive"); code != 0 {
+		t.Fatalf("split --archive exited %d: %s", code, stderr)
+	}
+	if matches, _ := filepath.Glob(prefix + "*"); !slices.Equal(matches, []string{archivePath(prefix)}) {
+		t.Errorf("split --archive left %v, want the archive alone", matches)
+	}
+
+	f, err := os.Open(archivePath(prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	members := map[string][]byte{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		members[hdr.Name] = data
+	}
+
+	var manifest manifestDoc
+	if err := json.Unmarshal(members["out"+manifestName], &manifest); err != nil {
+		t.Fatalf("archive member out%s: %v", manifestName, err)
+	}
+	if len(manifest.Files) != 3 {
+		t.Fatalf("manifest lists %d files, want 3", len(manifest.Files))
+	}
+	var got []string
+	for i := range 3 {
+		name := "out" + strconv.Itoa(i+1) + ".csv"
+		data, ok := members[name]
+		if !ok {
+			t.Fatalf("archive has no member %s, only %v", name, slices.Sorted(maps.Keys(members)))
+		}
+		lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		if lines[0] != "id,name,size" {
+			t.Errorf("member %s starts with %q, want the header", name, lines[0])
+		}
+		if len(lines)-1 != manifest.Files[i].Lines {
+			t.Errorf("member %s has %d rows, the manifest says %d", name, len(lines)-1, manifest.Files[i].Lines)
+		}
+		got = append(got, lines[1:]...)
+	}
+	if len(members) != 4 {
+		t.Errorf("archive has members %v, want the 3 outputs and the manifest", slices.Sorted(maps.Keys(members)))
+	}
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("archive members hold %d rows, differing from the input's %d", len(got), len(want))
+	}
+}
+
+// TestPartitionByKeepsKeysTogether hash-partitions rows on a user column and checks every user's rows share one output, the one its key hashes to
+func TestPartitionByKeepsKeysTogether(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.csv")
+	var b strings.Builder
+	b.WriteString("id,user,size\n")
+	for i := 1; i <= 500; i++ {
+		fmt.Fprintf(&b, "%d,u%d,%d\n", i, (i*7)%23, i%9+1)
+	}
+	if err := os.WriteFile(input, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prefix := filepath.Join(dir, "out")
+	_, stderr, code := runBinpacking(t, "split", input, "4", prefix, "--partition-by", "user")
+	if code != 0 {
+		t.Fatalf("split --partition-by exited %d: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "keys per bucket") {
+		t.Errorf("split --partition-by logged %q, want the keys per bucket", stderr)
+	}
+
+	nameTemplate = "{prefix}{index}.{ext}"
+	setOutputCount(4)
+	keyOutput := map[string]int{}
+	rows := 0
+	for i := range 4 {
+		data, err := os.ReadFile(outputPath(prefix, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")[1:] {
+			key := strings.Split(line, ",")[1]
+			if first, ok := keyOutput[key]; ok && first != i {
+				t.Errorf("key %s is in outputs %d and %d", key, first+1, i+1)
+			}
+			keyOutput[key] = i
+			rows++
+		}
+	}
+	if rows != 500 || len(keyOutput) != 23 {
+		t.Errorf("outputs hold %d rows with %d keys, want 500 and 23", rows, len(keyOutput))
+	}
+	for key, i := range keyOutput {
+		if want := int(partitionHash(key, 4)); i != want {
+			t.Errorf("key %s is in output %d, its hash picks %d", key, i+1, want+1)
+		}
+	}
+}
+
+func TestSplitMoreBucketsThanRows(t *testing.T) {
+	dir := t.TempDir()
+	input, _ := writeCSV(t, dir, 3)
+	prefix := filepath.Join(dir, "out")
+	_, stderr, code := runBinpacking(t, "split", input, "5", prefix)
+	if code != 0 {
+		t.Fatalf("split of 3 rows into 5 buckets exited %d: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "5 buckets but only 3 data rows, at least 2 outputs will have no rows") {
+		t.Errorf("split of 3 rows into 5 buckets logged %q, want a warning about the empty outputs", stderr)
+	}
+	if got := outputRowCounts(t, prefix, 5); !slices.Equal(got, []int{1, 1, 1, 0, 0}) {
+		t.Errorf("outputs hold %v rows, want 1, 1, 1 and two empty", got)
+	}
+
+	strict := filepath.Join(dir, "strict")
+	_, stderr, code = runBinpacking(t, "split", input, "5", strict, "--allow-empty-buckets=false")
+	if code != 1 {
+		t.Errorf("split with --allow-empty-buckets=false exited %d, want 1", code)
+	}
+	if !strings.Contains(stderr, "5 buckets but only 3 data rows, 2 outputs would be empty: pass at most 3 buckets") {
+		t.Errorf("split with --allow-empty-buckets=false printed %q, want the bucket count it can take", stderr)
+	}
+	if matches, _ := filepath.Glob(strict + "*"); len(matches) > 0 {
+		t.Errorf("split with --allow-empty-buckets=false left %v", matches)
+	}
+}
+
+// TestFilter splits with --filter and checks the outputs hold the matching rows alone and the rejected file the rest
+func TestFilter(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.csv")
+	var b strings.Builder
+	b.WriteString("id,status,size\n")
+	statuses := []string{"active", "gone", "new"}
+	for i := 1; i <= 60; i++ {
+		fmt.Fprintf(&b, "%d,%s,%d\n", i, statuses[i%3], i)
+	}
+	if err := os.WriteFile(input, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		filters []string
+		keep    func(id int, status string) bool
+	}{
+		{[]string{"status=active"}, func(_ int, status string) bool { return status == "active" }},
+		{[]string{"status!=gone"}, func(_ int, status string) bool { return status != "gone" }},
+		{[]string{"size>40"}, func(id int, _ string) bool { return id > 40 }},
+		{[]string{"status!=gone", "size<=30", "id>=10"}, func(id int, status string) bool { return status != "gone" && id <= 30 && id >= 10 }},
+	} {
+		prefix := filepath.Join(t.TempDir(), "out")
+		args := []string{"split", input, "3", prefix, "--reject-file"}
+		for _, f := range tc.filters {
+			args = append(args, "--filter", f)
+		}
+		if _, stderr, code := runBinpacking(t, args...); code != 0 {
+			t.Fatalf("split --filter %v exited %d: %s", tc.filters, code, stderr)
+		}
+		var kept, rejected []string
+		var keptSize int64
+		for i := 1; i <= 60; i++ {
+			row := fmt.Sprintf("%d,%s,%d", i, statuses[i%3], i)
+			if tc.keep(i, statuses[i%3]) {
+				kept = append(kept, row)
+				keptSize += int64(i)
+			} else {
+				rejected = append(rejected, row)
+			}
+		}
+		got := readOutputRows(t, prefix, 3, "id,status,size")
+		slices.Sort(got)
+		slices.Sort(kept)
+		if !slices.Equal(got, kept) {
+			t.Errorf("--filter %v: outputs hold %v, want %v", tc.filters, got, kept)
+		}
+		data, err := os.ReadFile(prefix + rejectedName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "id,status,size\n" + strings.Join(rejected, "\n") + "\n"; string(data) != want {
+			t.Errorf("--filter %v: %s holds %q, want %q", tc.filters, rejectedName, data, want)
+		}
+		// filtered rows take no room in the buckets
+		manifest, err := readManifest(prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var packed int64
+		for _, f := range manifest {
+			packed += f.TotalSize
+		}
+		if packed != keptSize {
+			t.Errorf("--filter %v: buckets hold %d, the matching rows %d", tc.filters, packed, keptSize)
+		}
+	}
+}
+
+// TestAppendSplit splits an input and appends a second batch to its outputs, checking every row is there once, the header only heads each file and the buckets stay balanced
+func TestAppendSplit(t *testing.T) {
+	dir := t.TempDir()
+	first, want := writeCSV(t, dir, 100)
+	second := filepath.Join(dir, "more.csv")
+	var b strings.Builder
+	b.WriteString("id,name,size\n")
+	for i := 101; i <= 250; i++ {
+		row := fmt.Sprintf("%d,n%d,%d", i, i, (i*37)%50+1)
+		want = append(want, row)
+		b.WriteString(row + "\n")
+	}
+	if err := os.WriteFile(second, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prefix := filepath.Join(dir, "out")
+	if _, stderr, code := runBinpacking(t, "split", first, "3", prefix); code != 0 {
+		t.Fatalf("split exited %d: %s", code, stderr)
+	}
+	if _, stderr, code := runBinpacking(t, "split", second, "3", prefix, "--append"); code != 0 {
+		t.Fatalf("split --append exited %d: %s", code, stderr)
+	}
+
+	got := readOutputRows(t, prefix, 3, "id,name,size")
+	for _, row := range got {
+		if row == "id,name,size" {
+			t.Fatal("an appended output repeats the header")
+		}
+	}
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("outputs hold %d rows, differing from the %d of both batches", len(got), len(want))
+	}
+
+	manifest, err := readManifest(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buckets []FileBucket
+	for i := range 3 {
+		var size int64
+		data, err := os.ReadFile(outputPath(prefix, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")[1:]
+		for _, line := range lines {
+			n, _ := strconv.ParseInt(line[strings.LastIndex(line, ",")+1:], 10, 64)
+			size += n
+		}
+		if manifest[i+1].TotalSize != size || manifest[i+1].Lines != len(lines) {
+			t.Errorf("output %d holds %d rows of size %d, the manifest says %d of %d", i+1, len(lines), size, manifest[i+1].Lines, manifest[i+1].TotalSize)
+		}
+		buckets = append(buckets, FileBucket{TotalSize: size, Lines: len(lines)})
+	}
+	// the second batch is packed around the first one's load, so no bucket ends up more than a row's size ahead
+	if spread := binpack.Imbalance(buckets); spread > 50 {
+		t.Errorf("appended outputs %v are %d apart, more than the largest row", buckets, spread)
+	}
+}
+
+// outputSizes returns the sum of the last column over each of the n outputs at prefix, the size writeCSV inputs give their rows
+func outputSizes(t *testing.T, prefix string, n int) []int64 {
+	t.Helper()
+	setOutputCount(n)
+	sizes := make([]int64, n)
+	for i := range n {
+		data, err := os.ReadFile(outputPath(prefix, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")[1:] {
+			size, err := strconv.ParseInt(line[strings.LastIndex(line, ",")+1:], 10, 64)
+			if err != nil {
+				t.Fatalf("%s: %v", outputPath(prefix, i), err)
+			}
+			sizes[i] += size
+		}
+	}
+	return sizes
+}
+
+// TestMaxBucketSize packs 40, 40, 40, 30 and 20 into 2 buckets under a cap of 60, where only 40+20 and 40 fit
+func TestMaxBucketSize(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.csv")
+	if err := os.WriteFile(input, []byte("id,name,size\n1,a,40\n2,b,40\n3,c,40\n4,d,30\n5,e,20\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	nameTemplate = "{prefix}{index}.{ext}"
+
+	prefix := filepath.Join(dir, "file")
+	_, stderr, code := runBinpacking(t, "split", input, "2", prefix, "--max-bucket-size", "60B")
+	if code != 0 {
+		t.Fatalf("split --overflow file exited %d: %s", code, stderr)
+	}
+	if got := outputSizes(t, prefix, 2); !slices.Equal(got, []int64{60, 40}) {
+		t.Errorf("--overflow file packed %v, want 60 and 40", got)
+	}
+	data, err := os.ReadFile(prefix + oversizedName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "id,name,size\n3,c,40\n4,d,30\n"; string(data) != want {
+		t.Errorf("%s holds %q, want %q", oversizedName, data, want)
+	}
+
+	prefix = filepath.Join(dir, "bucket")
+	_, stderr, code = runBinpacking(t, "split", input, "2", prefix, "--max-bucket-size", "60B", "--overflow", "bucket")
+	if code != 0 {
+		t.Fatalf("split --overflow bucket exited %d: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "added 2 overflow buckets to keep every bucket under 60") {
+		t.Errorf("split --overflow bucket logged %q, want the buckets it added", stderr)
+	}
+	if got := outputSizes(t, prefix, 4); !slices.Equal(got, []int64{40, 40, 40, 50}) {
+		t.Errorf("--overflow bucket packed %v, want 40, 40, 40 and 50", got)
+	}
+	if _, err := os.Stat(prefix + oversizedName); err == nil {
+		t.Errorf("--overflow bucket wrote %s", oversizedName)
+	}
+
+	prefix = filepath.Join(dir, "fail")
+	_, stderr, code = runBinpacking(t, "split", input, "2", prefix, "--max-bucket-size", "60B", "--overflow", "fail")
+	if code != 1 || !strings.Contains(stderr, "2 of 2 buckets are over --max-bucket-size 60") {
+		t.Errorf("split --overflow fail exited %d and printed %q, want it to fail on both buckets", code, stderr)
+	}
+}
+
+func TestMaxBucketSizeRejectsLargerRow(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.csv")
+	if err := os.WriteFile(input, []byte("id,name,size\n1,a,400\n2,b,40\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, mode := range []string{overflowFile, overflowBucket} {
+		prefix := filepath.Join(dir, mode)
+		_, stderr, code := runBinpacking(t, "split", input, "2", prefix, "--max-bucket-size", "60B", "--overflow", mode)
+		if code != 1 || !strings.Contains(stderr, "line 1 of size 400 is larger than the bucket size cap 60") {
+			t.Errorf("split --overflow %s exited %d and printed %q, want it to reject the row over the cap", mode, code, stderr)
+		}
+		if matches, _ := filepath.Glob(prefix + "*"); len(matches) > 0 {
+			t.Errorf("split --overflow %s left %v", mode, matches)
+		}
+	}
+}
+
+// TestChecksums checks the digests --checksum records against the bytes of the outputs, and has sha256sum -c check them too where it is installed
+func TestChecksums(t *testing.T) {
+	dir := t.TempDir()
+	input, _ := writeCSV(t, dir, 500)
+	for _, algo := range []string{"sha256", "crc32c"} {
+		prefix := filepath.Join(dir, algo)
+		if _, stderr, code := runBinpacking(t, "split", input, "3", prefix, "--checksum", algo); code != 0 {
+			t.Fatalf("split --checksum %s exited %d: %s", algo, code, stderr)
+		}
+		manifest, err := readManifest(prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nameTemplate = "{prefix}{index}.{ext}"
+		setOutputCount(3)
+		var sums strings.Builder
+		for i := range 3 {
+			data, err := os.ReadFile(outputPath(prefix, i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := newChecksum(algo)
+			h.Write(data)
+			digest := hex.EncodeToString(h.Sum(nil))
+			if got, want := manifest[i+1].Checksum, algo+":"+digest; got != want {
+				t.Errorf("manifest records %s for %s, its bytes hash to %s", got, outputPath(prefix, i), want)
+			}
+			fmt.Fprintf(&sums, "%s  %s\n", digest, filepath.Base(outputPath(prefix, i)))
+		}
+
+		data, err := os.ReadFile(prefix + checksumsName)
+		if algo != "sha256" {
+			if err == nil {
+				t.Errorf("--checksum %s wrote %s", algo, checksumsName)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != sums.String() {
+			t.Errorf("%s holds %q, want %q", checksumsName, data, sums.String())
+		}
+		if _, err := exec.LookPath("sha256sum"); err != nil {
+			continue
+		}
+		check := exec.Command("sha256sum", "-c", filepath.Base(prefix+checksumsName))
+		check.Dir = dir
+		if out, err := check.CombinedOutput(); err != nil {
+			t.Errorf("sha256sum -c %s: %v: %s", checksumsName, err, out)
+		}
+	}
+}
+
+// TestHeaderRows splits inputs with no header, one header row and two, checking every output starts with the header rows alone and inspect counts the same data rows
+func TestHeaderRows(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		header []string
+		args   []string
+	}{
+		{"no header", nil, []string{"--no-header"}},
+		{"zero header rows", nil, []string{"--header-rows", "0"}},
+		{"one header row", []string{"id,name,size"}, nil},
+		{"two header rows", []string{"id,name,size", "int,text,bytes"}, []string{"--header-rows", "2"}},
+	} {
+		dir := t.TempDir()
+		input := filepath.Join(dir, "in.csv")
+		var b strings.Builder
+		var want []string
+		for _, row := range tc.header {
+			b.WriteString(row + "\n")
+		}
+		for i := 1; i <= 40; i++ {
+			row := fmt.Sprintf("%d,n%d,%d", i, i, i%7+1)
+			want = append(want, row)
+			b.WriteString(row + "\n")
+		}
+		if err := os.WriteFile(input, []byte(b.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		prefix := filepath.Join(dir, "out")
+		if _, stderr, code := runBinpacking(t, append([]string{"split", input, "3", prefix}, tc.args...)...); code != 0 {
+			t.Fatalf("%s: split exited %d: %s", tc.name, code, stderr)
+		}
+		nameTemplate = "{prefix}{index}.{ext}"
+		setOutputCount(3)
+		var got []string
+		for i := range 3 {
+			data, err := os.ReadFile(outputPath(prefix, i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+			if !slices.Equal(lines[:len(tc.header)], tc.header) {
+				t.Errorf("%s: %s starts with %q, want %q", tc.name, outputPath(prefix, i), lines[:len(tc.header)], tc.header)
+			}
+			got = append(got, lines[len(tc.header):]...)
+		}
+		slices.Sort(got)
+		slices.Sort(want)
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: outputs hold data rows %v, want %v", tc.name, got, want)
+		}
+
+		stdout, stderr, code := runBinpacking(t, append([]string{"inspect", input}, tc.args...)...)
+		if code != 0 {
+			t.Fatalf("%s: inspect exited %d: %s", tc.name, code, stderr)
+		}
+		if !strings.Contains(stdout, "Total lines: 40, Total size: 160 bytes") {
+			t.Errorf("%s: inspect printed %q, want 40 data rows of size 160", tc.name, stdout)
+		}
+	}
+}
+
+// TestColumns projects the outputs with --columns and checks each holds the rows an unprojected split puts there, with the columns asked for in order
+func TestColumns(t *testing.T) {
+	dir := t.TempDir()
+	input, _ := writeCSV(t, dir, 60)
+	base := filepath.Join(dir, "base")
+	if _, stderr, code := runBinpacking(t, "split", input, "3", base); code != 0 {
+		t.Fatalf("split exited %d: %s", code, stderr)
+	}
+	baseManifest, err := readManifest(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nameTemplate = "{prefix}{index}.{ext}"
+	setOutputCount(3)
+	for _, tc := range []struct {
+		columns string
+		pick    []int
+	}{
+		{"size,name,id", []int{2, 1, 0}},
+		{"0,2", []int{0, 2}},
+		// the size column is left out of the outputs but still sizes the rows
+		{"name", []int{1}},
+	} {
+		prefix := filepath.Join(dir, "out")
+		if _, stderr, code := runBinpacking(t, "split", input, "3", prefix, "--columns", tc.columns, "--overwrite"); code != 0 {
+			t.Fatalf("split --columns %s exited %d: %s", tc.columns, code, stderr)
+		}
+		manifest, err := readManifest(prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range 3 {
+			if got, want := manifest[i+1], baseManifest[i+1]; got.TotalSize != want.TotalSize || !slices.Equal(got.LineRanges, want.LineRanges) {
+				t.Errorf("--columns %s: bucket %d packed %d in %v, without it %d in %v", tc.columns, i+1, got.TotalSize, got.LineRanges, want.TotalSize, want.LineRanges)
+			}
+			data, err := os.ReadFile(outputPath(base, i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []string
+			for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+				fields := strings.Split(line, ",")
+				var projected []string
+				for _, c := range tc.pick {
+					projected = append(projected, fields[c])
+				}
+				want = append(want, strings.Join(projected, ","))
+			}
+			want = append(want, "")
+			if data, err = os.ReadFile(outputPath(prefix, i)); err != nil {
+				t.Fatal(err)
+			}
+			if got := string(data); got != strings.Join(want, "\n") {
+				t.Errorf("--columns %s: %s holds %q, want %q", tc.columns, outputPath(prefix, i), got, strings.Join(want, "\n"))
+			}
+		}
+	}
+}
+
+// TestWriteSkipsUnmappedRow writes with a gap in the assignment and checks the row is left out of every output while the run carries on without --strict
+func TestWriteSkipsUnmappedRow(t *testing.T) {
+	dir := t.TempDir()
+	input, rows := writeCSV(t, dir, 30)
+	nameTemplate = "{prefix}{index}.{ext}"
+	ctx := context.Background()
+	metas := scan(ctx, input, ScanOptions{Format: "csv"})
+	setOutputCount(2)
+	buckets, assign, err := pack(ctx, metas, 2, PackOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assign[7] = 0
+	prefix := filepath.Join(dir, "out")
+	write(ctx, input, prefix, buckets, assign, WriteOptions{Format: "csv", ChannelBuffer: defaultChannelBuffer, ExpectedRecords: len(metas)})
+	got := readOutputRows(t, prefix, 2, "id,name,size")
+	want := slices.Delete(slices.Clone(rows), 6, 7)
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("outputs hold %v, want every row but line 7", got)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	// lines 1 to 10, line 4 in no bucket
+	assign := make(Assignment, 11)
+	for line := range assign {
+		if line != 0 && line != 4 {
+			assign[line] = 1
+		}
+	}
+	for _, tc := range []struct {
+		c      rowCounts
+		strict bool
+		want   string
+	}{
+		{rowCounts{routed: 9, skipped: 1}, false, ""},
+		{rowCounts{routed: 9, skipped: 1}, true, "--strict: 1 rows were in no bucket and left out of every output"},
+		{rowCounts{routed: 8, filtered: 1, skipped: 1}, true, "--strict: the assignment maps 9 rows to buckets but 8 were routed"},
+		{rowCounts{routed: 8, skipped: 1, outOfRange: 1}, false, "1 rows were assigned to buckets that don't exist"},
+		{rowCounts{routed: 8, skipped: 1}, false, "1 of 10 data rows are unaccounted for"},
+		{rowCounts{routed: 9, skipped: 2}, false, "-1 of 10 data rows are unaccounted for"},
+	} {
+		if got := reconcile(10, assign, tc.c, tc.strict); got != tc.want {
+			t.Errorf("reconcile(%+v, strict %t) = %q, want %q", tc.c, tc.strict, got, tc.want)
+		}
+	}
+}
+
+// TestRebalance evens out a pair of outputs holding 300 and 20 into three files, which rows of size 10 can only get within one row of each other
+func TestRebalance(t *testing.T) {
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "out")
+	var want []string
+	for i, rows := range []int{30, 2} {
+		var b strings.Builder
+		b.WriteString("id,name,size\n")
+		for j := range rows {
+			row := fmt.Sprintf("%d%02d,n,10", i+1, j)
+			want = append(want, row)
+			b.WriteString(row + "\n")
+		}
+		if err := os.WriteFile(prefix+strconv.Itoa(i+1)+".csv", []byte(b.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, stderr, code := runBinpacking(t, "rebalance", prefix, "3"); code != 0 {
+		t.Fatalf("rebalance exited %d: %s", code, stderr)
+	}
+	nameTemplate = "{prefix}{index}.{ext}"
+	sizes := outputSizes(t, prefix, 3)
+	if slices.Max(sizes)-slices.Min(sizes) > 10 {
+		t.Errorf("rebalanced files hold %v, want them within one row of each other", sizes)
+	}
+	got := readOutputRows(t, prefix, 3, "id,name,size")
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("rebalanced files hold %v, want %v", got, want)
+	}
+	manifest, err := readManifest(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, size := range sizes {
+		if manifest[i+1].TotalSize != size {
+			t.Errorf("manifest says file %d holds %d, it holds %d", i+1, manifest[i+1].TotalSize, size)
+		}
+	}
+	if matches, _ := filepath.Glob(prefix + "*"); len(matches) != 4 {
+		t.Errorf("rebalance left %v, want the 3 files and the manifest", matches)
+	}
+}
+
+func TestSplitRefusesToOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	input, _ := writeCSV(t, dir, 30)
+	prefix := filepath.Join(dir, "out")
+	if _, stderr, code := runBinpacking(t, "split", input, "3", prefix); code != 0 {
+		t.Fatalf("split exited %d: %s", code, stderr)
+	}
+	nameTemplate = "{prefix}{index}.{ext}"
+	setOutputCount(3)
+	before, err := os.ReadFile(outputPath(prefix, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, stderr, code := runBinpacking(t, "split", input, "2", prefix)
+	if code != 1 {
+		t.Errorf("split over an earlier one exited %d, want 1", code)
+	}
+	for _, want := range []string{"3 files the split would write already exist, pass --overwrite to replace them", prefix + "1.csv", prefix + "2.csv", prefix + manifestName} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("split over an earlier one printed %q, want it to list %q", stderr, want)
+		}
+	}
+	if after, err := os.ReadFile(outputPath(prefix, 0)); err != nil || !bytes.Equal(after, before) {
+		t.Errorf("refused split changed %s", outputPath(prefix, 0))
+	}
+}
+
+// TestOverwriteRemovesStaleOutputs splits into 3 and again into 2 with --overwrite, which must not leave the first run's third file behind
+func TestOverwriteRemovesStaleOutputs(t *testing.T) {
+	dir := t.TempDir()
+	input, want := writeCSV(t, dir, 30)
+	prefix := filepath.Join(dir, "out")
+	if _, stderr, code := runBinpacking(t, "split", input, "3", prefix); code != 0 {
+		t.Fatalf("split exited %d: %s", code, stderr)
+	}
+	_, stderr, code := runBinpacking(t, "split", input, "2", prefix, "--overwrite")
+	if code != 0 {
+		t.Fatalf("split --overwrite exited %d: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "removed "+prefix+"3.csv, left by an earlier split") {
+		t.Errorf("split --overwrite logged %q, want it to remove the stale third output", stderr)
+	}
+	matches, _ := filepath.Glob(prefix + "*")
+	if want := []string{prefix + "1.csv", prefix + "2.csv", prefix + manifestName}; !slices.Equal(matches, want) {
+		t.Errorf("split --overwrite left %v, want %v", matches, want)
+	}
+	got := readOutputRows(t, prefix, 2, "id,name,size")
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("overwritten outputs hold %v, want %v", got, want)
+	}
+}
+
+// TestJSONSummary runs split and inspect with --output json and checks stdout is one JSON object describing the run, the logs going to stderr
+func TestJSONSummary(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.csv")
+	if err := os.WriteFile(input, []byte("id,name,size\n1,a,40\n2,b,40\n3,c,40\n4,d,30\n5,e,20\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prefix := filepath.Join(dir, "out")
+	stdout, stderr, code := runBinpacking(t, "split", input, "2", prefix, "--output", "json")
+	if code != 0 {
+		t.Fatalf("split --output json exited %d: %s", code, stderr)
+	}
+	var split runSummary
+	dec := json.NewDecoder(strings.NewReader(stdout))
+	if err := dec.Decode(&split); err != nil {
+		t.Fatalf("split --output json printed %q: %v", stdout, err)
+	}
+	if dec.More() {
+		t.Errorf("split --output json printed more than the summary: %q", stdout)
+	}
+	if !strings.Contains(stderr, "[write]") {
+		t.Errorf("split --output json logged %q, want the progress on stderr", stderr)
+	}
+	// largest first, 40s go to buckets 1 and 2, the third 40 to 1, then 30 to 2 and 20 to 2
+	want := []summaryBucket{{File: prefix + "1.csv", Size: 80, Lines: 2}, {File: prefix + "2.csv", Size: 90, Lines: 3}}
+	if split.Command != "split" || split.Input != input || split.Prefix != prefix || split.Rows != 5 || split.TotalSize != 170 || split.BucketCount != 2 {
+		t.Errorf("split summary is %+v, want split of %s into 2 buckets at %s with 5 rows of size 170", split, input, prefix)
+	}
+	if !slices.Equal(split.Buckets, want) {
+		t.Errorf("split summary lists buckets %v, want %v", split.Buckets, want)
+	}
+	if b := split.Balance; b == nil || b.Min != 80 || b.Max != 90 || b.Mean != 85 || b.Imbalance != 10 {
+		t.Errorf("split summary has balance %+v, want min 80, max 90, mean 85 and imbalance 10", split.Balance)
+	}
+	for _, phase := range []string{"scan", "pack", "write", "total"} {
+		if _, ok := split.Timing[phase]; !ok {
+			t.Errorf("split summary times %v, without %s", split.Timing, phase)
+		}
+	}
+
+	stdout, stderr, code = runBinpacking(t, "inspect", input, "--output", "json")
+	if code != 0 {
+		t.Fatalf("inspect --output json exited %d: %s", code, stderr)
+	}
+	var inspect runSummary
+	if err := json.Unmarshal([]byte(stdout), &inspect); err != nil {
+		t.Fatalf("inspect --output json printed %q: %v", stdout, err)
+	}
+	if inspect.Command != "inspect" || inspect.Input != input || inspect.Rows != 5 || inspect.TotalSize != 170 || len(inspect.Buckets) != 0 {
+		t.Errorf("inspect summary is %+v, want inspect of %s with 5 rows of size 170", inspect, input)
+	}
+}
+
+// TestSkipEmptyRows splits an input with blank rows between and after the data and checks none reaches an output, while every data row lands in the bucket the scan sized it for
+func TestSkipEmptyRows(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.csv")
+	var b strings.Builder
+	var want []string
+	b.WriteString("id,name,size\n")
+	blanks := 0
+	for i := 1; i <= 40; i++ {
+		row := fmt.Sprintf("%d,n%d,%d", i, i, i%9+1)
+		want = append(want, row)
+		b.WriteString(row + "\n")
+		if i%4 == 0 {
+			b.WriteString(",,\n")
+			blanks++
+		}
+	}
+	b.WriteString(",,\n,,\n")
+	blanks += 2
+	if err := os.WriteFile(input, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prefix := filepath.Join(dir, "out")
+	_, stderr, code := runBinpacking(t, "split", input, "3", prefix, "--skip-empty-rows", "--strict")
+	if code != 0 {
+		t.Fatalf("split --skip-empty-rows exited %d: %s", code, stderr)
+	}
+	for _, want := range []string{fmt.Sprintf("empty rows skipped: %d", blanks), fmt.Sprintf("empty lines skipped: %d", blanks)} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("split --skip-empty-rows logged %q, want %q", stderr, want)
+		}
+	}
+	got := readOutputRows(t, prefix, 3, "id,name,size")
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("outputs hold %v, want the data rows alone", got)
+	}
+	manifest, err := readManifest(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, size := range outputSizes(t, prefix, 3) {
+		if manifest[i+1].TotalSize != size {
+			t.Errorf("bucket %d was packed with %d, its output holds %d", i+1, manifest[i+1].TotalSize, size)
+		}
+	}
+}
+
+// TestMaxOpenFiles splits into more buckets than --max-open-files lets be open and checks every output is what a split holding them all open writes
+func TestMaxOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	input, _ := writeCSV(t, dir, 2000)
+	wantPrefix, prefix := filepath.Join(dir, "want"), filepath.Join(dir, "out")
+	if _, stderr, code := runBinpacking(t, "split", input, "20", wantPrefix); code != 0 {
+		t.Fatalf("split exited %d: %s", code, stderr)
+	}
+	_, stderr, code := runBinpacking(t, "split", input, "20", prefix, "--max-open-files", "3")
+	if code != 0 {
+		t.Fatalf("split --max-open-files 3 exited %d: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "20 outputs over --max-open-files 3, writing them from 3 writers") {
+		t.Errorf("split --max-open-files 3 logged %q, want it to share the outputs among 3 writers", stderr)
+	}
+	nameTemplate = "{prefix}{index}.{ext}"
+	setOutputCount(20)
+	for i := range 20 {
+		got, err := os.ReadFile(outputPath(prefix, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := os.ReadFile(outputPath(wantPrefix, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s differs from the output a split without --max-open-files writes", outputPath(prefix, i))
+		}
+	}
+}
+
+func TestInspectPerColumn(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.csv")
+	if err := os.WriteFile(input, []byte("id,name,bytes,mixed\n1,a,100,3\n2,b,-5,x\n3,a,2000,\n4,c,7,9\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, code := runBinpacking(t, "inspect", input, "--per-column", "--output", "json")
+	if code != 0 {
+		t.Fatalf("inspect --per-column exited %d: %s", code, stderr)
+	}
+	var summary runSummary
+	if err := json.Unmarshal([]byte(stdout), &summary); err != nil {
+		t.Fatalf("inspect --per-column --output json printed %q: %v", stdout, err)
+	}
+	want := []columnStats{
+		{Index: 0, Name: "id", Values: 4, Numeric: 4, NumericFraction: 1, Min: 1, Max: 4, Sum: 10, Samples: []string{"1", "2", "3"}},
+		{Index: 1, Name: "name", Values: 4, Samples: []string{"a", "b", "c"}},
+		{Index: 2, Name: "bytes", Values: 4, Numeric: 4, NumericFraction: 1, Min: -5, Max: 2000, Sum: 2102, Samples: []string{"100", "-5", "2000"}},
+		{Index: 3, Name: "mixed", Values: 4, Empty: 1, Numeric: 2, NumericFraction: 2.0 / 3, Min: 3, Max: 9, Sum: 12, Samples: []string{"3", "x", "9"}},
+	}
+	if summary.Rows != 4 || len(summary.Columns) != len(want) {
+		t.Fatalf("inspect --per-column reported %d rows and columns %+v, want 4 rows and %d columns", summary.Rows, summary.Columns, len(want))
+	}
+	for i, got := range summary.Columns {
+		w := want[i]
+		if got.Index != w.Index || got.Name != w.Name || got.Values != w.Values || got.Empty != w.Empty || got.Numeric != w.Numeric ||
+			math.Abs(got.NumericFraction-w.NumericFraction) > 1e-9 || got.Min != w.Min || got.Max != w.Max || got.Sum != w.Sum || !slices.Equal(got.Samples, w.Samples) {
+			t.Errorf("column %d: got %+v, want %+v", i, got, w)
+		}
+	}
+
+	stdout, stderr, code = runBinpacking(t, "inspect", input, "--per-column")
+	if code != 0 {
+		t.Fatalf("inspect --per-column exited %d: %s", code, stderr)
+	}
+	for _, want := range []string{"Total lines: 4, columns: 4", "2,102", "66.7%"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("inspect --per-column printed %q, want %q in the table", stdout, want)
+		}
+	}
+}
+
+// TestBucketCountAndSizeCap packs 40, 40, 40, 30 and 20 into 2 buckets under caps they do and don't fit, and relaxes the count for the one they don't
+func TestBucketCountAndSizeCap(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.csv")
+	if err := os.WriteFile(input, []byte("id,name,size\n1,a,40\n2,b,40\n3,c,40\n4,d,30\n5,e,20\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	nameTemplate = "{prefix}{index}.{ext}"
+
+	prefix := filepath.Join(dir, "feasible")
+	_, stderr, code := runBinpacking(t, "split", input, "2", prefix, "--max-bucket-size", "100B", "--overflow", "fail")
+	if code != 0 {
+		t.Fatalf("split under a cap of 100 exited %d: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "every bucket is under 100, the bucket count was the binding constraint") {
+		t.Errorf("split under a cap of 100 logged %q, want the bucket count reported as binding", stderr)
+	}
+	if got := outputSizes(t, prefix, 2); !slices.Equal(got, []int64{80, 90}) {
+		t.Errorf("split under a cap of 100 packed %v, want 80 and 90", got)
+	}
+
+	prefix = filepath.Join(dir, "infeasible")
+	_, stderr, code = runBinpacking(t, "split", input, "2", prefix, "--max-bucket-size", "60B", "--overflow", "fail")
+	if code != 1 {
+		t.Errorf("split under a cap of 60 exited %d, want 1", code)
+	}
+	if !strings.Contains(stderr, "bucket 1 has 80, 20 over; bucket 2 has 90, 30 over. Pass --relax buckets") {
+		t.Errorf("split under a cap of 60 printed %q, want the buckets over it", stderr)
+	}
+	if matches, _ := filepath.Glob(prefix + "*"); len(matches) > 0 {
+		t.Errorf("split under a cap of 60 left %v", matches)
+	}
+
+	prefix = filepath.Join(dir, "relaxed")
+	_, stderr, code = runBinpacking(t, "split", input, "2", prefix, "--max-bucket-size", "60B", "--relax", "buckets")
+	if code != 0 {
+		t.Fatalf("split --relax buckets exited %d: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "2 buckets couldn't keep every bucket under 60, relaxed to 4: the size cap was the binding constraint") {
+		t.Errorf("split --relax buckets logged %q, want the size cap reported as binding", stderr)
+	}
+	// 3 buckets can hold 170 under 60 apiece, but greedy packing needs a fourth
+	if got := outputSizes(t, prefix, 4); !slices.Equal(got, []int64{40, 40, 40, 50}) {
+		t.Errorf("split --relax buckets packed %v, want 40, 40, 40 and 50", got)
+	}
+	if _, err := os.Stat(outputPath(prefix, 4)); err == nil {
+		t.Error("split --relax buckets wrote a fifth output")
+	}
+}
+
+// TestFieldCount splits inputs with a row short of the header's fields and one over it, which stop the scan at that row unless --lenient pads or truncates them
+func TestFieldCount(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		row     string
+		err     string
+		lenient string // how --lenient writes the row
+	}{
+		{"short row", "2,n2", "record on line 3: wrong number of fields (2 fields where the header has 3", "2,n2,"},
+		{"long row", "2,n2,2,extra", "record on line 3: wrong number of fields (4 fields where the header has 3", "2,n2,2"},
+	} {
+		dir := t.TempDir()
+		input := filepath.Join(dir, "in.csv")
+		if err := os.WriteFile(input, []byte("id,name,size\n1,n1,1\n"+tc.row+"\n3,n3,3\n4,n4,4\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// sized by id, so the padded row keeps a size
+		prefix := filepath.Join(dir, "strict")
+		_, stderr, code := runBinpacking(t, "split", input, "2", prefix, "--size-column", "id")
+		if code != 1 || !strings.Contains(stderr, tc.err) {
+			t.Errorf("%s: split exited %d and printed %q, want it to stop at the row with %q", tc.name, code, stderr, tc.err)
+		}
+		if matches, _ := filepath.Glob(prefix + "*"); len(matches) > 0 {
+			t.Errorf("%s: split stopped by the row left %v", tc.name, matches)
+		}
+
+		prefix = filepath.Join(dir, "lenient")
+		_, stderr, code = runBinpacking(t, "split", input, "2", prefix, "--size-column", "id", "--lenient")
+		if code != 0 {
+			t.Fatalf("%s: split --lenient exited %d: %s", tc.name, code, stderr)
+		}
+		if !strings.Contains(stderr, "rows padded or truncated to the header's field count: 1") {
+			t.Errorf("%s: split --lenient logged %q, want the row counted", tc.name, stderr)
+		}
+		got := readOutputRows(t, prefix, 2, "id,name,size")
+		want := []string{"1,n1,1", tc.lenient, "3,n3,3", "4,n4,4"}
+		slices.Sort(got)
+		slices.Sort(want)
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: split --lenient wrote %q, want %q", tc.name, got, want)
+		}
+	}
+}
+
+// TestOutputDir splits into a nested directory that doesn't exist yet, with a name template, and checks everything lands inside it
+func TestOutputDir(t *testing.T) {
+	dir := t.TempDir()
+	input, want := writeCSV(t, dir, 30)
+	outDir := filepath.Join(dir, "a", "b", "c")
+	_, stderr, code := runBinpacking(t, "split", input, "3", "part", "--output-dir", outDir, "--name-template", "{prefix}-{index}.{ext}", "--checksum", "sha256")
+	if code != 0 {
+		t.Fatalf("split --output-dir exited %d: %s", code, stderr)
+	}
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		t.Fatalf("split --output-dir didn't create %s: %v", outDir, err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if wantNames := []string{"part-1.csv", "part-2.csv", "part-3.csv", "part.sha256sums", "partmanifest.json"}; !slices.Equal(names, wantNames) {
+		t.Errorf("%s holds %v, want %v", outDir, names, wantNames)
+	}
+
+	prefix := filepath.Join(outDir, "part")
+	manifest, err := readManifest(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for i := range 3 {
+		path := filepath.Join(outDir, "part-"+strconv.Itoa(i+1)+".csv")
+		if file := manifest[i+1].File; file != path {
+			t.Errorf("manifest lists bucket %d as %s, want %s", i+1, file, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")[1:]...)
+	}
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("outputs in %s hold %v, want %v", outDir, got, want)
+	}
+	if _, stderr, code := runBinpacking(t, "verify", input, prefix, "--name-template", "{prefix}-{index}.{ext}"); code != 0 {
+		t.Errorf("verify of the outputs in --output-dir exited %d: %s", code, stderr)
+	}
+}
+
+func TestSample(t *testing.T) {
+	dir := t.TempDir()
+	input, _ := writeCSV(t, dir, 30)
+	prefix := filepath.Join(dir, "out")
+	if _, stderr, code := runBinpacking(t, "split", input, "3", prefix); code != 0 {
+		t.Fatalf("split exited %d: %s", code, stderr)
+	}
+	manifest, err := readManifest(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nameTemplate = "{prefix}{index}.{ext}"
+	setOutputCount(3)
+	files := make([][]string, 3)
+	want := "id,name,size\n"
+	for i := range 3 {
+		data, err := os.ReadFile(outputPath(prefix, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[i] = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")[1:]
+		want += fmt.Sprintf("\n%s: %d rows, size %d, %d bytes on disk\n%s\n%s\n", outputPath(prefix, i), len(files[i]), manifest[i+1].TotalSize, len(data), files[i][0], files[i][1])
+	}
+	want += "\n30 rows in 3 files\n"
+	stdout, stderr, code := runBinpacking(t, "sample", prefix, "--rows", "2")
+	if code != 0 {
+		t.Fatalf("sample exited %d: %s", code, stderr)
+	}
+	if stdout != want {
+		t.Errorf("sample printed %q, want %q", stdout, want)
+	}
+
+	// random rows are each file's own, as many as asked for and the same for the same seed
+	stdout, stderr, code = runBinpacking(t, "sample", prefix, "--rows", "4", "--random", "--seed", "7")
+	if code != 0 {
+		t.Fatalf("sample --random exited %d: %s", code, stderr)
+	}
+	again, _, _ := runBinpacking(t, "sample", prefix, "--rows", "4", "--random", "--seed", "7")
+	if again != stdout {
+		t.Errorf("sample --random --seed 7 printed %q and then %q", stdout, again)
+	}
+	sections := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n\n")
+	if len(sections) != 5 {
+		t.Fatalf("sample --random printed %q, want the header, 3 files and the total", stdout)
+	}
+	for i, section := range sections[1:4] {
+		lines := strings.Split(section, "\n")
+		if !strings.HasPrefix(lines[0], outputPath(prefix, i)+":") {
+			t.Errorf("sample --random section %d starts %q, want %s", i+1, lines[0], outputPath(prefix, i))
+		}
+		if len(lines)-1 != 4 {
+			t.Errorf("sample --random printed %d rows of %s, want 4", len(lines)-1, outputPath(prefix, i))
+		}
+		for _, row := range lines[1:] {
+			if !slices.Contains(files[i], row) {
+				t.Errorf("sample --random printed %q for %s, which doesn't hold it", row, outputPath(prefix, i))
+			}
+		}
+	}
+}
+
+// quietBenchmark keeps the logs and reports of the passes a benchmark runs off the terminal
+func quietBenchmark(b *testing.B) {
+	restoreLogging(b)
+	out := reportOut
+	b.Cleanup(func() { reportOut = out })
+	if err := setupLogging(context.Background(), io.Discard, "error", "text"); err != nil {
+		b.Fatal(err)
+	}
+	progressMode = "off"
+	reportOut = io.Discard
+}
+
+func BenchmarkWriteChannelBuffer(b *testing.B) {
+	quietBenchmark(b)
+	dir := b.TempDir()
+	input, _ := writeCSV(b, dir, 200_000)
+	info, err := os.Stat(input)
+	if err != nil {
+		b.Fatal(err)
+	}
+	nameTemplate = "{prefix}{index}.{ext}"
+	ctx := context.Background()
+	metas := scan(ctx, input, ScanOptions{Format: "csv"})
+	setOutputCount(8)
+	buckets, assign, err := pack(ctx, metas, 8, PackOptions{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	prefix := filepath.Join(dir, "out")
+	for _, size := range []int{1, 100, 1000, defaultChannelBuffer, 100_000} {
+		b.Run(fmt.Sprintf("buffer=%d", size), func(b *testing.B) {
+			b.SetBytes(info.Size())
+			for b.Loop() {
+				write(ctx, input, prefix, buckets, assign, WriteOptions{Format: "csv", ChannelBuffer: size, ExpectedRecords: len(metas)})
+			}
+		})
+	}
+}
+
+// BenchmarkWriteSparse writes the last tenth of every output, as resuming a split does, reading the input straight through and seeking past the rows already written with --seek-index
+func BenchmarkWriteSparse(b *testing.B) {
+	quietBenchmark(b)
+	dir := b.TempDir()
+	input, _ := writeCSV(b, dir, 200_000)
+	nameTemplate = "{prefix}{index}.{ext}"
+	ctx := context.Background()
+	var offsets []int64
+	metas := scan(ctx, input, ScanOptions{Format: "csv", RowOffsets: &offsets})
+	setOutputCount(8)
+	buckets, assign, err := pack(ctx, metas, 8, PackOptions{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	resume := make([]watermark, len(buckets))
+	for i := range resume {
+		resume[i] = watermark{Bucket: i, LastLine: len(metas) * 9 / 10}
+	}
+	prefix := filepath.Join(dir, "out")
+	for _, tc := range []struct {
+		name    string
+		offsets []int64
+	}{
+		{"sequential", nil},
+		{"seek-index", offsets},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			for b.Loop() {
+				write(ctx, input, prefix, buckets, assign, WriteOptions{Format: "csv", ChannelBuffer: defaultChannelBuffer, ExpectedRecords: len(metas), RowOffsets: tc.offsets, Resume: resume})
+			}
+		})
+	}
+}
+
+// largeScanRows gives a writeCSV input over parallelScanMin, large enough for the parallel scan
+const largeScanRows = 4_000_000
+
+func BenchmarkScanThreads(b *testing.B) {
+	quietBenchmark(b)
+	input, _ := writeCSV(b, b.TempDir(), largeScanRows)
+	info, err := os.Stat(input)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if info.Size() < parallelScanMin {
+		b.Fatalf("%s has %d bytes, too few to scan in parallel", input, info.Size())
+	}
+	ctx := context.Background()
+	serial := scan(ctx, input, ScanOptions{Format: "csv", Threads: 1})
+	for _, threads := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("threads=%d", threads), func(b *testing.B) {
+			if metas := scan(ctx, input, ScanOptions{Format: "csv", Threads: threads}); !slices.Equal(metas, serial) {
+				b.Fatalf("%d threads scan other metas than the serial scan", threads)
+			}
+			b.SetBytes(info.Size())
+			for b.Loop() {
+				scan(ctx, input, ScanOptions{Format: "csv", Threads: threads})
+			}
+		})
+	}
+}
+
+// BenchmarkScanMmap scans an input through bufio and csv.Reader and from a memory mapping, after checking both find the same metas
+func BenchmarkScanMmap(b *testing.B) {
+	quietBenchmark(b)
+	input, _ := writeCSV(b, b.TempDir(), 1_000_000)
+	info, err := os.Stat(input)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+	buffered := scan(ctx, input, ScanOptions{Format: "csv", Threads: 1})
+	if mapped := scan(ctx, input, ScanOptions{Format: "csv", Threads: 1, Mmap: true}); !slices.Equal(mapped, buffered) {
+		b.Fatal("the mmap scan finds other metas than the buffered scan")
+	}
+	for _, tc := range []struct {
+		name string
+		mmap bool
+	}{
+		{"buffered", false},
+		{"mmap", true},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			b.SetBytes(info.Size())
+			b.ReportAllocs()
+			for b.Loop() {
+				scan(ctx, input, ScanOptions{Format: "csv", Threads: 1, Mmap: tc.mmap})
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// manifestName is written next to the outputs, so <prefix>manifest.json sits beside <prefix>1.csv
+const manifestName = "manifest.json"
+
+// ManifestFile describes one output file of a split
+type ManifestFile struct {
+	File            string   `json:"file"`
+	Bucket          int      `json:"bucket"`
+	TotalSize       int64    `json:"total_size"`
+	Lines           int      `json:"lines"`
+	LineRanges      [][2]int `json:"line_ranges"` // inclusive [first, last] runs of 1-based data row numbers, header excluded
+	WrittenBytes    int64    `json:"written_bytes"`
+	ContentHash     string   `json:"content_hash,omitempty"`
+	Checksum        string   `json:"checksum,omitempty"` // "<algo>:<hex>" digest of the file's bytes
+	RowGroupOffsets []int64  `json:"row_group_offsets,omitempty"`
+}
+
+// manifestDoc is the whole of <prefix>manifest.json
+type manifestDoc struct {
+	InputRows int            `json:"input_rows,omitempty"` // data rows of every input the split and its --append runs read, which later row numbers follow on from
+	Files     []ManifestFile `json:"files"`
+}
+
+// lineRanges run-length encodes every bucket's line numbers into sorted inclusive ranges
+func lineRanges(assign Assignment, n int) [][][2]int {
+	ranges := make([][][2]int, n)
+	for i := range ranges {
+		ranges[i] = [][2]int{}
+	}
+	for line := range assign {
+		b, ok := assign.Bucket(line)
+		if !ok || b >= n {
+			// a row mapped past the last bucket was never written, write reports it
+			continue
+		}
+		if last := len(ranges[b]) - 1; last >= 0 && ranges[b][last][1] == line-1 {
+			ranges[b][last][1] = line
+			continue
+		}
+		ranges[b] = append(ranges[b], [2]int{line, line})
+	}
+	return ranges
+}
+
+// appendRanges adds ranges of a batch numbered from 1 to the earlier ranges, offset by the rows before the batch. A run that carries on across the batches is joined into one
+func appendRanges(earlier [][2]int, ranges [][2]int, offset int) [][2]int {
+	merged := slices.Clone(earlier)
+	for _, r := range ranges {
+		r = [2]int{r[0] + offset, r[1] + offset}
+		if last := len(merged) - 1; last >= 0 && merged[last][1] == r[0]-1 {
+			merged[last][1] = r[1]
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// writeManifest records which input rows went into which output as <prefix>manifest.json. inputRows is how many data rows the input held.
+// After --append, the rows of every batch are numbered as if the inputs were read one after another
+func writeManifest(prefix string, buckets []FileBucket, assign Assignment, stats []BucketStats, inputRows int, opts WriteOptions) error {
+	ranges := lineRanges(assign, len(buckets))
+	if opts.Append != nil {
+		for i := range ranges {
+			ranges[i] = appendRanges(opts.Append.Ranges[i], ranges[i], opts.Append.InputRows)
+		}
+		inputRows += opts.Append.InputRows
+	}
+	files := make([]ManifestFile, len(buckets))
+	for i, bucket := range buckets {
+		files[i] = ManifestFile{
+			File:            outputPath(prefix, i),
+			Bucket:          i + 1,
+			TotalSize:       bucket.TotalSize,
+			Lines:           bucket.Lines,
+			LineRanges:      ranges[i],
+			WrittenBytes:    stats[i].WrittenBytes,
+			RowGroupOffsets: stats[i].RowGroupOffsets,
+			Checksum:        stats[i].Checksum,
+		}
+		if opts.ContentHash {
+			files[i].ContentHash = fmt.Sprintf("%016x", stats[i].ContentHash)
+		}
+	}
+	return writeManifestFiles(prefix, inputRows, files)
+}
+
+// writeManifestFiles writes files, one entry per output in bucket order, as <prefix>manifest.json
+func writeManifestFiles(prefix string, inputRows int, files []ManifestFile) error {
+	out, err := openSidecar(prefix, manifestName)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+
+	// one file per line keeps manifests from two runs diffable line by line
+	fmt.Fprintf(w, "{\"input_rows\": %d, \"files\": [\n", inputRows)
+	for i, entry := range files {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			out.Close()
+			return err
+		}
+		sep := ","
+		if i == len(files)-1 {
+			sep = ""
+		}
+		fmt.Fprintf(w, "  %s%s\n", line, sep)
+	}
+	fmt.Fprintln(w, "]}")
+
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	logInfo("write", "wrote manifest %s%s", prefix, manifestName)
+	return nil
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge <output_prefix> <merged_output>",
+	Short: "Recombine the files of a split into one file",
+	Long:  "Finds every <output_prefix>N.csv (or .jsonl, or gzipped) file, writes their shared header once and then every data row, file by file in bucket order, or with --restore-order in the input's order, from the line ranges in <output_prefix>manifest.json.",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := checkHeaderRows(ScanOptions{Format: scanOpts.Format}); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if err := merge(args[0], args[1]); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var mergeRestoreOrder bool
+
+func init() {
+	mergeCmd.Flags().BoolVar(&mergeRestoreOrder, "restore-order", false, "write the rows in their input order, from the line ranges the split recorded in <output_prefix>manifest.json, instead of file by file")
+	mergeCmd.Flags().StringVar(&scanOpts.Format, "format", "csv", "the --format the split ran with, jsonl files are merged line by line")
+	mergeCmd.Flags().IntVar(&headerRows, "header-rows", 1, "the --header-rows the split ran with, written once at the top of the merged file")
+	mergeCmd.Flags().BoolVar(&noHeader, "no-header", false, "the split ran with --no-header, so every row is data")
+}
+
+// discoverBucketFiles lists the bucket files written with prefix, ordered by bucket number
+func discoverBucketFiles(prefix string) ([]string, error) {
+	matches, err := filepath.Glob(bucketFileGlob(prefix))
+	if err != nil {
+		return nil, err
+	}
+	pattern := bucketFilePattern(prefix)
+	type bucketFile struct {
+		path   string
+		number int
+	}
+	files := []bucketFile{}
+	seen := map[int]string{}
+	for _, path := range matches {
+		m := pattern.FindStringSubmatch(path)
+		if m == nil {
+			continue
+		}
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			continue
+		}
+		if other, ok := seen[n]; ok {
+			return nil, fmt.Errorf("both %s and %s exist for bucket %d", other, path, n)
+		}
+		seen[n] = path
+		files = append(files, bucketFile{path, n})
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files matching %sN.csv found", prefix)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].number < files[j].number })
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+	return paths, nil
+}
+
+func merge(prefix string, output string) error {
+	logInfo("merge", "merging bucket files...")
+	paths, err := discoverBucketFiles(prefix)
+	if err != nil {
+		return err
+	}
+	// the merged file may itself look like a bucket file, it must not be read while it's being written
+	paths = slices.DeleteFunc(paths, func(path string) bool {
+		return filepath.Clean(path) == filepath.Clean(output)
+	})
+	if len(paths) == 0 {
+		return fmt.Errorf("no files matching %sN.csv found besides %s", prefix, output)
+	}
+
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	var dst io.WriteCloser = out
+	if isGzipPath(output) {
+		dst = gzipOutput{Writer: gzip.NewWriter(out), dst: out}
+	}
+	w := newRecordWriter(scanOpts.Format, dst)
+
+	if mergeRestoreOrder {
+		rows, err := mergeInInputOrder(prefix, paths, w)
+		if err == nil {
+			w.Flush()
+			err = w.Error()
+		}
+		if err != nil {
+			dst.Close()
+			return err
+		}
+		if err := dst.Close(); err != nil {
+			return err
+		}
+		logInfo("merge", "merged %s rows from %d files into %s in input order", FormatNumber(int64(rows)), len(paths), output)
+		return nil
+	}
+
+	var header [][]string
+	rows := 0
+	for _, path := range paths {
+		n, h, err := mergeFile(path, w, header)
+		if err != nil {
+			dst.Close()
+			return err
+		}
+		if header == nil {
+			header = h
+		}
+		logInfo("merge", "%s: %s rows", path, FormatNumber(int64(n)))
+		rows += n
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	logInfo("merge", "merged %s rows from %d files into %s", FormatNumber(int64(rows)), len(paths), output)
+	return nil
+}
+
+// mergeRange is a run of consecutive input rows that went to one bucket file, in its order there
+type mergeRange struct {
+	first, last int
+	file        *bucketReader
+}
+
+// mergeInInputOrder writes the data rows of the bucket files at paths to w in input order. Every file lists its rows in ascending input order,
+// so walking the manifest's line ranges of every file, lowest first, says which file holds the next row. Rows the split left out of every output leave gaps
+func mergeInInputOrder(prefix string, paths []string, w RecordWriter) (int, error) {
+	manifest, err := readManifest(prefix)
+	if err != nil {
+		return 0, err
+	}
+	if manifest == nil {
+		return 0, fmt.Errorf("--restore-order needs %s%s, which records the input rows of every output", prefix, manifestName)
+	}
+	pattern := bucketFilePattern(prefix)
+	files := make([]*bucketReader, 0, len(paths))
+	defer func() {
+		for _, b := range files {
+			b.Close()
+		}
+	}()
+	var ranges []mergeRange
+	var header [][]string
+	for i, path := range paths {
+		bucket := 0
+		if m := pattern.FindStringSubmatch(path); m != nil {
+			bucket, _ = strconv.Atoi(m[1])
+		}
+		entry, ok := manifest[bucket]
+		if !ok {
+			return 0, fmt.Errorf("%s is not in %s%s", path, prefix, manifestName)
+		}
+		b, err := openBucketReader(path)
+		if err != nil {
+			return 0, err
+		}
+		files = append(files, b)
+		if i == 0 {
+			header = b.header
+		} else if err := b.checkHeader(header); err != nil {
+			return 0, err
+		}
+		for _, r := range entry.LineRanges {
+			ranges = append(ranges, mergeRange{first: r[0], last: r[1], file: b})
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].first < ranges[j].first })
+
+	for _, record := range header {
+		w.Write(record)
+	}
+	rows := 0
+	for _, r := range ranges {
+		for line := r.first; line <= r.last; line++ {
+			record, err := r.file.read()
+			if err == io.EOF {
+				return 0, fmt.Errorf("%s ends before input row %d, which %s%s lists in it", r.file.path, line, prefix, manifestName)
+			}
+			if err != nil {
+				return 0, err
+			}
+			w.Write(record)
+			rows++
+		}
+	}
+	for _, b := range files {
+		if _, err := b.read(); err != io.EOF {
+			if err == nil {
+				err = fmt.Errorf("%s has more rows than %s%s lists in it", b.path, prefix, manifestName)
+			}
+			return 0, err
+		}
+	}
+	return rows, nil
+}
+
+// bucketReader reads the records of one bucket file, gzipped or not, in the output format of --format
+type bucketReader struct {
+	path   string
+	f      *os.File
+	r      RecordReader
+	header [][]string // the file's --header-rows header rows, read on opening
+}
+
+// openBucketReader opens a bucket file and reads its header rows
+func openBucketReader(path string) (*bucketReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	in, err := inputReader(f, path)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	format := outputFormat(scanOpts.Format)
+	r, err := newRecordReader(format, in, SizeSpec{Lines: true})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	b := &bucketReader{path: path, f: f, r: r, header: [][]string{}}
+	for range headerCount(format) {
+		record, _, err := r.Read()
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: reading header: %w", path, err)
+		}
+		b.header = append(b.header, record)
+	}
+	return b, nil
+}
+
+// read returns the next data row, or io.EOF once there are none
+func (b *bucketReader) read() ([]string, error) {
+	record, _, err := b.r.Read()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %w", b.path, err)
+	}
+	return record, err
+}
+
+// checkHeader makes sure the file's header rows are header's, unless header is nil
+func (b *bucketReader) checkHeader(header [][]string) error {
+	if header != nil && !slices.EqualFunc(header, b.header, slices.Equal) {
+		return fmt.Errorf("%s: header %v does not match %v", b.path, b.header, header)
+	}
+	return nil
+}
+
+func (b *bucketReader) Close() error {
+	return b.f.Close()
+}
+
+// mergeFile copies one bucket file's data rows to w, writing its --header-rows header rows first when header is nil and otherwise checking they match
+func mergeFile(path string, w RecordWriter, header [][]string) (int, [][]string, error) {
+	b, err := openBucketReader(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer b.Close()
+	if err := b.checkHeader(header); err != nil {
+		return 0, nil, err
+	}
+	if header == nil {
+		for _, record := range b.header {
+			w.Write(record)
+		}
+	}
+
+	h := b.header
+	rows := 0
+	for {
+		record, err := b.read()
+		if err == io.EOF {
+			return rows, h, nil
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		w.Write(record)
+		rows++
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"compress/gzip"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var mergeAssumeSorted bool
+var mergeNumeric bool
+
+var mergeSortedCmd = &cobra.Command{
+	Use:   "merge-sorted <output_prefix> <buckets> <key_column> <merged_output>",
+	Short: "Merge bucket files that are each sorted by a key into one globally sorted file",
+	Args:  cobra.ExactArgs(4),
+	Run: func(cmd *cobra.Command, args []string) {
+		bucketsN, err := parseBucketCount(args[1])
+		if err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if err := checkHeaderRows(ScanOptions{Format: scanOpts.Format}); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if err := mergeSorted(args[0], bucketsN, args[2], args[3]); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	mergeSortedCmd.Flags().BoolVar(&mergeAssumeSorted, "assume-sorted", false, "skip checking that every input is sorted by the key")
+	mergeSortedCmd.Flags().BoolVar(&mergeNumeric, "numeric", false, "compare keys as numbers instead of strings")
+	mergeSortedCmd.Flags().StringVar(&scanOpts.Format, "format", "csv", "the --format the split ran with; for jsonl the key is a dotted field path, e.g. meta.ts")
+	mergeSortedCmd.Flags().IntVar(&headerRows, "header-rows", 1, "the --header-rows the split ran with, written once at the top of the merged file")
+	mergeSortedCmd.Flags().BoolVar(&noHeader, "no-header", false, "the split ran with --no-header, so the key must be a column index")
+}
+
+// mergeSource is one bucket file's reader and its current row, with the key it sorts by
+type mergeSource struct {
+	bucket int
+	b      *bucketReader
+	record []string
+	key    string
+	num    float64 // the key as a number, with --numeric
+	line   int
+}
+
+// mergeHeap orders sources by the key of their current row, ties go to the lower bucket so equal keys keep bucket order
+type mergeHeap struct {
+	sources []*mergeSource
+	less    func(a, b *mergeSource) bool
+}
+
+func (h *mergeHeap) Len() int { return len(h.sources) }
+func (h *mergeHeap) Less(i, j int) bool {
+	a, b := h.sources[i], h.sources[j]
+	if h.less(a, b) {
+		return true
+	}
+	if h.less(b, a) {
+		return false
+	}
+	return a.bucket < b.bucket
+}
+func (h *mergeHeap) Swap(i, j int) { h.sources[i], h.sources[j] = h.sources[j], h.sources[i] }
+func (h *mergeHeap) Push(x any)    { h.sources = append(h.sources, x.(*mergeSource)) }
+func (h *mergeHeap) Pop() any {
+	last := h.sources[len(h.sources)-1]
+	h.sources = h.sources[:len(h.sources)-1]
+	return last
+}
+
+// mergeKeyReader returns what a row sorts by: a column, by name or index, of a csv row, or the value at a dotted field path of a jsonl line
+func mergeKeyReader(header [][]string, keySpec string) (func(record []string) (string, error), error) {
+	if outputFormat(scanOpts.Format) == jsonlFormat {
+		path := strings.Split(keySpec, ".")
+		return func(record []string) (string, error) {
+			v, err := jsonValue(record[0], path)
+			if err != nil {
+				return "", err
+			}
+			switch v := v.(type) {
+			case string:
+				return v, nil
+			case json.Number:
+				return v.String(), nil
+			}
+			return "", fmt.Errorf("field %s is not a string or number", keySpec)
+		}, nil
+	}
+	var names []string
+	if len(header) > 0 {
+		names = header[0]
+	}
+	key, err := resolveColumn(names, keySpec)
+	if err != nil {
+		return nil, err
+	}
+	return func(record []string) (string, error) {
+		if key >= len(record) {
+			return "", fmt.Errorf("no key column")
+		}
+		return record[key], nil
+	}, nil
+}
+
+func mergeSorted(prefix string, bucketsN int, keySpec string, output string) error {
+	logInfo("merge sorted", "merging bucket files...")
+	paths, err := discoverBucketFiles(prefix)
+	if err != nil {
+		return err
+	}
+	paths = slices.DeleteFunc(paths, func(path string) bool {
+		return filepath.Clean(path) == filepath.Clean(output)
+	})
+	if len(paths) != bucketsN {
+		return fmt.Errorf("found %d bucket files at %s, but asked to merge %d", len(paths), prefix, bucketsN)
+	}
+	var header [][]string
+	sources := []*mergeSource{}
+	for i, path := range paths {
+		b, err := openBucketReader(path)
+		if err != nil {
+			return err
+		}
+		defer b.Close()
+		if err := b.checkHeader(header); err != nil {
+			return err
+		}
+		if header == nil {
+			header = b.header
+		}
+		sources = append(sources, &mergeSource{bucket: i, b: b})
+	}
+
+	keyOf, err := mergeKeyReader(header, keySpec)
+	if err != nil {
+		return err
+	}
+	less := func(a, b *mergeSource) bool { return a.key < b.key }
+	if mergeNumeric {
+		less = func(a, b *mergeSource) bool { return a.num < b.num }
+	}
+
+	// advance reads the source's next row, checking it doesn't sort before the previous one
+	advance := func(s *mergeSource) (bool, error) {
+		prev := *s
+		record, err := s.b.read()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		s.line++
+		key, err := keyOf(record)
+		if err != nil {
+			return false, fmt.Errorf("%s: row %d: %v", s.b.path, s.line, err)
+		}
+		s.record, s.key = record, key
+		if mergeNumeric {
+			if s.num, err = strconv.ParseFloat(key, 64); err != nil {
+				return false, fmt.Errorf("%s: row %d: key %q is not numeric", s.b.path, s.line, key)
+			}
+		}
+		if !mergeAssumeSorted && prev.record != nil && less(s, &prev) {
+			return false, fmt.Errorf("%s: not sorted by %s at row %d (%q after %q), pass --assume-sorted to skip this check", s.b.path, keySpec, s.line, key, prev.key)
+		}
+		return true, nil
+	}
+
+	h := &mergeHeap{less: less}
+	for _, s := range sources {
+		ok, err := advance(s)
+		if err != nil {
+			return err
+		}
+		if ok {
+			h.sources = append(h.sources, s)
+		}
+	}
+	heap.Init(h)
+
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	var dst io.WriteCloser = out
+	if isGzipPath(output) {
+		dst = gzipOutput{Writer: gzip.NewWriter(out), dst: out}
+	}
+	w := newRecordWriter(scanOpts.Format, dst)
+	for _, record := range header {
+		w.Write(record)
+	}
+
+	rows := 0
+	for h.Len() > 0 {
+		s := h.sources[0]
+		w.Write(s.record)
+		rows++
+		ok, err := advance(s)
+		if err != nil {
+			dst.Close()
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	logInfo("merge sorted", "merged %s rows from %d files into %s", FormatNumber(int64(rows)), len(sources), output)
+	return nil
+}
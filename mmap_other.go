@@ -0,0 +1,16 @@
+//go:build !(linux || darwin)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func mapWindow(f *os.File, offset int64, length int) ([]byte, error) {
+	return nil, errors.New("mmap not supported on this platform")
+}
+
+func unmapWindow(b []byte) error {
+	return nil
+}
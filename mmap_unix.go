@@ -0,0 +1,17 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapWindow maps length bytes of f starting at offset, which must be page aligned, read only
+func mapWindow(f *os.File, offset int64, length int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), offset, length, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func unmapWindow(b []byte) error {
+	return syscall.Munmap(b)
+}
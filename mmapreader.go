@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+// mmapWindowSize is how much of the input is mapped at a time, so files larger than the address space can still be scanned. A window grows when a single record doesn't fit
+const mmapWindowSize = 1 << 30
+
+// mmapRecordReader parses CSV straight out of memory mapped windows of the input instead of copying it through bufio and csv.Reader. It follows encoding/csv's rules (quoting, "" escapes, \r\n normalization, skipped blank lines and a fixed field count taken from the first record) so both paths produce identical records
+type mmapRecordReader struct {
+	f        *os.File
+	fileSize int64
+	window   int
+	data     []byte // current mapping
+	base     int64  // file offset of data[0]
+	pos      int    // read position within data
+	size     SizeSpec
+	delim    byte
+	fields   int // expected fields per record, set by the first record
+	fitted   int // rows lenientFields padded or truncated
+	// sizeOnly lets Read return a nil record for data rows whose size it can take straight from the mapped bytes, for scans that need nothing else
+	sizeOnly bool
+	line     int // physical lines consumed, for error positions
+	errCol   int // 1-based column of the last parse error
+
+	recordBuf []byte // unescaped bytes of the current record, reused
+	fieldEnds []int
+}
+
+func newMmapRecordReader(f *os.File, size SizeSpec) (*mmapRecordReader, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if csvDelimiter >= utf8.RuneSelf {
+		return nil, fmt.Errorf("multi-byte delimiter %q", csvDelimiter)
+	}
+	r := &mmapRecordReader{f: f, fileSize: stat.Size(), window: mmapWindowSize, size: size, delim: byte(csvDelimiter)}
+	if r.fileSize > 0 {
+		if err := r.remap(0); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// remap moves the window so it starts at or just before file offset off
+func (r *mmapRecordReader) remap(off int64) error {
+	if r.data != nil {
+		unmapWindow(r.data)
+		r.data = nil
+	}
+	page := int64(os.Getpagesize())
+	base := off - off%page
+	length := int64(r.window)
+	if base+length > r.fileSize {
+		length = r.fileSize - base
+	}
+	data, err := mapWindow(r.f, base, int(length))
+	if err != nil {
+		return err
+	}
+	r.data, r.base, r.pos = data, base, int(off-base)
+	return nil
+}
+
+func (r *mmapRecordReader) Fitted() int {
+	return r.fitted
+}
+
+// Close unmaps the current window, the file itself belongs to the caller
+func (r *mmapRecordReader) Close() error {
+	if r.data == nil {
+		return nil
+	}
+	err := unmapWindow(r.data)
+	r.data = nil
+	return err
+}
+
+func (r *mmapRecordReader) Read() ([]string, int64, error) {
+	for {
+		if r.base+int64(r.pos) >= r.fileSize {
+			return nil, 0, io.EOF
+		}
+		atEOF := r.base+int64(len(r.data)) >= r.fileSize
+		startLine := r.line + 1
+		consumed, lines, complete, err := r.parseRecord(r.data[r.pos:], atEOF)
+		if !complete {
+			// the record runs past the window, map again from its start. Grow the window when that wouldn't move it forward, i.e. the record alone doesn't fit
+			off := r.base + int64(r.pos)
+			if off-off%int64(os.Getpagesize()) == r.base {
+				r.window *= 2
+			}
+			if err := r.remap(off); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		r.pos += consumed
+		r.line += lines
+		if err != nil {
+			return nil, 0, &csv.ParseError{StartLine: startLine, Line: r.line, Column: r.errCol, Err: err}
+		}
+		if len(r.fieldEnds) == 0 {
+			continue // blank line, skipped like csv.Reader does
+		}
+
+		if r.sizeOnly && r.fields > 0 && len(r.fieldEnds) == r.fields {
+			if size, ok := r.quickSize(); ok {
+				return nil, size, nil
+			}
+		}
+		record := r.record()
+		if r.fields == 0 {
+			// the first record is the header
+			r.fields = len(record)
+			if r.size, err = r.size.Resolve(record); err != nil {
+				return record, 0, err
+			}
+		} else if len(record) != r.fields {
+			if !lenientFields {
+				return record, 0, fieldCountError(&csv.ParseError{StartLine: startLine, Line: r.line, Err: csv.ErrFieldCount}, record, r.fields)
+			}
+			record, _ = fitFields(record, r.fields)
+			r.fitted++
+		}
+		size, err := r.size.Parse(record)
+		if err != nil {
+			return record, 0, fmt.Errorf("%w: %v", ErrBadSize, err)
+		}
+		return record, size, nil
+	}
+}
+
+// sizeOnlyScan reports whether a scan with opts uses nothing of a data row but its size read from one column, or its count, so the mmap reader can skip building the row's fields
+func sizeOnlyScan(opts ScanOptions) bool {
+	if opts.Filter != nil || opts.SkipEmptyRows || opts.ValidateUTF8 || opts.GroupColumn != "" {
+		return false
+	}
+	return opts.Size.Lines || !opts.Size.Bytes && !opts.Size.Relative && opts.Size.Expr == ""
+}
+
+// quickSize reads the current record's size from its unescaped bytes. ok is false when the value isn't a plain integer, leaving the row to the full parse so a bad size is reported as usual
+func (r *mmapRecordReader) quickSize() (int64, bool) {
+	if r.size.Lines {
+		return 1, true
+	}
+	col := r.size.column()
+	if col >= len(r.fieldEnds) {
+		return 0, false
+	}
+	start := 0
+	if col > 0 {
+		start = r.fieldEnds[col-1]
+	}
+	return parseDigits(r.recordBuf[start:r.fieldEnds[col]])
+}
+
+// parseDigits is strconv.ParseInt(string(b), 10, 64) without the string, for values of at most 18 digits after an optional sign, which can't overflow
+func parseDigits(b []byte) (int64, bool) {
+	neg := false
+	if len(b) > 0 && (b[0] == '-' || b[0] == '+') {
+		neg = b[0] == '-'
+		b = b[1:]
+	}
+	if len(b) == 0 || len(b) > 18 {
+		return 0, false
+	}
+	n := int64(0)
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int64(c-'0')
+	}
+	if neg {
+		n = -n
+	}
+	return n, true
+}
+
+// record turns the unescaped record bytes into fields sharing a single string allocation
+func (r *mmapRecordReader) record() []string {
+	s := string(r.recordBuf)
+	fields := make([]string, len(r.fieldEnds))
+	start := 0
+	for i, end := range r.fieldEnds {
+		fields[i] = s[start:end]
+		start = end
+	}
+	return fields
+}
+
+// parseRecord tokenizes one record from b into recordBuf and fieldEnds. It reports how many bytes and lines were consumed, and complete=false when b ended mid-record and more input follows. A blank line yields no fields
+func (r *mmapRecordReader) parseRecord(b []byte, atEOF bool) (consumed int, lines int, complete bool, err error) {
+	r.recordBuf = r.recordBuf[:0]
+	r.fieldEnds = r.fieldEnds[:0]
+
+	i := 0
+	// lone "\n" or "\r\n" lines are skipped entirely
+	if len(b) > 0 && b[0] == '\n' {
+		return 1, 1, true, nil
+	}
+	if len(b) > 1 && b[0] == '\r' && b[1] == '\n' {
+		return 2, 1, true, nil
+	}
+	if len(b) == 1 && b[0] == '\r' && atEOF {
+		return 1, 0, true, nil
+	}
+
+	for {
+		if i < len(b) && b[i] == '"' {
+			// quoted field
+			i++
+			for {
+				if i >= len(b) {
+					if !atEOF {
+						return 0, 0, false, nil
+					}
+					// unterminated quote at EOF
+					return i, lines, true, csv.ErrQuote
+				}
+				c := b[i]
+				switch {
+				case c == '"':
+					if i+1 < len(b) && b[i+1] == '"' {
+						r.recordBuf = append(r.recordBuf, '"')
+						i += 2
+						continue
+					}
+					if i+1 >= len(b) && !atEOF {
+						return 0, 0, false, nil
+					}
+					i++
+					goto fieldDone
+				case c == '\r' && i+1 < len(b) && b[i+1] == '\n':
+					r.recordBuf = append(r.recordBuf, '\n')
+					lines++
+					i += 2
+				case c == '\r' && i+1 >= len(b) && !atEOF:
+					return 0, 0, false, nil
+				case c == '\n':
+					r.recordBuf = append(r.recordBuf, '\n')
+					lines++
+					i++
+				default:
+					r.recordBuf = append(r.recordBuf, c)
+					i++
+				}
+			}
+		fieldDone:
+			r.fieldEnds = append(r.fieldEnds, len(r.recordBuf))
+			if i >= len(b) {
+				return i, lines + 1, true, nil
+			}
+			if b[i] == r.delim {
+				i++
+				continue
+			}
+			switch n := lineEnd(b, i, atEOF); {
+			case n < 0:
+				return 0, 0, false, nil
+			case n > 0:
+				return i + n, lines + 1, true, nil
+			}
+			// a closing quote followed by something other than a delimiter or line end
+			return r.skipLine(b, i, i-1, lines, atEOF, csv.ErrQuote)
+		}
+
+		// unquoted field
+		for {
+			if i >= len(b) {
+				if !atEOF {
+					return 0, 0, false, nil
+				}
+				r.fieldEnds = append(r.fieldEnds, len(r.recordBuf))
+				return i, lines + 1, true, nil
+			}
+			c := b[i]
+			if c == r.delim {
+				r.fieldEnds = append(r.fieldEnds, len(r.recordBuf))
+				i++
+				break
+			}
+			if c == '"' {
+				return r.skipLine(b, i, i, lines, atEOF, csv.ErrBareQuote)
+			}
+			switch n := lineEnd(b, i, atEOF); {
+			case n < 0:
+				return 0, 0, false, nil
+			case n > 0:
+				r.fieldEnds = append(r.fieldEnds, len(r.recordBuf))
+				return i + n, lines + 1, true, nil
+			}
+			r.recordBuf = append(r.recordBuf, c)
+			i++
+		}
+	}
+}
+
+// lineEnd returns the length of the line terminator at b[i], 0 if there is none and -1 if b ends on a \r that may be followed by \n. A trailing \r at EOF ends the line like it does for csv.Reader
+func lineEnd(b []byte, i int, atEOF bool) int {
+	switch {
+	case b[i] == '\n':
+		return 1
+	case b[i] != '\r':
+		return 0
+	case i+1 < len(b):
+		if b[i+1] == '\n' {
+			return 2
+		}
+		return 0
+	case atEOF:
+		return 1
+	}
+	return -1
+}
+
+// skipLine consumes the rest of a malformed line so the next Read starts on a fresh one, then returns err for the byte at b[at]
+func (r *mmapRecordReader) skipLine(b []byte, i int, at int, lines int, atEOF bool, err error) (int, int, bool, error) {
+	r.errCol = at - bytes.LastIndexByte(b[:at], '\n')
+	for ; i < len(b); i++ {
+		if b[i] == '\n' {
+			return i + 1, lines + 1, true, err
+		}
+	}
+	if !atEOF {
+		return 0, 0, false, nil
+	}
+	return i, lines + 1, true, err
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// openFiles, when set, keeps no more than its limit of the local outputs open at once, for --max-open-files
+var openFiles *fileCache
+
+// fileCache closes the least recently written of its files whenever opening one more would go over max
+type fileCache struct {
+	max  int
+	mu   sync.Mutex
+	open *list.List // the open files, most recently used first
+}
+
+func newFileCache(max int) *fileCache {
+	return &fileCache{max: max, open: list.New()}
+}
+
+// cachedFile is an output of a fileCache, reopened for appending whenever it is written to after being closed
+type cachedFile struct {
+	cache *fileCache
+	path  string
+	f     *os.File // nil while closed
+	elem  *list.Element
+	busy  bool  // in use outside the lock, so it can't be closed
+	err   error // from closing it to make room, returned by the next call
+	done  bool  // Close was called
+}
+
+// adopt puts an opened output under the cache's limit, closing others if it takes it over. A nil cache leaves f as it is
+func (c *fileCache) adopt(f *os.File) io.WriteCloser {
+	if c == nil {
+		return f
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.makeRoom()
+	file := &cachedFile{cache: c, path: f.Name(), f: f}
+	file.elem = c.open.PushFront(file)
+	return file
+}
+
+// makeRoom closes files until one more fits, skipping those in use. Each writer holds at most one, so with no more writers than max there is always one to close
+func (c *fileCache) makeRoom() {
+	for e := c.open.Back(); e != nil && c.open.Len() >= c.max; {
+		file := e.Value.(*cachedFile)
+		e = e.Prev()
+		if file.busy {
+			continue
+		}
+		if err := file.f.Close(); err != nil && file.err == nil {
+			file.err = err
+		}
+		file.f = nil
+		c.open.Remove(file.elem)
+		file.elem = nil
+	}
+}
+
+// acquire opens the file if it was closed and marks it in use until release
+func (file *cachedFile) acquire() (*os.File, error) {
+	c := file.cache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if file.err != nil {
+		return nil, file.err
+	}
+	if file.done {
+		return nil, os.ErrClosed
+	}
+	if file.f == nil {
+		c.makeRoom()
+		f, err := os.OpenFile(file.path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return nil, fmt.Errorf("reopening %s: %w", file.path, err)
+		}
+		file.f = f
+		file.elem = c.open.PushFront(file)
+	} else {
+		c.open.MoveToFront(file.elem)
+	}
+	file.busy = true
+	return file.f, nil
+}
+
+func (file *cachedFile) release() {
+	file.cache.mu.Lock()
+	file.busy = false
+	file.cache.mu.Unlock()
+}
+
+func (file *cachedFile) Write(p []byte) (int, error) {
+	f, err := file.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer file.release()
+	return f.Write(p)
+}
+
+// Sync commits the file to disk, reopening it if it was closed since, as closing doesn't sync
+func (file *cachedFile) Sync() error {
+	f, err := file.acquire()
+	if err != nil {
+		return err
+	}
+	defer file.release()
+	return f.Sync()
+}
+
+func (file *cachedFile) Truncate(size int64) error {
+	return os.Truncate(file.path, size)
+}
+
+func (file *cachedFile) Name() string {
+	return file.path
+}
+
+func (file *cachedFile) Close() error {
+	c := file.cache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if file.done {
+		return os.ErrClosed
+	}
+	file.done = true
+	err := file.err
+	if file.f != nil {
+		if cerr := file.f.Close(); err == nil {
+			err = cerr
+		}
+		file.f = nil
+		c.open.Remove(file.elem)
+		file.elem = nil
+	}
+	return err
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// nameTemplate lays out every output file name; {prefix} is the output prefix, {index} the 1-based bucket number and {ext} csv or jsonl
+var nameTemplate string
+
+// noPadIndex turns off zero-padding {index}, for tooling that expects the old prefix1.csv ... prefix10.csv names
+var noPadIndex bool
+
+// indexWidth is how many digits {index} is zero-padded to, 0 until a command knows how many outputs it writes
+var indexWidth int
+
+// nameToken matches a {token} in nameTemplate
+var nameToken = regexp.MustCompile(`\{[^{}]*\}`)
+
+// checkNameTemplate rejects --name-template values that can't name every bucket apart
+func checkNameTemplate(template string) error {
+	for _, token := range nameToken.FindAllString(template, -1) {
+		switch token {
+		case "{prefix}", "{index}", "{ext}":
+		default:
+			return fmt.Errorf("--name-template %q has unknown token %s, expected {prefix}, {index} or {ext}", template, token)
+		}
+	}
+	if !strings.Contains(template, "{index}") {
+		return fmt.Errorf("--name-template %q needs {index} to tell the outputs apart", template)
+	}
+	return nil
+}
+
+// outputDir is --output-dir, the directory a split writes into. Empty leaves the prefix as the whole path
+var outputDir string
+
+// inOutputDir puts a prefix, which is then a file name stem, under outputDir. A trailing slash is kept, making the stem a directory
+func inOutputDir(prefix string) (string, error) {
+	if isS3Prefix(outputDir) || isS3Prefix(prefix) {
+		return "", fmt.Errorf("--output-dir is a local directory, give an S3 destination as the prefix alone")
+	}
+	if filepath.IsAbs(prefix) {
+		return "", fmt.Errorf("the prefix %q is a file name stem under --output-dir, not an absolute path", prefix)
+	}
+	joined := filepath.Join(outputDir, prefix)
+	if strings.HasSuffix(prefix, "/") || strings.HasSuffix(prefix, string(filepath.Separator)) {
+		joined += string(filepath.Separator)
+	}
+	return joined, nil
+}
+
+// setOutputCount zero-pads bucket numbers to the width of n, so plain sorting lists prefix01.csv ... prefix10.csv in bucket order
+func setOutputCount(n int) {
+	if !noPadIndex {
+		indexWidth = len(strconv.Itoa(n))
+	}
+}
+
+// outputPath is the file a bucket is written to
+func outputPath(prefix string, bucket int) string {
+	index := fmt.Sprintf("%0*d", indexWidth, bucket+1)
+	path := strings.NewReplacer("{prefix}", prefix, "{index}", index, "{ext}", outputFormat(scanOpts.Format)).Replace(nameTemplate)
+	if compressOutputs {
+		path += ".gz"
+	}
+	return path
+}
+
+// tempSuffix marks a local output that is still being written. write renames the outputs to their final names only once every one of them is complete, so a failed or killed split leaves no file that looks finished
+const tempSuffix = ".tmp"
+
+// tempOutputPath is where a bucket is written until write renames it to outputPath
+func tempOutputPath(prefix string, bucket int) string {
+	return outputPath(prefix, bucket) + tempSuffix
+}
+
+// renameOutputs moves the first n outputs from their temporary names to their final ones. If one can't be moved, those already moved are put back
+// under their temporary names, so the split ends with none of its outputs looking finished rather than some
+func renameOutputs(prefix string, n int) error {
+	for i := range n {
+		if err := os.Rename(tempOutputPath(prefix, i), outputPath(prefix, i)); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				if undo := os.Rename(outputPath(prefix, j), tempOutputPath(prefix, j)); undo != nil {
+					return fmt.Errorf("%w, and %s could not be moved back, so the first %d outputs are final: %v", err, outputPath(prefix, j), j+1, undo)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// bucketFilePattern matches the files written with prefix under nameTemplate at any padding, capturing the bucket number
+func bucketFilePattern(prefix string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	last := 0
+	for _, loc := range nameToken.FindAllStringIndex(nameTemplate, -1) {
+		b.WriteString(regexp.QuoteMeta(nameTemplate[last:loc[0]]))
+		switch nameTemplate[loc[0]:loc[1]] {
+		case "{prefix}":
+			b.WriteString(regexp.QuoteMeta(prefix))
+		case "{index}":
+			b.WriteString(`(\d+)`)
+		case "{ext}":
+			b.WriteString(`(?:csv|jsonl)`)
+		}
+		last = loc[1]
+	}
+	b.WriteString(regexp.QuoteMeta(nameTemplate[last:]))
+	b.WriteString(`(\.gz)?$`)
+	return regexp.MustCompile(b.String())
+}
+
+// bucketFileGlob lists the candidates bucketFilePattern is checked against
+func bucketFileGlob(prefix string) string {
+	glob := strings.NewReplacer("{prefix}", globEscape(prefix), "{index}", "*", "{ext}", "*").Replace(nameTemplate)
+	return glob + "*"
+}
+
+// globEscape quotes the characters filepath.Match treats specially
+func globEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`).Replace(s)
+}
+
+// OutputFactory opens the destination for a bucket, keyed by bucket index
+type OutputFactory func(bucket int) (io.WriteCloser, error)
+
+// openOutputs is how write gets its OutputFactory, a test wraps it to make an output fail partway through
+var openOutputs = newOutputFactory
+
+// newOutputFactory picks the output backend from the prefix: s3:// prefixes upload to S3, anything else is a local path, written under its tempOutputPath. With --archive the outputs become members of <prefix>.tar instead, and with --compress every output is gzipped
+func newOutputFactory(prefix string) (OutputFactory, error) {
+	var factory OutputFactory = func(bucket int) (io.WriteCloser, error) {
+		f, err := os.Create(tempOutputPath(prefix, bucket))
+		if err != nil {
+			return nil, err
+		}
+		return openFiles.adopt(f), nil
+	}
+	if archiveOutputs {
+		var err error
+		if outputArchive, err = openArchive(prefix); err != nil {
+			return nil, err
+		}
+		factory = func(bucket int) (io.WriteCloser, error) {
+			return outputArchive.member(archiveMemberName(outputPath(prefix, bucket)))
+		}
+	} else if isS3Prefix(prefix) {
+		var err error
+		if factory, err = s3Outputs(prefix); err != nil {
+			return nil, err
+		}
+	}
+	if checksumAlgo != "" {
+		outputSums = newChecksums(checksumAlgo)
+		factory = outputSums.outputs(factory)
+	}
+	if compressOutputs {
+		factory = compressedOutputs(factory)
+	}
+	return factory, nil
+}
+
+// aborter is an output that can be dropped instead of closed, keeping none of what was written
+type aborter interface {
+	Abort() error
+}
+
+// discardOutput drops a partially written output: uploads are aborted, local files are closed and removed
+func discardOutput(file io.WriteCloser, path string) error {
+	if g, ok := file.(gzipOutput); ok {
+		file = g.dst
+	}
+	if c, ok := file.(*checksummedOutput); ok {
+		file = c.WriteCloser
+	}
+	if a, ok := file.(aborter); ok {
+		return a.Abort()
+	}
+	file.Close()
+	return os.Remove(path)
+}
+
+// openSidecar creates prefix+name next to the outputs, on the same backend, for files that describe a split rather than hold a bucket
+func openSidecar(prefix string, name string) (io.WriteCloser, error) {
+	if outputArchive != nil {
+		return outputArchive.member(archiveMemberName(prefix + name))
+	}
+	if isS3Prefix(prefix) {
+		open, err := s3Objects(prefix)
+		if err != nil {
+			return nil, err
+		}
+		return open(name)
+	}
+	return os.Create(prefix + name)
+}
+
+func isS3Prefix(prefix string) bool {
+	return strings.HasPrefix(prefix, "s3://")
+}
+
+// checkOutputPaths makes sure every bucket's output file could be written without writing any records: the file must be creatable (or writable if it already exists), the projected bytes must fit in the free space of each target filesystem, and the process must be allowed enough open files. It returns the number of problems found
+func checkOutputPaths(prefix string, buckets []FileBucket) int {
+	logInfo("check outputs", "checking output paths...")
+	problems := 0
+
+	type filesystem struct {
+		dir       string
+		projected int64
+		available uint64
+		known     bool
+	}
+	filesystems := map[string]*filesystem{}
+	order := []string{}
+
+	for i, bucket := range buckets {
+		// the write pass creates the temporary name, the final one only has to take the rename
+		path := tempOutputPath(prefix, i)
+		if err := probeWritable(path); err != nil {
+			logInfo("check outputs", "%s: %v", path, err)
+			problems++
+			continue
+		}
+		if err := probeRenameTarget(outputPath(prefix, i)); err != nil {
+			logInfo("check outputs", "%s: %v", outputPath(prefix, i), err)
+			problems++
+			continue
+		}
+
+		dir := filepath.Dir(path)
+		id, available, err := diskSpace(dir)
+		if err != nil {
+			// diskSpace is unavailable on this platform or the directory can't be stat'ed, group by directory instead
+			id = dir
+		}
+		fs, ok := filesystems[id]
+		if !ok {
+			fs = &filesystem{dir: dir, available: available, known: err == nil}
+			filesystems[id] = fs
+			order = append(order, id)
+		}
+		fs.projected += bucket.TotalSize
+	}
+
+	for _, id := range order {
+		fs := filesystems[id]
+		if !fs.known {
+			logInfo("check outputs", "%s: projected %s, free space unknown", fs.dir, displaySize(fs.projected, fmt.Sprintf("%d bytes", fs.projected)))
+			continue
+		}
+		available := int64(min(fs.available, math.MaxInt64))
+		logInfo("check outputs", "%s: projected %s, available %s", fs.dir, displaySize(fs.projected, fmt.Sprintf("%d bytes", fs.projected)), displaySize(available, fmt.Sprintf("%d bytes", available)))
+		if fs.projected > available {
+			short := fs.projected - available
+			logInfo("check outputs", "%s: not enough free space, short by %s", fs.dir, displaySize(short, fmt.Sprintf("%d bytes", short)))
+			problems++
+		}
+	}
+
+	// one descriptor per output file plus the input and stdio
+	needed := uint64(len(buckets) + 4)
+	if limit, ok := openFileLimit(); ok {
+		logInfo("check outputs", "open file limit: %d, needed: %d", limit, needed)
+		if needed > limit {
+			logInfo("check outputs", "open file limit too low, raise it with ulimit -n %d", needed)
+			problems++
+		}
+	}
+
+	return problems
+}
+
+var overwriteOutputs bool
+
+// outputTargets is every local file a split into n buckets writes with opts: the outputs, the manifest and the side files asked for, or just the archive with --archive
+func outputTargets(prefix string, n int, opts WriteOptions) []string {
+	if archiveOutputs {
+		return []string{archivePath(prefix)}
+	}
+	paths := []string{}
+	for i := range n {
+		paths = append(paths, outputPath(prefix, i))
+	}
+	paths = append(paths, prefix+manifestName)
+	if checksumAlgo == "sha256" {
+		paths = append(paths, prefix+checksumsName)
+	}
+	if opts.RejectFile {
+		paths = append(paths, prefix+rejectedName)
+	}
+	if opts.Oversized != nil {
+		paths = append(paths, prefix+oversizedName)
+	}
+	return paths
+}
+
+// existingOutputs lists the files a split into n buckets would overwrite, without creating or touching any of them. S3 prefixes aren't checked
+func existingOutputs(prefix string, n int, opts WriteOptions) []string {
+	if isS3Prefix(prefix) {
+		return nil
+	}
+	existing := []string{}
+	for _, path := range outputTargets(prefix, n, opts) {
+		if _, err := os.Stat(path); err == nil {
+			existing = append(existing, path)
+		}
+	}
+	return existing
+}
+
+// staleOutputs lists the bucket files at prefix that a split into n buckets doesn't write, left by an earlier run with more buckets, another zero-padding or --compress
+func staleOutputs(prefix string, n int) ([]string, error) {
+	if isS3Prefix(prefix) || archiveOutputs {
+		return nil, nil
+	}
+	matches, err := filepath.Glob(bucketFileGlob(prefix))
+	if err != nil {
+		return nil, err
+	}
+	current := map[string]bool{}
+	for i := range n {
+		current[filepath.Clean(outputPath(prefix, i))] = true
+	}
+	pattern := bucketFilePattern(prefix)
+	stale := []string{}
+	for _, path := range matches {
+		if pattern.MatchString(path) && !current[filepath.Clean(path)] {
+			stale = append(stale, path)
+		}
+	}
+	return stale, nil
+}
+
+// checkOverwrite stops a split into n buckets that would replace files at prefix, unless --overwrite is given. With it, the bucket files
+// of an earlier run that the split won't replace are removed, so the prefix ends up holding only this run's outputs
+func checkOverwrite(prefix string, n int, opts WriteOptions) error {
+	existing := existingOutputs(prefix, n, opts)
+	if !overwriteOutputs {
+		if len(existing) == 0 {
+			return nil
+		}
+		return fmt.Errorf("%d files the split would write already exist, pass --overwrite to replace them:\n  %s", len(existing), strings.Join(existing, "\n  "))
+	}
+	stale, err := staleOutputs(prefix, n)
+	if err != nil {
+		return err
+	}
+	for _, path := range stale {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		logInfo("write", "removed %s, left by an earlier split with other outputs", path)
+	}
+	return nil
+}
+
+// probeWritable checks path can be opened for writing. Existing files are opened without truncating, new files are created and removed again
+func probeWritable(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err == nil {
+		return f.Close()
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(path)
+}
+
+// probeRenameTarget checks a finished output can be renamed to path: its directory must exist and path, if it is there already, must not be a directory
+func probeRenameTarget(path string) error {
+	if info, err := os.Stat(filepath.Dir(path)); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", filepath.Dir(path))
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return fmt.Errorf("is a directory, the finished output can't be renamed over it")
+	}
+	return nil
+}
+
+// sideRows collects rows that belong to no bucket, such as filtered or oversized ones, in a sidecar file with the input's header
+type sideRows struct {
+	path string
+	file io.WriteCloser
+	w    RecordWriter
+	rows int
+}
+
+// openSideRows creates prefix+name for rows of the given input format
+func openSideRows(prefix, name, format string) (*sideRows, error) {
+	file, err := openSidecar(prefix, name)
+	if err != nil {
+		return nil, err
+	}
+	return &sideRows{path: prefix + name, file: file, w: newRecordWriter(format, file)}, nil
+}
+
+// header writes the header row, which isn't counted
+func (s *sideRows) header(record []string) {
+	if s != nil {
+		s.w.Write(record)
+	}
+}
+
+func (s *sideRows) write(record []string) {
+	if s != nil {
+		s.w.Write(record)
+		s.rows++
+	}
+}
+
+// close flushes and closes the file, reporting what it holds as the given kind of rows
+func (s *sideRows) close(kind string) error {
+	if s == nil {
+		return nil
+	}
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		s.file.Close()
+		return fmt.Errorf("writing %s: %w", s.path, err)
+	}
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	logInfo("write", "wrote %s %s rows to %s", FormatNumber(int64(s.rows)), kind, s.path)
+	return nil
+}
+
+// discard drops the file of an interrupted run
+func (s *sideRows) discard() {
+	if s == nil {
+		return
+	}
+	if err := discardOutput(s.file, s.path); err != nil {
+		logInfo("write", "could not discard partial output %s: %v", s.path, err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+var partitionBy string
+
+// checkPartition rejects flags that size-based packing needs and --partition-by doesn't do
+func checkPartition(sizeCap int64) error {
+	switch {
+	case !hasHeader(scanOpts.Format):
+		return fmt.Errorf("--partition-by needs a header row, it isn't supported %s", withoutHeader(scanOpts.Format))
+	case scanOpts.GroupColumn != "":
+		return fmt.Errorf("--partition-by already keeps every key in one bucket, drop --keep-groups-together")
+	case sizeCap > 0:
+		return fmt.Errorf("--partition-by cannot be combined with %s, the bucket count decides where each key goes", capFlag)
+	case streamingPack:
+		return fmt.Errorf("--partition-by cannot be combined with --streaming-pack")
+	case precomputeSizes:
+		return fmt.Errorf("--partition-by cannot be combined with --precompute-sizes, the size cache doesn't hold the keys")
+	case packOpts.MaxCountSpread > 0:
+		return fmt.Errorf("--partition-by cannot be combined with --max-count-spread")
+	}
+	return nil
+}
+
+// partitionHash picks the bucket of a key, the same for every run and input. FNV-1a alone spreads short keys badly: its low bits only mix the low bits of each byte and its high bits barely move, so the hash goes through murmur3's finalizer before the modulo
+func partitionHash(key string, buckets int) uint32 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return uint32(x % uint64(buckets))
+}
+
+// partition assigns every row to the bucket its key hashes to, so rows with the same key share an output however unevenly that spreads the sizes. keys holds each group id's key, as scanned with the key column as group column
+func partition(ctx context.Context, metas []LineMeta, keys []string, bucketsN int) ([]FileBucket, Assignment) {
+	start := time.Now()
+	logInfo("binpack", "partitioning rows by %s...", partitionBy)
+	keyBucket := make([]uint32, len(keys))
+	keysPerBucket := make([]int, bucketsN)
+	for id := 1; id < len(keys); id++ {
+		keyBucket[id] = partitionHash(keys[id], bucketsN)
+		keysPerBucket[keyBucket[id]]++
+	}
+
+	maxLine := 0
+	for _, meta := range metas {
+		maxLine = max(maxLine, meta.LineNumber)
+	}
+	buckets := make([]FileBucket, bucketsN)
+	copy(buckets, packOpts.Initial)
+	assign := make(Assignment, maxLine+1)
+	for i, meta := range metas {
+		if i%cancelCheckEvery == 0 && ctx.Err() != nil {
+			exitCancelled("binpack")
+		}
+		b := keyBucket[meta.Group]
+		buckets[b].TotalSize += meta.Size
+		buckets[b].Lines++
+		assign[meta.LineNumber] = b + 1
+	}
+	logInfo("binpack", "partitioning finished in %s", time.Since(start))
+	printBuckets(buckets)
+	printPartitionSkew(buckets, keysPerBucket)
+	printLineTotals(buckets, len(metas))
+	return buckets, assign
+}
+
+// printPartitionSkew reports how the keys spread over the buckets, since a few heavy keys or fewer keys than buckets leave a partitioning lopsided
+func printPartitionSkew(buckets []FileBucket, keysPerBucket []int) {
+	var total int64
+	fullest := 0
+	for i, bucket := range buckets {
+		total += bucket.TotalSize
+		if bucket.TotalSize > buckets[fullest].TotalSize {
+			fullest = i
+		}
+	}
+	fewest, most, empty := keysPerBucket[0], keysPerBucket[0], 0
+	for _, n := range keysPerBucket {
+		fewest, most = min(fewest, n), max(most, n)
+		if n == 0 {
+			empty++
+		}
+	}
+	logInfo("binpack", "keys per bucket: min %d, max %d", fewest, most)
+	if total > 0 {
+		share := float64(total) / float64(len(buckets))
+		logInfo("binpack", "fullest bucket: %d with %.2f%% of an even bucket share", fullest+1, float64(buckets[fullest].TotalSize)/share*100)
+	}
+	if empty > 0 {
+		logWarn("binpack", "%d of %d buckets got no key and will be written empty", empty, len(buckets))
+	}
+}
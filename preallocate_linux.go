@@ -0,0 +1,13 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// preallocate reserves size bytes for f with fallocate, which also extends the file to that size until it is truncated
+func preallocate(f *os.File, size int64) error {
+	return syscall.Fallocate(int(f.Fd()), 0, 0, size)
+}
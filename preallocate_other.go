@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func preallocate(f *os.File, size int64) error {
+	return errors.New("preallocation not supported on this platform")
+}
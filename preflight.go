@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+var preflight bool
+var assumeYes bool
+
+// printPreflight prints an inspect-style summary of the scanned rows and the projected size of each bucket
+func printPreflight(metas []LineMeta, bucketsN int) {
+	sizes := make([]int64, len(metas))
+	total := int64(0)
+	for i, meta := range metas {
+		sizes[i] = meta.Size
+		total += meta.Size
+	}
+	slices.Sort(sizes)
+
+	logInfo("preflight", "rows: %s, total size: %s", FormatNumber(int64(len(metas))), displaySize(total, FormatNumber(total)+" bytes"))
+	if len(sizes) > 0 {
+		logInfo("preflight", "row size min: %d, p50: %d, p90: %d, p99: %d, max: %d", sizes[0], percentile(sizes, 0.5), percentile(sizes, 0.9), percentile(sizes, 0.99), sizes[len(sizes)-1])
+	}
+	perBucket := total / int64(bucketsN)
+	logInfo("preflight", "buckets: %d, projected size per bucket: %s, rows per bucket: %s", bucketsN, displaySize(perBucket, FormatNumber(perBucket)+" bytes"), FormatNumber(int64(len(metas)/bucketsN)))
+}
+
+// percentile picks the nearest-rank percentile from sorted values
+func percentile(sorted []int64, p float64) int64 {
+	i := int(p*float64(len(sorted))+0.5) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+// confirmPreflight asks whether to go ahead with the split. It only prompts when stdin is a terminal, non-interactive runs carry on
+func confirmPreflight() bool {
+	if assumeYes {
+		return true
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return true
+	}
+
+	fmt.Fprint(reportOut, "Continue with the split? [y/N] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+var progressMode string
+
+// progressInterval is how often a running phase reports progress
+const progressInterval = 2 * time.Second
+
+// progressCheckEvery is how many rows pass between clock checks, so reporting costs next to nothing per row
+const progressCheckEvery = 1024
+
+// progressBarWidth is the number of cells in --progress bar
+const progressBarWidth = 30
+
+// checkProgressMode rejects unknown --progress values
+func checkProgressMode(mode string) error {
+	switch mode {
+	case "off", "plain", "bar":
+		return nil
+	}
+	return fmt.Errorf("unknown --progress %q, expected off, plain or bar", mode)
+}
+
+// progress reports a phase's row count, rate and, when the total is known, percentage and ETA to stderr so stdout keeps only the summaries
+type progress struct {
+	phase string
+	total int // rows expected, 0 when unknown
+	start time.Time
+	last  time.Time
+	shown bool
+}
+
+func newProgress(phase string, total int) *progress {
+	now := time.Now()
+	return &progress{phase: phase, total: total, start: now, last: now}
+}
+
+// update is called with the rows handled so far and reports at most once per progressInterval
+func (p *progress) update(rows int) {
+	if progressMode == "off" || rows%progressCheckEvery != 0 {
+		return
+	}
+	now := time.Now()
+	if now.Sub(p.last) < progressInterval {
+		return
+	}
+	p.last = now
+	p.report(rows, now)
+}
+
+// done ends a bar on its own line, plain reports need nothing more
+func (p *progress) done() {
+	if progressMode == "bar" && p.shown {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+func (p *progress) report(rows int, now time.Time) {
+	rate := float64(rows) / now.Sub(p.start).Seconds()
+	line := fmt.Sprintf("%s %s lines, %s lines/s", p.phase, FormatNumber(int64(rows)), FormatNumber(int64(rate)))
+	if p.total > 0 {
+		fraction := min(float64(rows)/float64(p.total), 1)
+		eta := max(time.Duration(float64(p.total-rows)/rate*float64(time.Second)).Round(time.Second), 0)
+		line = fmt.Sprintf("%s %s of %s lines (%.1f%%), %s lines/s, ETA %s", p.phase, FormatNumber(int64(rows)), FormatNumber(int64(p.total)), fraction*100, FormatNumber(int64(rate)), eta)
+		if progressMode == "bar" {
+			cells := int(fraction * progressBarWidth)
+			line = fmt.Sprintf("%s [%s%s] %.1f%%, %s of %s lines, %s lines/s, ETA %s", p.phase, strings.Repeat("#", cells), strings.Repeat(".", progressBarWidth-cells), fraction*100, FormatNumber(int64(rows)), FormatNumber(int64(p.total)), FormatNumber(int64(rate)), eta)
+		}
+	}
+	if progressMode == "bar" {
+		// redraw in place, padding over whatever a longer previous line left behind
+		fmt.Fprintf(os.Stderr, "\r%-100s", line)
+	} else {
+		fmt.Fprintln(os.Stderr, line)
+	}
+	p.shown = true
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// csvDelimiter separates fields in every CSV the tool reads or writes, set with --delimiter
+var csvDelimiter = ','
+
+// lenientFields makes the CSV readers pad a row with fewer fields than the header with empty ones and cut one with more down to size, instead of failing, for --lenient
+var lenientFields bool
+
+// parseDelimiter accepts a single character, or \t / tab for tabs
+func parseDelimiter(s string) (rune, error) {
+	if s == `\t` || s == "tab" {
+		return '\t', nil
+	}
+	r, n := utf8.DecodeRuneInString(s)
+	if n == 0 || n != len(s) {
+		return 0, fmt.Errorf("delimiter %q must be a single character", s)
+	}
+	if r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+		return 0, fmt.Errorf("delimiter %q can't be used", s)
+	}
+	return r, nil
+}
+
+func newCSVReader(r io.Reader) *csv.Reader {
+	cr := csv.NewReader(r)
+	cr.Comma = csvDelimiter
+	return cr
+}
+
+// fitFields pads record with empty fields or cuts it down to n of them. ok is false when it had to
+func fitFields(record []string, n int) (fitted []string, ok bool) {
+	if len(record) == n {
+		return record, true
+	}
+	if len(record) > n {
+		return record[:n], false
+	}
+	return append(record, make([]string, n-len(record))...), false
+}
+
+// fieldCountError explains a row whose field count differs from the header's, which the readers report wrapping csv.ErrFieldCount
+func fieldCountError(err error, record []string, fields int) error {
+	if fields == 0 || !errors.Is(err, csv.ErrFieldCount) {
+		return err
+	}
+	return fmt.Errorf("%w (%d fields where the header has %d, --lenient pads or truncates such rows)", err, len(record), fields)
+}
+
+// fittedReader is a RecordReader that can say how many rows --lenient padded or truncated
+type fittedReader interface {
+	Fitted() int
+}
+
+// fittedRows is how many rows r padded or truncated, 0 for readers that never do
+func fittedRows(r RecordReader) int {
+	if f, ok := r.(fittedReader); ok {
+		return f.Fitted()
+	}
+	return 0
+}
+
+func newCSVWriter(w io.Writer) *csv.Writer {
+	cw := csv.NewWriter(w)
+	cw.Comma = csvDelimiter
+	return cw
+}
+
+// recordBytes is how many bytes newCSVWriter writes for record, following encoding/csv's quoting rules and counting the trailing newline
+func recordBytes(record []string) int64 {
+	n := int64(len(record)-1) * int64(utf8.RuneLen(csvDelimiter))
+	for _, field := range record {
+		n += int64(len(field))
+		if fieldNeedsQuotes(field) {
+			// surrounding quotes plus one extra for every doubled quote
+			n += 2 + int64(strings.Count(field, `"`))
+		}
+	}
+	return n + 1
+}
+
+// fieldNeedsQuotes mirrors csv.Writer's decision to quote a field
+func fieldNeedsQuotes(field string) bool {
+	if field == "" {
+		return false
+	}
+	if field == `\.` || strings.ContainsRune(field, csvDelimiter) || strings.ContainsAny(field, "\"\r\n") {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(field)
+	return unicode.IsSpace(r)
+}
+
+// RecordReader yields the input one record at a time, header rows included. size is the record's balancing weight. When the fields were read but the weight can't be determined, Read returns the fields along with an error wrapping ErrBadSize so callers can skip the row and keep going. io.EOF ends the input
+type RecordReader interface {
+	Read() (fields []string, size int64, err error)
+}
+
+// ErrBadSize marks a record whose fields were read but whose size could not be determined
+var ErrBadSize = errors.New("bad size")
+
+// RecordReaderFactory wraps the raw input in a RecordReader that measures records as described by size
+type RecordReaderFactory func(r io.Reader, size SizeSpec) RecordReader
+
+var recordFormats = map[string]RecordReaderFactory{
+	"csv": newCSVRecordReader,
+}
+
+// RegisterFormat makes a custom input format selectable with --format name. Call it from an init function before the command runs; outputs are still written as CSV from the returned fields
+//
+//	func init() {
+//		RegisterFormat("fixed", func(r io.Reader, size SizeSpec) RecordReader { return newFixedWidthReader(r, size) })
+//	}
+func RegisterFormat(name string, factory RecordReaderFactory) {
+	recordFormats[name] = factory
+}
+
+func newRecordReader(format string, r io.Reader, size SizeSpec) (RecordReader, error) {
+	factory, ok := recordFormats[format]
+	if !ok {
+		names := []string{}
+		for name := range recordFormats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown format %q, available: %v", format, names)
+	}
+	return factory(r, size), nil
+}
+
+type csvRecordReader struct {
+	r           *csv.Reader
+	size        SizeSpec
+	headerSeen  bool
+	headersLeft int // header rows after the first still to read, which may have any field count
+	fields      int // the header's field count, which data rows are held to
+	fitted      int // rows lenientFields padded or truncated
+}
+
+func newCSVRecordReader(r io.Reader, size SizeSpec) RecordReader {
+	cr := newCSVReader(bufio.NewReader(r))
+	if lenientFields || headerRows > 1 {
+		// fitted to the header's count below rather than rejected, or held to it once the header rows are read
+		cr.FieldsPerRecord = -1
+	}
+	return &csvRecordReader{r: cr, size: size, headersLeft: max(headerRows-1, 0)}
+}
+
+func (c *csvRecordReader) Read() ([]string, int64, error) {
+	record, err := c.r.Read()
+	if err != nil {
+		return nil, 0, fieldCountError(err, record, c.fields)
+	}
+	if !c.headerSeen {
+		c.headerSeen = true
+		c.fields = len(record)
+		c.dataRowsNext()
+		if c.size, err = c.size.Resolve(record); err != nil {
+			return record, 0, err
+		}
+	} else if c.headersLeft > 0 {
+		c.headersLeft--
+		c.dataRowsNext()
+		return record, 0, nil
+	} else if lenientFields && c.fields > 0 {
+		var ok bool
+		if record, ok = fitFields(record, c.fields); !ok {
+			c.fitted++
+		}
+	}
+	size, err := c.size.Parse(record)
+	if err != nil {
+		return record, 0, fmt.Errorf("%w: %v", ErrBadSize, err)
+	}
+	return record, size, nil
+}
+
+// dataRowsNext holds the rows after the last header row to the first one's field count, unless --lenient fits them instead
+func (c *csvRecordReader) dataRowsNext() {
+	if c.headersLeft == 0 && !lenientFields {
+		c.r.FieldsPerRecord = c.fields
+	}
+}
+
+func (c *csvRecordReader) Fitted() int {
+	return c.fitted
+}
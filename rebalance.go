@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+var rebalanceManifest bool
+
+// rebalanceOnBadSize is kept apart from scanOpts.OnBadSize, whose default the other commands set to skip
+var rebalanceOnBadSize string
+
+var rebalanceCmd = &cobra.Command{
+	Use:   "rebalance <output_prefix> <new_buckets>",
+	Short: "Repack the files of an existing split into a new number of balanced files",
+	Long:  "Reads every <output_prefix>N.csv (or .csv.gz) file, packs their combined rows into <new_buckets> balanced buckets and replaces the old files with the new ones. The new set is written in full to a temporary directory next to the outputs before any old file is touched.",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		prefix := args[0]
+		bucketsN, err := parseBucketCount(args[1])
+		if err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if isS3Prefix(prefix) {
+			logError("", "rebalance only supports local output paths")
+			os.Exit(1)
+		}
+		scanOpts.Format = "csv"
+		if scanOpts.Size, err = sizeSpecFromFlags(scanOpts.Size, sizeMode, sizeSource, splitBy); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if err := checkHeaderRows(scanOpts); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		scanOpts.OnBadSize = rebalanceOnBadSize
+		if err := checkBadSizePolicy(scanOpts.OnBadSize); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if scanOpts.OnBadSize == badSizeSkip {
+			logError("", "rebalance can't skip rows with a bad size, they are in no other copy; use --on-bad-size fail or zero")
+			os.Exit(1)
+		}
+		if err := rebalance(cmd, prefix, bucketsN); err != nil {
+			logError("", "%v", err)
+			exit(1)
+		}
+	},
+}
+
+func init() {
+	rebalanceCmd.Flags().StringVarP(&scanOpts.Size.Column, "size-column", "c", "", "name or zero-based index of the column holding each row's size (default 2)")
+	rebalanceCmd.Flags().StringVar(&sizeMode, "size-mode", "absolute", "absolute reads the size column as bytes, relative as decimal weights such as percentages")
+	rebalanceCmd.Flags().StringVar(&sizeSource, "size", "column", "column reads each row's size from the size column, bytes measures the row as written to the output")
+	rebalanceCmd.Flags().StringVar(&splitBy, "by", "size", "balance the files by total size, or by row count with lines")
+	rebalanceCmd.Flags().StringVar(&rebalanceOnBadSize, "on-bad-size", badSizeFail, "what to do with a row whose size can't be read: fail, or zero to pack it as size 0")
+	rebalanceCmd.Flags().IntVar(&headerRows, "header-rows", 1, "the --header-rows the split ran with, kept at the top of every new file")
+	rebalanceCmd.Flags().BoolVar(&noHeader, "no-header", false, "the split ran with --no-header")
+	rebalanceCmd.Flags().BoolVar(&rebalanceManifest, "manifest", true, "write <prefix>manifest.json for the new files; false removes the old one instead of leaving it stale")
+}
+
+// rebalance repacks the outputs at prefix into bucketsN files. The old outputs are merged into one file in a temporary directory beside them,
+// split from there into the same directory, and only once every new output is complete are they renamed over the old ones
+func rebalance(cmd *cobra.Command, prefix string, bucketsN int) error {
+	paths, err := discoverBucketFiles(prefix)
+	if err != nil {
+		return err
+	}
+	// the new files are compressed if the old ones were, so they keep the names the other commands look for
+	compressOutputs = isGzipPath(paths[0])
+
+	// renames only replace files atomically within one file system, so the temporary directory sits next to the outputs
+	dir, base := filepath.Split(prefix)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.MkdirTemp(dir, ".rebalance-")
+	if err != nil {
+		return err
+	}
+	atExit(func() { os.RemoveAll(tmp) })
+	defer os.RemoveAll(tmp)
+	tmpPrefix := filepath.Join(tmp, base)
+	if base == "" {
+		tmpPrefix = tmp + string(filepath.Separator)
+	}
+
+	combined := filepath.Join(tmp, "combined.csv")
+	if err := merge(prefix, combined); err != nil {
+		return err
+	}
+	metas := scan(cmd.Context(), combined, scanOpts)
+	highest := 0
+	if len(metas) > 0 {
+		highest = metas[len(metas)-1].LineNumber
+	}
+	setOutputCount(bucketsN)
+	buckets, assign, err := pack(cmd.Context(), metas, bucketsN, packOpts)
+	if err != nil {
+		return err
+	}
+	// strict makes a row the packing lost fail the run here, before the only other copy of it is overwritten
+	write(cmd.Context(), combined, tmpPrefix, buckets, assign, WriteOptions{Format: "csv", ChannelBuffer: defaultChannelBuffer, ExpectedRecords: highest, Strict: true})
+	if cmd.Context().Err() != nil {
+		exitCancelled("rebalance")
+	}
+
+	// every new output is complete, from here on each rename replaces one old file as a whole
+	keep := map[string]bool{}
+	for i := range buckets {
+		path := outputPath(prefix, i)
+		if err := os.Rename(outputPath(tmpPrefix, i), path); err != nil {
+			return fmt.Errorf("replacing %s: %w", path, err)
+		}
+		keep[filepath.Clean(path)] = true
+	}
+	removed := 0
+	for _, path := range paths {
+		// fewer buckets, or a narrower zero-padding, leaves old files the new set doesn't overwrite
+		if keep[filepath.Clean(path)] {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+	}
+	logInfo("rebalance", "replaced %d files at %s with %d, removing %d old files", len(paths), prefix, bucketsN, removed)
+
+	if !rebalanceManifest {
+		if err := os.Remove(prefix + manifestName); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return moveManifest(tmpPrefix, prefix, bucketsN)
+}
+
+// moveManifest rewrites the manifest write left at tmpPrefix as <prefix>manifest.json, naming the outputs where they were moved to
+func moveManifest(tmpPrefix string, prefix string, bucketsN int) error {
+	manifest, err := loadManifest(tmpPrefix)
+	if err != nil {
+		return err
+	}
+	files := make([]ManifestFile, bucketsN)
+	for _, file := range manifest.Files {
+		file.File = outputPath(prefix, file.Bucket-1)
+		files[file.Bucket-1] = file
+	}
+	return writeManifestFiles(prefix, manifest.InputRows, files)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+var skewTopK int
+var skewJSON bool
+
+// BucketSkew is how concentrated one bucket's size is in its largest rows
+type BucketSkew struct {
+	Bucket    int     `json:"bucket"`
+	Rows      int     `json:"rows"`
+	TotalSize int64   `json:"total_size"`
+	TopLines  []int   `json:"top_lines"`
+	TopSize   int64   `json:"top_size"`
+	TopShare  float64 `json:"top_share"`
+	// LargestExceedsMean is set when the bucket's largest row alone is bigger than the mean bucket, so no packing can even it out
+	LargestExceedsMean bool `json:"largest_exceeds_mean"`
+}
+
+var reportSkewCmd = &cobra.Command{
+	Use:   "report-skew <input_csv> <buckets>",
+	Short: "Report how much of each bucket's size comes from its largest rows",
+	Long:  "Runs the scan and binpack passes and reports, per bucket, the share of its total size contributed by its top K rows. A high share means the bucket is dominated by a few rows, which more buckets or a different packing can't fix.",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		input := args[0]
+		bucketsN, err := parseBucketCount(args[1])
+		if err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if skewTopK < 1 {
+			logError("", "--top must be at least 1")
+			os.Exit(1)
+		}
+
+		if err := checkScanFlags(); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+
+		// with --json stdout only carries the document, the bucket lines of the binpack pass go to stderr
+		reportOut = cmd.OutOrStdout()
+		if skewJSON {
+			reportOut = cmd.ErrOrStderr()
+		}
+		metas := scan(cmd.Context(), input, scanOpts)
+		buckets, assign, err := pack(cmd.Context(), metas, bucketsN, packOpts)
+		if err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+
+		skews := bucketSkews(metas, buckets, assign, skewTopK)
+		if skewJSON {
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(skews); err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		logInfo("report-skew", "share of each bucket's size held by its top %d rows", skewTopK)
+		for _, s := range skews {
+			note := ""
+			if s.LargestExceedsMean {
+				note = " (largest row alone exceeds the mean bucket size)"
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "  bucket %d: top %d rows = %.1f%% of %s across %s rows, lines %v%s\n",
+				s.Bucket, len(s.TopLines), s.TopShare*100, displaySize(s.TotalSize, FormatNumber(s.TotalSize)), FormatNumber(int64(s.Rows)), s.TopLines, note)
+		}
+	},
+}
+
+// bucketSkews computes, for every bucket, the rows with the k largest sizes and the share of the bucket they make up
+func bucketSkews(metas []LineMeta, buckets []FileBucket, assign Assignment, k int) []BucketSkew {
+	sizes := make(map[int]int64, len(metas))
+	var total int64
+	for _, m := range metas {
+		sizes[m.LineNumber] += m.Size
+		total += m.Size
+	}
+	mean := float64(total) / float64(len(buckets))
+
+	bucketLines := assign.BucketLines(len(buckets))
+	skews := make([]BucketSkew, len(buckets))
+	for i, b := range buckets {
+		lines := bucketLines[i]
+		sort.Slice(lines, func(x, y int) bool {
+			if sizes[lines[x]] != sizes[lines[y]] {
+				return sizes[lines[x]] > sizes[lines[y]]
+			}
+			return lines[x] < lines[y]
+		})
+		top := lines[:min(k, len(lines))]
+
+		s := BucketSkew{Bucket: i + 1, Rows: len(lines), TotalSize: b.TotalSize, TopLines: top}
+		for _, line := range top {
+			s.TopSize += sizes[line]
+		}
+		if b.TotalSize > 0 {
+			s.TopShare = float64(s.TopSize) / float64(b.TotalSize)
+		}
+		if len(top) > 0 {
+			s.LargestExceedsMean = float64(sizes[top[0]]) > mean
+		}
+		skews[i] = s
+	}
+	return skews
+}
+
+func init() {
+	addScanFlags(reportSkewCmd)
+	reportSkewCmd.Flags().IntVar(&skewTopK, "top", 3, "number of largest rows to measure per bucket")
+	reportSkewCmd.Flags().BoolVar(&skewJSON, "json", false, "print the report as JSON")
+	reportSkewCmd.Flags().Float64Var(&packOpts.MaxCountSpread, "max-count-spread", 0, "apply the same row count constraint as split")
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// s3PartSize is how much of a bucket is buffered before it is sent as one part. S3 requires every part but the last to be at least 5MiB
+const s3PartSize = 8 * 1024 * 1024
+
+var s3Region string
+var s3MaxConcurrentParts int
+
+// s3Uploads is shared by every bucket's writer to bound and account for the part uploads in flight.
+// Slots are per part, not per multipart upload: every bucket's upload is created when the write pass opens its outputs and completed when it closes them, so bounding whole uploads would leave the buckets without a slot unable to send anything until the pass ends
+type s3Uploads struct {
+	slots   chan struct{} // nil when uploads are unbounded
+	start   time.Time
+	bytes   atomic.Int64
+	retries atomic.Int64
+	open    atomic.Int64
+}
+
+func (u *s3Uploads) acquire() {
+	if u.slots != nil {
+		u.slots <- struct{}{}
+	}
+}
+
+func (u *s3Uploads) release() {
+	if u.slots != nil {
+		<-u.slots
+	}
+}
+
+// s3Outputs streams each bucket to s3://<bucket>/<key prefix><n>.csv through a multipart upload. Credentials come from the standard AWS chain (env, shared config, instance role)
+func s3Outputs(prefix string) (OutputFactory, error) {
+	// object keys are the key prefix followed by the name, so the template can't put anything before {prefix}
+	if !strings.HasPrefix(nameTemplate, "{prefix}") {
+		return nil, fmt.Errorf("with an s3:// prefix, --name-template must start with {prefix}")
+	}
+	open, err := s3Objects(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return func(i int) (io.WriteCloser, error) {
+		return open(outputPath("", i))
+	}, nil
+}
+
+// s3Objects returns a function that opens s3://<bucket>/<key prefix><name> for writing through a multipart upload
+func s3Objects(prefix string) (func(name string) (io.WriteCloser, error), error) {
+	bucket, keyPrefix, ok := strings.Cut(strings.TrimPrefix(prefix, "s3://"), "/")
+	if !ok || bucket == "" {
+		return nil, fmt.Errorf("invalid s3 prefix %q, expected s3://bucket/prefix", prefix)
+	}
+
+	opts := []func(*config.LoadOptions) error{}
+	if s3Region != "" {
+		opts = append(opts, config.WithRegion(s3Region))
+	}
+	cfg, err := config.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	client := s3.NewFromConfig(cfg)
+
+	uploads := &s3Uploads{start: time.Now()}
+	if s3MaxConcurrentParts > 0 {
+		uploads.slots = make(chan struct{}, s3MaxConcurrentParts)
+	}
+
+	return func(name string) (io.WriteCloser, error) {
+		key := keyPrefix + name
+		out, err := client.CreateMultipartUpload(context.Background(), &s3.CreateMultipartUploadInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("starting upload of s3://%s/%s: %w", bucket, key, err)
+		}
+		uploads.open.Add(1)
+		return &s3Writer{client: client, uploads: uploads, bucket: bucket, key: key, uploadID: out.UploadId}, nil
+	}, nil
+}
+
+// s3Writer buffers writes into parts of a multipart upload, the object only appears once Close completes the upload.
+// A full part is uploaded in the background while the next one fills up, so a bucket waiting for an upload slot keeps accepting records instead of stalling the read loop for every other bucket. Only a second full part has to wait for the first to finish
+type s3Writer struct {
+	client   *s3.Client
+	uploads  *s3Uploads
+	bucket   string
+	key      string
+	uploadID *string
+	buf      bytes.Buffer
+	parts    []types.CompletedPart
+	inflight chan error // result of the part being uploaded, nil when none is
+	err      error
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf.Write(p)
+	for w.buf.Len() >= s3PartSize {
+		if err := w.wait(); err != nil {
+			return 0, err
+		}
+		w.startPart(bytes.Clone(w.buf.Next(s3PartSize)))
+	}
+	return len(p), nil
+}
+
+// wait blocks until the in flight part, if any, has been uploaded
+func (w *s3Writer) wait() error {
+	if w.inflight != nil {
+		if err := <-w.inflight; err != nil && w.err == nil {
+			w.err = err
+		}
+		w.inflight = nil
+	}
+	return w.err
+}
+
+func (w *s3Writer) startPart(data []byte) {
+	w.inflight = make(chan error, 1)
+	go func() {
+		w.uploads.acquire()
+		defer w.uploads.release()
+		w.inflight <- w.uploadPart(data)
+	}()
+}
+
+func (w *s3Writer) uploadPart(data []byte) error {
+	partNumber := int32(len(w.parts) + 1)
+	out, err := w.client.UploadPart(context.Background(), &s3.UploadPartInput{
+		Bucket:     aws.String(w.bucket),
+		Key:        aws.String(w.key),
+		UploadId:   w.uploadID,
+		PartNumber: aws.Int32(partNumber),
+		Body:       bytes.NewReader(data),
+	})
+	if err != nil {
+		return fmt.Errorf("uploading part %d of s3://%s/%s: %w", partNumber, w.bucket, w.key, err)
+	}
+	if attempts, ok := retry.GetAttemptResults(out.ResultMetadata); ok && len(attempts.Results) > 1 {
+		w.uploads.retries.Add(int64(len(attempts.Results) - 1))
+	}
+	w.uploads.bytes.Add(int64(len(data)))
+	w.parts = append(w.parts, types.CompletedPart{
+		PartNumber:        aws.Int32(partNumber),
+		ETag:              out.ETag,
+		ChecksumCRC32:     out.ChecksumCRC32,
+		ChecksumCRC32C:    out.ChecksumCRC32C,
+		ChecksumCRC64NVME: out.ChecksumCRC64NVME,
+		ChecksumSHA1:      out.ChecksumSHA1,
+		ChecksumSHA256:    out.ChecksumSHA256,
+	})
+	return nil
+}
+
+// Close uploads whatever is still buffered as the last part and completes the upload, aborting it if anything failed so no orphaned parts are left billed
+func (w *s3Writer) Close() error {
+	defer w.closed()
+
+	if w.wait() == nil && (w.buf.Len() > 0 || len(w.parts) == 0) {
+		w.startPart(w.buf.Bytes())
+		w.wait()
+	}
+	if w.err != nil {
+		w.abort()
+		return w.err
+	}
+
+	_, err := w.client.CompleteMultipartUpload(context.Background(), &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(w.bucket),
+		Key:             aws.String(w.key),
+		UploadId:        w.uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: w.parts},
+	})
+	if err != nil {
+		return fmt.Errorf("completing upload of s3://%s/%s: %w", w.bucket, w.key, err)
+	}
+	logInfo("write", "uploaded s3://%s/%s (%d parts)", w.bucket, w.key, len(w.parts))
+	return nil
+}
+
+// Abort drops the upload and every part already sent, instead of completing it
+func (w *s3Writer) Abort() error {
+	defer w.closed()
+	w.wait()
+	return w.abort()
+}
+
+func (w *s3Writer) abort() error {
+	_, err := w.client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(w.bucket),
+		Key:      aws.String(w.key),
+		UploadId: w.uploadID,
+	})
+	return err
+}
+
+// closed reports the upload totals once the last bucket is closed
+func (w *s3Writer) closed() {
+	if w.uploads.open.Add(-1) > 0 {
+		return
+	}
+	elapsed := time.Since(w.uploads.start)
+	uploaded := w.uploads.bytes.Load()
+	logInfo("write", "uploaded %s in %s (%.2f MB/s), %d retries", displaySize(uploaded, fmt.Sprintf("%d bytes", uploaded)), elapsed, float64(uploaded)/(1024*1024)/elapsed.Seconds(), w.uploads.retries.Load())
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var sampleRows int
+var sampleRandom bool
+var sampleSeed int64
+
+var sampleCmd = &cobra.Command{
+	Use:   "sample <output_prefix>",
+	Short: "Print a few rows from every file of a split",
+	Long:  "Finds every <output_prefix>N.csv (or .jsonl, or gzipped) file, prints their shared header once and then, for each file in bucket order, its row count, size and first --rows data rows, or with --random that many rows picked at random in one pass.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if sampleRows < 0 {
+			logError("", "--rows can't be negative")
+			os.Exit(1)
+		}
+		if err := checkHeaderRows(ScanOptions{Format: scanOpts.Format}); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if sampleRandom && !cmd.Flags().Changed("seed") {
+			sampleSeed = time.Now().UnixNano()
+		}
+		if sampleRandom {
+			logInfo("sample", "sampling with seed %d", sampleSeed)
+		}
+		if err := sample(args[0]); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	sampleCmd.Flags().IntVar(&sampleRows, "rows", 5, "data rows to print from each file")
+	sampleCmd.Flags().BoolVar(&sampleRandom, "random", false, "print rows picked at random from the whole file instead of its first ones")
+	sampleCmd.Flags().Int64Var(&sampleSeed, "seed", 0, "random seed for --random, random unless set")
+	sampleCmd.Flags().StringVar(&scanOpts.Format, "format", "csv", "the --format the split ran with, jsonl outputs are printed as JSON lines")
+	sampleCmd.Flags().IntVar(&headerRows, "header-rows", 1, "the --header-rows the split ran with, printed once")
+	sampleCmd.Flags().BoolVar(&noHeader, "no-header", false, "the split ran with --no-header, so every row is data")
+}
+
+// sampledRow is a data row sample kept, with its 1-based row number in its file
+type sampledRow struct {
+	line   int
+	record []string
+}
+
+func sample(prefix string) error {
+	paths, err := discoverBucketFiles(prefix)
+	if err != nil {
+		return err
+	}
+	manifest, err := readManifest(prefix)
+	if err != nil {
+		return err
+	}
+	pattern := bucketFilePattern(prefix)
+	rng := rand.New(rand.NewSource(sampleSeed))
+
+	var header [][]string
+	w := newRecordWriter(scanOpts.Format, os.Stdout)
+	totalRows := 0
+	for i, path := range paths {
+		rows, h, sampled, err := sampleFile(path, rng)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			header = h
+			for _, record := range header {
+				w.Write(record)
+			}
+		} else if !slices.EqualFunc(header, h, slices.Equal) {
+			logWarn("sample", "%s: header %v does not match %v", path, h, header)
+		}
+		totalRows += rows
+
+		size := ""
+		if m := pattern.FindStringSubmatch(path); m != nil && manifest != nil {
+			bucket, _ := strconv.Atoi(m[1])
+			if file, ok := manifest[bucket]; ok {
+				size = ", size " + displaySize(file.TotalSize, FormatNumber(file.TotalSize))
+				if file.Lines != rows {
+					logWarn("sample", "%s has %s rows, the manifest says %s", path, FormatNumber(int64(rows)), FormatNumber(int64(file.Lines)))
+				}
+			}
+		}
+		if stat, err := os.Stat(path); err == nil {
+			size += ", " + displaySize(stat.Size(), FormatNumber(stat.Size())+" bytes") + " on disk"
+		}
+		w.Flush()
+		fmt.Printf("\n%s: %s rows%s\n", path, FormatNumber(int64(rows)), size)
+		for _, row := range sampled {
+			w.Write(row.record)
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("\n%s rows in %d files\n", FormatNumber(int64(totalRows)), len(paths))
+	return nil
+}
+
+// sampleFile counts the data rows of a bucket file and keeps sampleRows of them: the first ones, or with --random a reservoir sample in row order
+func sampleFile(path string, rng *rand.Rand) (int, [][]string, []sampledRow, error) {
+	sampled := []sampledRow{}
+	rows := 0
+	header, err := readRecords(path, outputFormat(scanOpts.Format), SizeSpec{Lines: true}, nil, func(line int, record []string, _ int64, _ error) {
+		rows = line
+		switch {
+		case len(sampled) < sampleRows:
+			sampled = append(sampled, sampledRow{line, record})
+		case sampleRandom:
+			// each of the line rows seen so far stays with probability sampleRows/line
+			if j := rng.Intn(line); j < sampleRows {
+				sampled[j] = sampledRow{line, record}
+			}
+		}
+	})
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	sort.Slice(sampled, func(i, j int) bool { return sampled[i].line < sampled[j].line })
+	return rows, header, sampled, nil
+}
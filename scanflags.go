@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// addScanFlags registers on cmd the flags that decide how split scans its input and sizes every row, so a command that replays split's packing reads the input the same way
+func addScanFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&scanOpts.Size.Column, "size-column", "c", "", "name or zero-based index of the column holding each row's size (default 2)")
+	cmd.Flags().StringVar(&scanOpts.Size.Expr, "size-expr", "", "the --size-expr the split ran with")
+	cmd.Flags().StringVar(&scanOpts.Size.Field, "size-field", "", "dotted path to each object's size with --format jsonl, e.g. meta.bytes")
+	cmd.Flags().StringVar(&scanOpts.Format, "format", "csv", "input format, one of the registered record readers")
+	cmd.Flags().StringVar(&sizeMode, "size-mode", "absolute", "absolute reads the size column as bytes, relative as decimal weights such as percentages")
+	cmd.Flags().StringVar(&sizeSource, "size", "column", "column reads each row's size from the size column, bytes measures the row as written to the output")
+	cmd.Flags().StringVar(&splitBy, "by", "size", "the --by the split ran with, lines packs by row count alone")
+	cmd.Flags().StringVar(&scanOpts.OnBadSize, "on-bad-size", badSizeSkip, "the --on-bad-size the split ran with, skip leaves rows without a usable size out, fail aborts, zero packs them as size 0")
+	cmd.Flags().StringArrayVar(&filterExprs, "filter", nil, "the --filter expressions the split ran with, whose left-out rows aren't packed")
+	cmd.Flags().BoolVar(&lenientFields, "lenient", false, "the split ran with --lenient, packing rows with the wrong field count instead of stopping")
+	cmd.Flags().BoolVar(&scanOpts.SkipEmptyRows, "skip-empty-rows", false, "the split ran with --skip-empty-rows, whose empty rows aren't packed")
+	cmd.Flags().IntVar(&headerRows, "header-rows", 1, "the --header-rows the split ran with")
+	cmd.Flags().BoolVar(&noHeader, "no-header", false, "the split ran with --no-header")
+}
+
+// checkScanFlags validates the flags addScanFlags registered and fills scanOpts from them the way split does
+func checkScanFlags() error {
+	var err error
+	if scanOpts.Size, err = sizeSpecFromFlags(scanOpts.Size, sizeMode, sizeSource, splitBy); err != nil {
+		return err
+	}
+	if err := checkBadSizePolicy(scanOpts.OnBadSize); err != nil {
+		return err
+	}
+	if err := checkFormatOptions(scanOpts); err != nil {
+		return err
+	}
+	if err := checkHeaderRows(scanOpts); err != nil {
+		return err
+	}
+	if len(filterExprs) > 0 {
+		if err := checkFilter(); err != nil {
+			return err
+		}
+		if scanOpts.Filter, err = parseFilters(filterExprs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
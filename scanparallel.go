@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// parallelScanMin is the smallest input scanned in parallel, below it starting the workers costs more than they save
+const parallelScanMin = 64 << 20
+
+// canScanParallel says whether f can be scanned as byte ranges: a large, uncompressed csv file read without --mmap, whose metas are collected rather than emitted
+func canScanParallel(f *os.File, filename string, opts ScanOptions, emit func(LineMeta)) bool {
+	if opts.Threads < 2 || emit != nil || opts.Mmap || opts.Format != "csv" || isGzipPath(filename) {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode().IsRegular() && info.Size() >= parallelScanMin
+}
+
+// scanRange is what a worker of a parallel scan found in its byte range, with line numbers counted from the range's first row
+type scanRange struct {
+	records int
+	metas   []LineMeta
+	ends    []int64 // the offset every record ends at, when the scan collects offsets
+	events  []scanEvent
+	rows    *rowScan
+	fitted  int // rows --lenient padded or truncated
+	err     error
+}
+
+// scanParallel scans f as opts.Threads byte ranges at once, each starting on a row boundary, and merges them into what the serial scan produces.
+// It returns false before printing anything the scan found if a range can't be parsed, so the serial scan can report the error where it is
+func scanParallel(ctx context.Context, f *os.File, opts ScanOptions, start time.Time) ([]LineMeta, bool) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, false
+	}
+	size := info.Size()
+	hr := newCSVRecordReader(io.NewSectionReader(f, 0, size), opts.Size).(*csvRecordReader)
+	// the first record fixes the size column and field count, even when it is a data row rather than a header
+	headers := headerCount(opts.Format)
+	var header []string
+	fields, headerEnd := 0, int64(0)
+	for i := range max(headers, 1) {
+		record, _, err := hr.Read()
+		if err != nil && !errors.Is(err, ErrBadSize) {
+			return nil, false
+		}
+		if i == 0 {
+			fields = len(record)
+		}
+		if i == 0 && headers > 0 {
+			header = record
+		}
+		if i < headers {
+			headerEnd = hr.InputOffset()
+		}
+	}
+	rows, err := newRowScan(opts, header)
+	if err != nil {
+		logError("", "%v", err)
+		exit(1)
+	}
+	bounds, err := rowBoundaries(f, headerEnd, size, opts.Threads)
+	if err != nil {
+		logInfo("meta scan", "can't split the input into ranges (%v), scanning it serially", err)
+		return nil, false
+	}
+	logInfo("meta scan", "scanning %d byte ranges in parallel", len(bounds)-1)
+
+	parts := make([]scanRange, len(bounds)-1)
+	var read atomic.Int64
+	var wg sync.WaitGroup
+	for i := range parts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			parts[i] = scanByteRange(ctx, f, bounds[i], bounds[i+1], fields, hr.size, *rows, opts.RowOffsets != nil, &read)
+		}()
+	}
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	prog := newProgress("[meta scan]", 0)
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for waiting := true; waiting; {
+		select {
+		case <-finished:
+			waiting = false
+		case <-ticker.C:
+			n := int(read.Load())
+			prog.update(n - n%progressCheckEvery)
+		}
+	}
+	prog.done()
+	if ctx.Err() != nil {
+		exitCancelled("meta scan")
+	}
+	for i, part := range parts {
+		if part.err != nil {
+			logInfo("meta scan", "byte range %d failed to parse (%v), rescanning serially to report it", i+1, part.err)
+			return nil, false
+		}
+	}
+
+	// replay the ranges in input order, so messages, group ids and line numbers come out as the serial scan has them
+	total, records := 0, 0
+	for _, part := range parts {
+		total += len(part.metas)
+		records += part.records
+	}
+	metas := make([]LineMeta, 0, total)
+	var offsets []int64
+	if opts.RowOffsets != nil {
+		offsets = make([]int64, 0, records+2)
+		offsets = append(offsets, 0, headerEnd)
+	}
+	rep := newScanReport(opts)
+	line := 1
+	for i := range parts {
+		part := &parts[i]
+		for _, e := range part.events {
+			e.line += line - 1
+			rep.report(e)
+		}
+		ids := make([]int, len(part.rows.groupNames))
+		for id := 1; id < len(ids); id++ {
+			ids[id] = rows.group(part.rows.groupNames[id])
+			rows.groupSizes[ids[id]] += part.rows.groupSizes[id]
+		}
+		for _, meta := range part.metas {
+			meta.LineNumber += line - 1
+			meta.Group = ids[meta.Group]
+			metas = append(metas, meta)
+		}
+		offsets = append(offsets, part.ends...)
+		rows.filtered += part.rows.filtered
+		rows.empty += part.rows.empty
+		rows.zeroed += part.rows.zeroed
+		rows.fitted += part.fitted
+		line += part.records
+		*part = scanRange{}
+	}
+	highest := 0
+	if len(metas) > 0 {
+		highest = metas[len(metas)-1].LineNumber
+	}
+	if opts.RowOffsets != nil {
+		*opts.RowOffsets = offsets
+	}
+	if opts.GroupKeys != nil {
+		*opts.GroupKeys = rows.groupNames
+	}
+	rows.printSummary(rep, start, len(metas), highest, line)
+	return metas, true
+}
+
+// scanByteRange scans the rows in [from, to) of f with a copy of rows of its own, collecting its events to be reported once the ranges before it are
+func scanByteRange(ctx context.Context, f *os.File, from, to int64, fields int, size SizeSpec, rows rowScan, offsets bool, read *atomic.Int64) scanRange {
+	part := scanRange{rows: &rows}
+	rows.groupIDs, rows.groupNames, rows.groupSizes = map[string]int{}, []string{""}, []int64{0}
+	rows.filtered, rows.empty, rows.zeroed = 0, 0, 0
+	rows.report = func(e scanEvent) { part.events = append(part.events, e) }
+
+	cr := newCSVReader(bufio.NewReader(io.NewSectionReader(f, from, to-from)))
+	// the header fixes the field count for the serial scan, a range has to be told it
+	cr.FieldsPerRecord = fields
+	if lenientFields {
+		cr.FieldsPerRecord = -1
+	}
+	r := &csvRecordReader{r: cr, size: size, headerSeen: true, fields: fields}
+	for {
+		if part.records%cancelCheckEvery == 0 && part.records > 0 {
+			if ctx.Err() != nil {
+				return part
+			}
+			read.Add(cancelCheckEvery)
+		}
+		record, size, err := r.Read()
+		if err == io.EOF {
+			part.fitted = r.fitted
+			return part
+		}
+		if err != nil && !errors.Is(err, ErrBadSize) {
+			part.err = err
+			return part
+		}
+		part.records++
+		if offsets {
+			part.ends = append(part.ends, from+r.InputOffset())
+		}
+		if meta, ok := rows.add(part.records, record, size, err); ok {
+			part.metas = append(part.metas, meta)
+		}
+	}
+}
+
+// rowBoundaries splits [from, to) of f into n ranges that each start on a row. A newline only ends a row outside a quoted field, and with strict quoting
+// every '"' opens or closes one or is half of an escaped pair, so the parity of the quotes before a point says whether it is inside a quoted field
+func rowBoundaries(f *os.File, from, to int64, n int) ([]int64, error) {
+	nominal := make([]int64, n+1)
+	for i := range nominal {
+		nominal[i] = from + (to-from)*int64(i)/int64(n)
+	}
+	quotes := make([]int64, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			quotes[i], errs[i] = countQuotes(io.NewSectionReader(f, nominal[i], nominal[i+1]-nominal[i]))
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	bounds := []int64{from}
+	before := int64(0)
+	for i := 1; i < n; i++ {
+		before += quotes[i-1]
+		at := max(nominal[i], bounds[len(bounds)-1])
+		if at == nominal[i] {
+			// the row boundary following a range's nominal start is the first newline outside quotes
+			next, err := nextRowStart(io.NewSectionReader(f, at, to-at), before%2 == 1)
+			if err != nil {
+				return nil, err
+			}
+			at += next
+		}
+		if at > bounds[len(bounds)-1] && at < to {
+			bounds = append(bounds, at)
+		}
+	}
+	return append(bounds, to), nil
+}
+
+// countQuotes counts the '"' bytes r holds
+func countQuotes(r io.Reader) (int64, error) {
+	buf := make([]byte, 1<<20)
+	count := int64(0)
+	for {
+		n, err := r.Read(buf)
+		count += int64(bytes.Count(buf[:n], []byte{'"'}))
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// nextRowStart is the offset just past the first newline in r outside a quoted field, quoted saying whether r starts inside one, or r's length if there is none
+func nextRowStart(r io.Reader, quoted bool) (int64, error) {
+	br := bufio.NewReader(r)
+	for pos := int64(1); ; pos++ {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return pos - 1, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case b == '"':
+			quoted = !quoted
+		case b == '\n' && !quoted:
+			return pos, nil
+		}
+	}
+}
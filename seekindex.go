@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+var seekIndex bool
+
+// seekIndexThreshold is the share of the input's bytes below which write seeks past the rows it doesn't need. Above it, reading straight through the input costs less than the seeks
+const seekIndexThreshold = 0.5
+
+// seekGap is the smallest gap write seeks over, shorter ones are discarded from the read buffer instead
+const seekGap = 1 << 20
+
+// offsetReader is a RecordReader that knows the byte offset its next record starts at
+type offsetReader interface {
+	InputOffset() int64
+}
+
+// InputOffset is where the next record starts, the end of the last one read
+func (c *csvRecordReader) InputOffset() int64 {
+	return c.r.InputOffset()
+}
+
+// InputOffset is where the next record starts, the end of the last one read
+func (r *mmapRecordReader) InputOffset() int64 {
+	return r.base + int64(r.pos)
+}
+
+// checkSeekIndex rejects inputs whose byte offsets can't be seeked to in the write pass
+func checkSeekIndex(input string) error {
+	switch {
+	case scanOpts.Format != "csv":
+		return fmt.Errorf("--seek-index only supports csv input")
+	case isGzipPath(input):
+		return fmt.Errorf("--seek-index can't seek into compressed input")
+	case precomputeSizes:
+		return fmt.Errorf("--seek-index cannot be combined with --precompute-sizes, the size cache doesn't hold offsets")
+	case streamingPack:
+		return fmt.Errorf("--seek-index cannot be combined with --streaming-pack, the offsets take 8 bytes per row in memory")
+	}
+	return nil
+}
+
+// rowSeeker positions the write pass's reader at the start of the next row it needs
+type rowSeeker struct {
+	f   *os.File
+	br  *bufio.Reader
+	pos int64 // offset of the next byte br returns
+}
+
+// to moves the reader forward to offset, seeking over long gaps and discarding short ones from the buffer
+func (s *rowSeeker) to(offset int64) error {
+	gap := offset - s.pos
+	if gap < 0 || gap > seekGap {
+		if _, err := s.f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		s.br.Reset(s.f)
+	} else if _, err := s.br.Discard(int(gap)); err != nil {
+		return err
+	}
+	s.pos = offset
+	return nil
+}
+
+// neededShare is the share of the data rows' bytes taken up by the rows wanted says must be written
+func neededShare(offsets []int64, last int, wanted func(line int) bool) float64 {
+	needed := int64(0)
+	for line := 1; line <= last; line++ {
+		if wanted(line) {
+			needed += offsets[line+1] - offsets[line]
+		}
+	}
+	total := offsets[last+1] - offsets[1]
+	if total == 0 {
+		return 1
+	}
+	return float64(needed) / float64(total)
+}
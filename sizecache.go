@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// sizeCacheMagic starts every size cache file, bump the version whenever the layout changes
+const sizeCacheMagic = "BPSIZES5"
+
+// sizeCacheRecord is the encoded length of one meta: line number, size and group as little endian uint64s
+const sizeCacheRecord = 24
+
+// sizeFingerprint identifies the input and the settings a size cache was computed with, any difference invalidates it
+type sizeFingerprint struct {
+	InputSize   int64
+	ModTime     int64
+	HeaderRows  int64
+	Format      string
+	Delimiter   string
+	GroupColumn string
+	OnBadSize   string
+	Size        string // the SizeSpec formatted with %+v, so new size settings invalidate old caches automatically
+}
+
+func newSizeFingerprint(stat os.FileInfo, opts ScanOptions) sizeFingerprint {
+	return sizeFingerprint{
+		InputSize:   stat.Size(),
+		ModTime:     stat.ModTime().UnixNano(),
+		HeaderRows:  int64(headerCount(opts.Format)),
+		Format:      opts.Format,
+		Delimiter:   string(csvDelimiter),
+		GroupColumn: opts.GroupColumn,
+		OnBadSize:   opts.OnBadSize,
+		Size:        fmt.Sprintf("%+v", opts.Size),
+	}
+}
+
+func sizeCachePath(input string) string {
+	return input + ".sizes"
+}
+
+// loadSizeCache returns the cached metas when the cache exists and matches fp
+func loadSizeCache(path string, fp sizeFingerprint) ([]LineMeta, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	magic := make([]byte, len(sizeCacheMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != sizeCacheMagic {
+		return nil, false
+	}
+	cached, err := readFingerprint(r)
+	if err != nil || cached != fp {
+		return nil, false
+	}
+
+	var count uint64
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, false
+	}
+	metas := make([]LineMeta, count)
+	var rec [sizeCacheRecord]byte
+	for i := range metas {
+		if _, err := io.ReadFull(r, rec[:]); err != nil {
+			return nil, false
+		}
+		metas[i] = LineMeta{
+			LineNumber: int(binary.LittleEndian.Uint64(rec[0:])),
+			Size:       int64(binary.LittleEndian.Uint64(rec[8:])),
+			Group:      int(binary.LittleEndian.Uint64(rec[16:])),
+		}
+	}
+	return metas, true
+}
+
+func saveSizeCache(path string, fp sizeFingerprint, metas []LineMeta) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+
+	w.WriteString(sizeCacheMagic)
+	writeFingerprint(w, fp)
+	binary.Write(w, binary.LittleEndian, uint64(len(metas)))
+	var rec [sizeCacheRecord]byte
+	for _, meta := range metas {
+		binary.LittleEndian.PutUint64(rec[0:], uint64(meta.LineNumber))
+		binary.LittleEndian.PutUint64(rec[8:], uint64(meta.Size))
+		binary.LittleEndian.PutUint64(rec[16:], uint64(meta.Group))
+		w.Write(rec[:])
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeFingerprint(w io.Writer, fp sizeFingerprint) {
+	binary.Write(w, binary.LittleEndian, [3]int64{fp.InputSize, fp.ModTime, fp.HeaderRows})
+	for _, s := range []string{fp.Format, fp.Delimiter, fp.GroupColumn, fp.OnBadSize, fp.Size} {
+		binary.Write(w, binary.LittleEndian, uint32(len(s)))
+		io.WriteString(w, s)
+	}
+}
+
+func readFingerprint(r io.Reader) (sizeFingerprint, error) {
+	var fp sizeFingerprint
+	var nums [3]int64
+	if err := binary.Read(r, binary.LittleEndian, &nums); err != nil {
+		return fp, err
+	}
+	fp.InputSize, fp.ModTime, fp.HeaderRows = nums[0], nums[1], nums[2]
+
+	strs := make([]string, 5)
+	for i := range strs {
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return fp, err
+		}
+		if n > 1<<16 {
+			return fp, errors.New("corrupt fingerprint")
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return fp, err
+		}
+		strs[i] = string(b)
+	}
+	fp.Format, fp.Delimiter, fp.GroupColumn, fp.OnBadSize, fp.Size = strs[0], strs[1], strs[2], strs[3], strs[4]
+	return fp, nil
+}
+
+// scanCached serves metas from the size cache next to the input when it is still valid, otherwise it scans and refreshes the cache
+func scanCached(ctx context.Context, input string, stat os.FileInfo, opts ScanOptions) []LineMeta {
+	path := sizeCachePath(input)
+	fp := newSizeFingerprint(stat, opts)
+	if metas, ok := loadSizeCache(path, fp); ok {
+		logInfo("size cache", "hit: loaded %d sizes from %s", len(metas), path)
+		return metas
+	}
+
+	logInfo("size cache", "miss: computing sizes into %s", path)
+	metas := scan(ctx, input, opts)
+	if err := saveSizeCache(path, fp, metas); err != nil {
+		logInfo("size cache", "could not write cache: %v", err)
+	}
+	return metas
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// exprOp is one step of a compiled --size-expr, run on a stack: push a constant or a column's value, or combine the top two values
+type exprOp struct {
+	op     byte // 'n' pushes value, 'c' pushes the field at index, '+', '-' and '*' combine, '~' negates
+	value  int64
+	column string // the column as written, a name or colN
+	index  int
+}
+
+// parseSizeExpr compiles an arithmetic expression over columns, such as "col2 + 8*col4". colN is the zero-based column N, any other
+// name is looked up in the header. Integer constants, + - *, unary minus and parentheses are supported, with * binding tighter
+func parseSizeExpr(expr string) ([]exprOp, error) {
+	p := &exprParser{src: expr}
+	p.next()
+	if err := p.sum(); err != nil {
+		return nil, fmt.Errorf("--size-expr %q: %w", expr, err)
+	}
+	if p.tok != "" {
+		return nil, fmt.Errorf("--size-expr %q: unexpected %q at offset %d", expr, p.tok, p.at)
+	}
+	return p.ops, nil
+}
+
+// exprParser is a recursive descent parser emitting the ops in postfix order
+type exprParser struct {
+	src string
+	pos int
+	at  int    // where tok starts
+	tok string // the current token, empty at the end
+	ops []exprOp
+}
+
+// next moves to the next token: a number, a column name, or a single operator or parenthesis
+func (p *exprParser) next() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+	p.at = p.pos
+	if p.pos == len(p.src) {
+		p.tok = ""
+		return
+	}
+	isWord := func(r byte) bool {
+		return r == '_' || r == '.' || unicode.IsLetter(rune(r)) || unicode.IsDigit(rune(r))
+	}
+	end := p.pos + 1
+	if isWord(p.src[p.pos]) {
+		for end < len(p.src) && isWord(p.src[end]) {
+			end++
+		}
+	}
+	p.tok, p.pos = p.src[p.at:end], end
+}
+
+func (p *exprParser) sum() error {
+	if err := p.product(); err != nil {
+		return err
+	}
+	for p.tok == "+" || p.tok == "-" {
+		op := p.tok[0]
+		p.next()
+		if err := p.product(); err != nil {
+			return err
+		}
+		p.ops = append(p.ops, exprOp{op: op})
+	}
+	return nil
+}
+
+func (p *exprParser) product() error {
+	if err := p.operand(); err != nil {
+		return err
+	}
+	for p.tok == "*" {
+		p.next()
+		if err := p.operand(); err != nil {
+			return err
+		}
+		p.ops = append(p.ops, exprOp{op: '*'})
+	}
+	return nil
+}
+
+func (p *exprParser) operand() error {
+	tok, at := p.tok, p.at
+	switch {
+	case tok == "":
+		return fmt.Errorf("expression ends where a column or number was expected")
+	case tok == "-":
+		p.next()
+		if err := p.operand(); err != nil {
+			return err
+		}
+		p.ops = append(p.ops, exprOp{op: '~'})
+		return nil
+	case tok == "(":
+		p.next()
+		if err := p.sum(); err != nil {
+			return err
+		}
+		if p.tok != ")" {
+			return fmt.Errorf("missing ) for the ( at offset %d", at)
+		}
+		p.next()
+		return nil
+	case tok[0] >= '0' && tok[0] <= '9':
+		n, err := strconv.ParseInt(tok, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q at offset %d is not an integer constant", tok, at)
+		}
+		p.ops = append(p.ops, exprOp{op: 'n', value: n})
+	case strings.ContainsRune("+*)", rune(tok[0])):
+		return fmt.Errorf("unexpected %q at offset %d where a column or number was expected", tok, at)
+	case tok[0] == '_' || tok[0] == '.' || unicode.IsLetter(rune(tok[0])):
+		p.ops = append(p.ops, exprOp{op: 'c', column: tok})
+	default:
+		return fmt.Errorf("unexpected %q at offset %d, only columns, integers, + - * and parentheses are supported", tok, at)
+	}
+	p.next()
+	return nil
+}
+
+// columnIndex is the zero-based index a colN reference names, if it is one
+func (o exprOp) columnIndex() (string, bool) {
+	n, ok := strings.CutPrefix(o.column, "col")
+	if _, err := strconv.Atoi(n); !ok || err != nil {
+		return "", false
+	}
+	return n, true
+}
+
+// resolveSizeExpr binds every column of ops to its field, names through the header, and rejects columns the header doesn't have
+func resolveSizeExpr(ops []exprOp, header []string) ([]exprOp, error) {
+	resolved := make([]exprOp, len(ops))
+	for i, o := range ops {
+		if o.op == 'c' {
+			spec := o.column
+			if n, ok := o.columnIndex(); ok {
+				spec = n
+			}
+			index, err := resolveColumn(header, spec)
+			if err != nil {
+				return nil, fmt.Errorf("--size-expr: %w", err)
+			}
+			o.index = index
+		}
+		resolved[i] = o
+	}
+	return resolved, nil
+}
+
+// exprNames lists the columns of ops referenced by name rather than as colN, which need a header row to resolve
+func exprNames(ops []exprOp) []string {
+	names := []string{}
+	for _, o := range ops {
+		if _, ok := o.columnIndex(); o.op == 'c' && !ok {
+			names = append(names, o.column)
+		}
+	}
+	return names
+}
+
+// evalSizeExpr computes a record's size from resolved ops. Fields that aren't integers, overflow and negative results are errors
+func evalSizeExpr(ops []exprOp, record []string) (int64, error) {
+	stack := make([]int64, 0, 8)
+	for _, o := range ops {
+		switch o.op {
+		case 'n':
+			stack = append(stack, o.value)
+			continue
+		case 'c':
+			if o.index >= len(record) {
+				return 0, fmt.Errorf("row has %d fields, no column %s at index %d", len(record), o.column, o.index)
+			}
+			v, err := strconv.ParseInt(record[o.index], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("column %s: %w", o.column, err)
+			}
+			stack = append(stack, v)
+			continue
+		case '~':
+			if stack[len(stack)-1] == math.MinInt64 {
+				return 0, fmt.Errorf("size expression overflows")
+			}
+			stack[len(stack)-1] = -stack[len(stack)-1]
+			continue
+		}
+		a, b := stack[len(stack)-2], stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		var v int64
+		switch o.op {
+		case '+':
+			v = a + b
+			if (v > a) != (b > 0) {
+				return 0, fmt.Errorf("size expression overflows")
+			}
+		case '-':
+			v = a - b
+			if (v < a) != (b > 0) {
+				return 0, fmt.Errorf("size expression overflows")
+			}
+		case '*':
+			v = a * b
+			if a != 0 && (v/a != b || a == -1 && b == math.MinInt64) {
+				return 0, fmt.Errorf("size expression overflows")
+			}
+		}
+		stack[len(stack)-1] = v
+	}
+	if stack[0] < 0 {
+		return 0, fmt.Errorf("size expression gives %d, sizes can't be negative", stack[0])
+	}
+	return stack[0], nil
+}
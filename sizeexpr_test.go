@@ -0,0 +1,103 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSizeExpr(t *testing.T) {
+	header := []string{"id", "bytes", "name", "attachments"}
+	record := []string{"1", "100", "x", "3"}
+	for _, tc := range []struct {
+		expr string
+		want int64
+	}{
+		{"col1", 100},
+		{"col1 + 8*col3", 124},
+		{"bytes + 8*attachments", 124},
+		{"8*attachments+bytes", 124},
+		{"(bytes - 10) * 2", 180},
+		{"bytes - 10 * 2", 80},
+		{"-col3 + 200", 197},
+		{"2*3+4", 10},
+		{"col0 * (col1 + col3)", 103},
+	} {
+		ops, err := parseSizeExpr(tc.expr)
+		if err != nil {
+			t.Errorf("parseSizeExpr(%q): %v", tc.expr, err)
+			continue
+		}
+		if ops, err = resolveSizeExpr(ops, header); err != nil {
+			t.Errorf("resolveSizeExpr(%q): %v", tc.expr, err)
+			continue
+		}
+		got, err := evalSizeExpr(ops, record)
+		if err != nil {
+			t.Errorf("evalSizeExpr(%q): %v", tc.expr, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q gives %d, want %d", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestSizeExprRejectsMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		want string
+	}{
+		{"", "expression ends where a column or number was expected"},
+		{"col1 +", "expression ends where a column or number was expected"},
+		{"col1 * * 2", `unexpected "*" at offset 7`},
+		{"(col1 + 2", "missing ) for the ( at offset 0"},
+		{"col1 / 2", `unexpected "/"`},
+		{"col1 col3", `unexpected "col3" at offset 5`},
+		{"99999999999999999999", "is not an integer constant"},
+	} {
+		_, err := parseSizeExpr(tc.expr)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("parseSizeExpr(%q) = %v, want an error containing %q", tc.expr, err, tc.want)
+		}
+	}
+}
+
+func TestSizeExprRejectsUnknownColumns(t *testing.T) {
+	header := []string{"id", "bytes"}
+	for _, expr := range []string{"col2", "bytes + attachments"} {
+		ops, err := parseSizeExpr(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := resolveSizeExpr(ops, header); err == nil {
+			t.Errorf("resolveSizeExpr(%q) accepted a column the header doesn't have", expr)
+		}
+	}
+
+	dir := t.TempDir()
+	input, _ := writeCSV(t, dir, 5)
+	prefix := filepath.Join(dir, "out")
+	_, stderr, code := runBinpacking(t, "split", input, "2", prefix, "--size-expr", "size + 8*col7")
+	if code != 1 || !strings.Contains(stderr, "--size-expr") {
+		t.Errorf("split --size-expr over a missing column exited %d and printed %q, want it rejected up front", code, stderr)
+	}
+	if matches, _ := filepath.Glob(prefix + "*"); len(matches) > 0 {
+		t.Errorf("rejected split left %v", matches)
+	}
+}
+
+func TestSizeExprRejectsBadRows(t *testing.T) {
+	ops, err := parseSizeExpr("col0 - col1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ops, err = resolveSizeExpr(ops, []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range [][]string{{"1", "2"}, {"1", "x"}, {"1"}} {
+		if got, err := evalSizeExpr(ops, record); err == nil {
+			t.Errorf("col0 - col1 over %q gave %d, want an error", record, got)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/spf13/cobra"
+)
+
+var splitOnChangeNameByValue bool
+
+var splitOnChangeCmd = &cobra.Command{
+	Use:   "split-on-change <input_csv> <column> <output_prefix>",
+	Short: "Start a new output file whenever the value of a column changes",
+	Long:  "Streams a pre-grouped input once, in order, opening a new output file every time <column> differs from the previous row. No packing is done.",
+	Args:  cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := splitOnChange(args[0], args[1], args[2]); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	splitOnChangeCmd.Flags().BoolVar(&splitOnChangeNameByValue, "name-by-value", false, "name each file after its column value instead of its sequence number")
+}
+
+// unsafeFileChars are replaced when a column value is used as a file name
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+type segment struct {
+	path  string
+	rows  int
+	bytes int64
+}
+
+func splitOnChange(input string, columnSpec string, prefix string) error {
+	logInfo("split on change", "splitting file into segments...")
+	f, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	in, err := inputReader(f, input)
+	if err != nil {
+		return err
+	}
+
+	r := newCSVReader(bufio.NewReader(in))
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("reading header: %w", err)
+	}
+	column, err := resolveColumn(header, columnSpec)
+	if err != nil {
+		return err
+	}
+
+	var out io.WriteCloser
+	var w *csv.Writer
+	var current *segment
+	segments := []*segment{}
+	names := map[string]string{} // file name to the value it was created for
+
+	closeSegment := func() error {
+		if out == nil {
+			return nil
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+		return out.Close()
+	}
+
+	var prev string
+	line := 0
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line+1, err)
+		}
+		line++
+		if column >= len(record) {
+			return fmt.Errorf("line %d has no column %q", line, header[column])
+		}
+
+		value := record[column]
+		if current == nil || value != prev {
+			if err := closeSegment(); err != nil {
+				return err
+			}
+			path := outputPath(prefix, len(segments))
+			if splitOnChangeNameByValue {
+				name := unsafeFileChars.ReplaceAllString(value, "_")
+				if owner, ok := names[name]; ok {
+					if owner == value {
+						return fmt.Errorf("value %q appears in more than one run at line %d, the input is not grouped by %s", value, line, header[column])
+					}
+					return fmt.Errorf("values %q and %q both map to file name %q", owner, value, name)
+				}
+				names[name] = value
+				path = prefix + name + ".csv"
+			}
+
+			file, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+			current = &segment{path: path}
+			segments = append(segments, current)
+			out = file
+			w = newCSVWriter(countingWriter{w: file, n: &current.bytes})
+			w.Write(header)
+			prev = value
+		}
+		w.Write(record)
+		current.rows++
+	}
+	if err := closeSegment(); err != nil {
+		return err
+	}
+
+	if current == nil {
+		logInfo("split on change", "input has no data rows, nothing written")
+		return nil
+	}
+	for _, s := range segments {
+		fmt.Printf("  %s: %s rows, %s\n", s.path, FormatNumber(int64(s.rows)), displaySize(s.bytes, FormatNumber(s.bytes)+" bytes"))
+	}
+	logInfo("split on change", "wrote %s segments from %s rows split on %s", FormatNumber(int64(len(segments))), FormatNumber(int64(line)), header[column])
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var ratioBalanceBy string
+var ratioShuffle bool
+var ratioSeed int64
+
+var splitRatioCmd = &cobra.Command{
+	Use:   "split-ratio <input_csv> <ratios> <output_prefix>",
+	Short: "Split the input into files holding given fractions of the total, e.g. 80,10,10",
+	Args:  cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		input, prefix := args[0], args[2]
+		ratios, err := parseRatios(args[1])
+		if err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if ratioBalanceBy != "size" && ratioBalanceBy != "rows" {
+			logError("", "unknown --balance-by %q, expected size or rows", ratioBalanceBy)
+			os.Exit(1)
+		}
+
+		if ratioShuffle && !cmd.Flags().Changed("seed") {
+			ratioSeed = time.Now().UnixNano()
+		}
+		if ratioShuffle {
+			logInfo("split ratio", "shuffling with seed %d", ratioSeed)
+		}
+
+		metas := scan(cmd.Context(), input, ScanOptions{Format: "csv"})
+		if ratioBalanceBy == "rows" {
+			for i := range metas {
+				metas[i].Size = 1
+			}
+		}
+
+		setOutputCount(len(ratios))
+		buckets, assign, err := pack(cmd.Context(), metas, len(ratios), PackOptions{Weights: ratios, Shuffle: ratioShuffle, Seed: ratioSeed})
+		if err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+
+		total := int64(0)
+		for _, bucket := range buckets {
+			total += bucket.TotalSize
+		}
+		for i, bucket := range buckets {
+			actual := 0.0
+			if total > 0 {
+				actual = float64(bucket.TotalSize) / float64(total)
+			}
+			fmt.Printf("%s: target %.2f%%, actual %.2f%% of %s\n", outputPath(prefix, i), ratios[i]*100, actual*100, ratioBalanceBy)
+		}
+
+		write(cmd.Context(), input, prefix, buckets, assign, WriteOptions{Format: "csv", ChannelBuffer: defaultChannelBuffer})
+		fmt.Printf("Split %s into %d files by ratio %s with prefix %s\n", input, len(ratios), args[1], prefix)
+	},
+}
+
+func init() {
+	splitRatioCmd.Flags().StringVar(&ratioBalanceBy, "balance-by", "size", "apply the ratios to total size or to row counts (size|rows)")
+	splitRatioCmd.Flags().BoolVar(&ratioShuffle, "shuffle", false, "randomize which rows go to which file while keeping the ratios")
+	splitRatioCmd.Flags().Int64Var(&ratioSeed, "seed", 0, "random seed for --shuffle, random unless set")
+}
+
+// parseRatios turns "80,10,10" into weights normalized to sum to 1
+func parseRatios(arg string) ([]float64, error) {
+	parts := strings.Split(arg, ",")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("ratios must list at least two comma separated values, got %q", arg)
+	}
+	ratios := make([]float64, len(parts))
+	sum := 0.0
+	for i, part := range parts {
+		r, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || r <= 0 {
+			return nil, fmt.Errorf("ratio %q must be a positive number", part)
+		}
+		ratios[i] = r
+		sum += r
+	}
+	for i := range ratios {
+		ratios[i] /= sum
+	}
+	return ratios, nil
+}
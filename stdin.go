@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// stdinInput is the input argument that means read from stdin
+const stdinInput = "-"
+
+var spillDir string
+
+// scanStdin scans stdin in a single pass while copying every byte to a spill file, since the write pass needs to read the input a second time and stdin can't be rewound. It returns the metas and the spill file's path, which the caller removes
+func scanStdin(ctx context.Context, opts ScanOptions) ([]LineMeta, string) {
+	return scanStdinTo(ctx, opts, nil)
+}
+
+// scanStdinTo is scanStdin handing each row's meta to emit instead of collecting them, unless emit is nil
+func scanStdinTo(ctx context.Context, opts ScanOptions, emit func(LineMeta)) ([]LineMeta, string) {
+	start := time.Now()
+	logInfo("meta scan", "scanning stdin for line sizes...")
+	if opts.Mmap {
+		logInfo("meta scan", "--mmap can't map stdin, reading it normally")
+	}
+	spill, err := os.CreateTemp(spillDir, "binpacking-stdin-*.csv")
+	if err != nil {
+		logError("", "creating spill file: %v", err)
+		os.Exit(1)
+	}
+	logInfo("meta scan", "spooling stdin to %s for the write pass", spill.Name())
+	atExit(func() { os.Remove(spill.Name()) })
+
+	w := bufio.NewWriter(spill)
+	r, err := newRecordReader(opts.Format, io.TeeReader(os.Stdin, w), opts.Size)
+	if err != nil {
+		logError("", "%v", err)
+		os.Exit(1)
+	}
+	metas := scanRecordsTo(ctx, r, opts, start, emit)
+
+	if err := w.Flush(); err != nil {
+		logError("", "writing spill file: %v", err)
+		os.Exit(1)
+	}
+	if err := spill.Close(); err != nil {
+		logError("", "writing spill file: %v", err)
+		os.Exit(1)
+	}
+	return metas, spill.Name()
+}
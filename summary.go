@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"binpacking/binpack"
+
+	"github.com/spf13/cobra"
+)
+
+// outputMode is --output: text prints the usual logs on stdout, json moves them to stderr and prints one runSummary on stdout at the end
+var outputMode string
+
+// checkOutputMode rejects unknown --output values
+func checkOutputMode(mode string) error {
+	if mode != "text" && mode != "json" {
+		return fmt.Errorf("unknown --output %q, expected text or json", mode)
+	}
+	return nil
+}
+
+// reportOut is where split, inspect and the commands replaying their scan and binpack passes print readable results such as the bucket lines. With --output json or --json it is stderr, so stdout only carries the JSON document
+var reportOut io.Writer = os.Stdout
+
+// summaryStdout points reportOut at cmd's stderr with --output json, and returns the stdout the summary goes to. Nil with --output text
+func summaryStdout(cmd *cobra.Command) io.Writer {
+	reportOut = cmd.OutOrStdout()
+	if outputMode != "json" {
+		return nil
+	}
+	reportOut = cmd.ErrOrStderr()
+	return cmd.OutOrStdout()
+}
+
+// runSummary is what --output json prints for a finished split or inspect
+type runSummary struct {
+	Command string `json:"command"`
+	Input   string `json:"input"`
+	Prefix  string `json:"prefix,omitempty"`
+	// Rows and TotalSize are the data rows and their summed size, across the outputs for split, which includes the rows an --append added to
+	Rows      int   `json:"rows"`
+	TotalSize int64 `json:"total_size"`
+	// ScannedRows is the rows split read from the input and packed, without those the outputs held before an --append. A --resume doesn't scan and leaves it out
+	ScannedRows int             `json:"scanned_rows,omitempty"`
+	ShortRows   int             `json:"short_rows,omitempty"`
+	DryRun      bool            `json:"dry_run,omitempty"`
+	BucketCount int             `json:"bucket_count,omitempty"`
+	Buckets     []summaryBucket `json:"buckets,omitempty"`
+	Balance     *summaryBalance `json:"balance,omitempty"`
+	// Columns is inspect --per-column's report, which sums no single size column and leaves TotalSize at 0
+	Columns []columnStats      `json:"columns,omitempty"`
+	Timing  map[string]float64 `json:"timing_seconds"`
+}
+
+// summaryBucket is one output of a split
+type summaryBucket struct {
+	File  string `json:"file"`
+	Size  int64  `json:"size"`
+	Lines int    `json:"lines"`
+}
+
+// summaryBalance is printBuckets' balance report
+type summaryBalance struct {
+	Min              int64   `json:"min"`
+	Max              int64   `json:"max"`
+	Mean             float64 `json:"mean"`
+	StdDev           float64 `json:"stddev"`
+	LargestAboveMean float64 `json:"largest_above_mean"` // a fraction of the mean, 0.05 for 5%
+	SizeSpread       float64 `json:"size_spread"`
+	CountSpread      float64 `json:"count_spread"`
+	Imbalance        int64   `json:"imbalance"` // largest minus smallest bucket
+}
+
+// splitSummary describes the outputs of a split into buckets at prefix
+func splitSummary(input string, prefix string, buckets []FileBucket, scanned int) *runSummary {
+	s := &runSummary{Command: "split", Input: input, Prefix: prefix, ScannedRows: scanned, BucketCount: len(buckets), Buckets: []summaryBucket{}, Timing: map[string]float64{}}
+	sizes := make([]float64, len(buckets))
+	counts := make([]float64, len(buckets))
+	for i, bucket := range buckets {
+		s.Buckets = append(s.Buckets, summaryBucket{File: outputPath(prefix, i), Size: bucket.TotalSize, Lines: bucket.Lines})
+		s.Rows += bucket.Lines
+		s.TotalSize += bucket.TotalSize
+		sizes[i] = float64(bucket.TotalSize)
+		counts[i] = float64(bucket.Lines)
+	}
+	b := binpack.Summarize(buckets)
+	s.Balance = &summaryBalance{Min: b.Min, Max: b.Max, Mean: b.Mean, StdDev: b.StdDev, LargestAboveMean: b.Imbalance,
+		SizeSpread: MaxDeviation(sizes), CountSpread: MaxDeviation(counts), Imbalance: binpack.Imbalance(buckets)}
+	return s
+}
+
+// time records how long a phase took
+func (s *runSummary) time(phase string, d time.Duration) {
+	s.Timing[phase] = d.Seconds()
+}
+
+// print writes the summary to stdout as indented JSON, once the run got to its end
+func (s *runSummary) print(stdout io.Writer, start time.Time) {
+	s.time("total", time.Since(start))
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		logError("", "%v", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+var targetSize string
+var maxLines int
+
+// capFlag names the flag that set the cap, for messages
+var capFlag = "--target-size"
+
+// largestPackItem is the biggest unit binpack has to place whole: a single row, or a group's aggregate when rows are grouped
+func largestPackItem(metas []LineMeta) int64 {
+	groups := map[int]int64{}
+	var largest int64
+	for _, m := range metas {
+		if m.Group == 0 {
+			largest = max(largest, m.Size)
+			continue
+		}
+		groups[m.Group] += m.Size
+		largest = max(largest, groups[m.Group])
+	}
+	return largest
+}
+
+// bucketsForCap is the fewest buckets that could hold metas without any exceeding sizeCap, the starting point for packToCap
+func bucketsForCap(metas []LineMeta, sizeCap int64) (int, error) {
+	if largest := largestPackItem(metas); largest > sizeCap {
+		return 0, fmt.Errorf("%s %s is smaller than the largest row or group (%s), no split can stay under it", capFlag, displaySize(sizeCap, FormatNumber(sizeCap)), displaySize(largest, FormatNumber(largest)))
+	}
+	var total int64
+	for _, m := range metas {
+		total += m.Size
+	}
+	n := int((total + sizeCap - 1) / sizeCap)
+	if n > maxBuckets {
+		return 0, fmt.Errorf("%s needs at least %d buckets, more than the maximum of %d", capFlag, n, maxBuckets)
+	}
+	return max(n, 1), nil
+}
+
+// packToCap runs binpack with n buckets and adds buckets until none is over sizeCap
+func packToCap(ctx context.Context, metas []LineMeta, n int, sizeCap int64, opts PackOptions) ([]FileBucket, Assignment, error) {
+	for {
+		buckets, assign, err := pack(ctx, metas, n, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		over := 0
+		for _, b := range buckets {
+			if b.TotalSize > sizeCap {
+				over++
+			}
+		}
+		if over == 0 {
+			return buckets, assign, nil
+		}
+		if n >= maxBuckets {
+			return nil, nil, fmt.Errorf("could not fit every bucket under %s with %d buckets", capFlag, n)
+		}
+		logInfo("target size", "%d of %d buckets exceed the cap, retrying with %d buckets", over, n, n+1)
+		n++
+	}
+}
+
+// printCapReport shows how close every bucket came to the size cap
+func printCapReport(buckets []FileBucket, sizeCap int64) {
+	logInfo("target size", "%d buckets under a cap of %s", len(buckets), displaySize(sizeCap, FormatNumber(sizeCap)))
+	for i, b := range buckets {
+		if quietBuckets {
+			break
+		}
+		logInfo("target size", "bucket %d: %s, %.1f%% of cap", i+1, displaySize(b.TotalSize, FormatNumber(b.TotalSize)), float64(b.TotalSize)/float64(sizeCap)*100)
+	}
+}
@@ -2,13 +2,41 @@ package main
 
 import (
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 )
 
-func FormatNumber(n int64) string {
-	s := fmt.Sprintf("%d", n)
+// numberSeparator goes between the digit groups of every number FormatNumber writes, from --number-separator
+var numberSeparator = ","
+
+// numberSeparators are the --number-separator names and what each puts between digit groups
+var numberSeparators = map[string]string{"comma": ",", "space": " ", "underscore": "_", "none": ""}
+
+// checkNumberSeparator sets numberSeparator from a --number-separator name
+func checkNumberSeparator(name string) error {
+	sep, ok := numberSeparators[name]
+	if !ok {
+		return fmt.Errorf("unknown --number-separator %q, expected comma, space, underscore or none", name)
+	}
+	numberSeparator = sep
+	return nil
+}
+
+// FormatNumber writes n with its digits in groups of three, e.g. -1,234,567. sep, when given, goes between the groups instead of the --number-separator
+func FormatNumber(n int64, sep ...string) string {
+	group := numberSeparator
+	if len(sep) > 0 {
+		group = sep[0]
+	}
+	s := strconv.FormatInt(n, 10)
+	sign := ""
+	if n < 0 {
+		// the sign isn't a digit, it must not count towards the first group
+		sign, s = "-", s[1:]
+	}
 	if len(s) <= 3 {
-		return s
+		return sign + s
 	}
 
 	var b strings.Builder
@@ -17,11 +45,82 @@ func FormatNumber(n int64) string {
 		pre = 3
 	}
 
+	b.WriteString(sign)
 	b.WriteString(s[:pre])
 	for i := pre; i < len(s); i += 3 {
-		b.WriteString(",")
+		b.WriteString(group)
 		b.WriteString(s[i : i+3])
 	}
 	return b.String()
 }
 
+// byteUnits are the binary units FormatBytes scales through
+var byteUnits = []string{"B", "KB", "MB", "GB", "TB", "PB", "EB"}
+
+// FormatBytes scales n to the largest binary unit that keeps it at or above 1, e.g. 1536 becomes 1.50KB
+func FormatBytes(n int64) string {
+	sign := ""
+	v := float64(n)
+	if n < 0 {
+		sign = "-"
+		v = -v
+	}
+	unit := 0
+	for v >= 1024 && unit < len(byteUnits)-1 {
+		v /= 1024
+		unit++
+	}
+	// just under the next unit, such as 1MB-1 at 1023.999KB, rounds up to 1024.00 of this one
+	if unit > 0 && unit < len(byteUnits)-1 && math.Round(v*100) >= 1024*100 {
+		v /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%s%.0f%s", sign, v, byteUnits[unit])
+	}
+	return fmt.Sprintf("%s%.2f%s", sign, v, byteUnits[unit])
+}
+
+// ParseBytes reads a size such as 500MB, 2GB, 1.5G or a plain byte count, using the same binary units as FormatBytes
+func ParseBytes(s string) (int64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	num := strings.TrimRight(upper, "KMGTPEIB")
+	unit := strings.TrimSuffix(strings.TrimSuffix(upper[len(num):], "B"), "I")
+	v, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	scale := 1.0
+	if unit != "" {
+		i := strings.Index("KMGTPE", unit)
+		if len(unit) != 1 || i < 0 {
+			return 0, fmt.Errorf("invalid size unit in %q", s)
+		}
+		scale = math.Pow(1024, float64(i+1))
+	}
+	if v*scale >= math.MaxInt64 {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return int64(v * scale), nil
+}
+
+// MaxDeviation returns the largest relative distance of any value from the mean, e.g. 0.05 when the furthest value is 5% off
+func MaxDeviation(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	if mean == 0 {
+		return 0
+	}
+
+	worst := 0.0
+	for _, v := range values {
+		worst = math.Max(worst, math.Abs(v-mean)/mean)
+	}
+	return worst
+}
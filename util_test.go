@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFormatNumber(t *testing.T) {
+	for _, tc := range []struct {
+		n    int64
+		sep  []string
+		want string
+	}{
+		{0, nil, "0"},
+		{7, nil, "7"},
+		{999, nil, "999"},
+		{1000, nil, "1,000"},
+		{123456, nil, "123,456"},
+		{1234567, nil, "1,234,567"},
+		{-7, nil, "-7"},
+		{-999, nil, "-999"},
+		{-1000, nil, "-1,000"},
+		{-100000, nil, "-100,000"},
+		{-1234567, nil, "-1,234,567"},
+		{math.MaxInt64, nil, "9,223,372,036,854,775,807"},
+		{math.MinInt64, nil, "-9,223,372,036,854,775,808"},
+		{1234567, []string{" "}, "1 234 567"},
+		{-1234567, []string{"_"}, "-1_234_567"},
+		{1234567, []string{""}, "1234567"},
+	} {
+		if got := FormatNumber(tc.n, tc.sep...); got != tc.want {
+			t.Errorf("FormatNumber(%d, %q) = %q, want %q", tc.n, tc.sep, got, tc.want)
+		}
+	}
+}
+
+func TestFormatNumberUsesNumberSeparator(t *testing.T) {
+	defer checkNumberSeparator("comma")
+	for name, want := range map[string]string{"comma": "-12,345", "space": "-12 345", "underscore": "-12_345", "none": "-12345"} {
+		if err := checkNumberSeparator(name); err != nil {
+			t.Fatal(err)
+		}
+		if got := FormatNumber(-12345); got != want {
+			t.Errorf("with --number-separator %s, FormatNumber(-12345) = %q, want %q", name, got, want)
+		}
+	}
+	if err := checkNumberSeparator("dot"); err == nil {
+		t.Error("checkNumberSeparator accepted an unknown separator")
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for _, tc := range []struct {
+		n    int64
+		want string
+	}{
+		{0, "0B"},
+		{512, "512B"},
+		{1536, "1.50KB"},
+		{500 << 20, "500.00MB"},
+		{3 << 30, "3.00GB"},
+		{-1536, "-1.50KB"},
+	} {
+		if got := FormatBytes(tc.n); got != tc.want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want int64
+	}{
+		{"0", 0},
+		{"1024", 1024},
+		{"100B", 100},
+		{"1K", 1 << 10},
+		{"1KiB", 1 << 10},
+		{"500MB", 500 << 20},
+		{"500mb", 500 << 20},
+		{"1.5G", 3 << 29},
+		{"2GB", 2 << 30},
+		{" 10 KB ", 10 << 10},
+		{"1TB", 1 << 40},
+	} {
+		got, err := ParseBytes(tc.s)
+		if err != nil {
+			t.Errorf("ParseBytes(%q): %v", tc.s, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseBytes(%q) = %d, want %d", tc.s, got, tc.want)
+		}
+	}
+	for _, s := range []string{"", "abc", "-1MB", "10XB", "10MM", "9EB"} {
+		if got, err := ParseBytes(s); err == nil {
+			t.Errorf("ParseBytes(%q) = %d, want an error", s, got)
+		}
+	}
+}
+
+func TestParseBytesReadsFormatBytes(t *testing.T) {
+	for _, n := range []int64{0, 1023, 1 << 10, 3 << 20, 5 << 30} {
+		got, err := ParseBytes(FormatBytes(n))
+		if err != nil {
+			t.Errorf("ParseBytes(%q): %v", FormatBytes(n), err)
+			continue
+		}
+		if got != n {
+			t.Errorf("ParseBytes(FormatBytes(%d)) = %d", n, got)
+		}
+	}
+}
+
+func TestFormatBytesUnitBoundaries(t *testing.T) {
+	for _, tc := range []struct {
+		n    int64
+		want string
+	}{
+		{1, "1B"},
+		{1023, "1023B"},
+		{1024, "1.00KB"},
+		{1025, "1.00KB"},
+		{1<<20 - 1, "1.00MB"},
+		{1 << 20, "1.00MB"},
+		{1<<30 - 1, "1.00GB"},
+		{1 << 30, "1.00GB"},
+		{1<<40 - 1, "1.00TB"},
+		{1 << 40, "1.00TB"},
+		{1 << 50, "1.00PB"},
+		{1 << 60, "1.00EB"},
+		{math.MaxInt64, "8.00EB"},
+		{-1023, "-1023B"},
+		{-(1 << 20), "-1.00MB"},
+	} {
+		if got := FormatBytes(tc.n); got != tc.want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", tc.n, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+var verifyMaxMismatches int
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <input_csv> <output_prefix>",
+	Short: "Check that a split is complete and lossless",
+	Long:  "Re-reads the input and every <output_prefix>N.csv (or .jsonl) file and checks that each input data row appears in exactly one output, that every output has the input's header, and that each output's summed size matches what <output_prefix>manifest.json reports.",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		scanOpts.Size, err = sizeSpecFromFlags(scanOpts.Size, sizeMode, sizeSource, splitBy)
+		if err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if err := checkBadSizePolicy(scanOpts.OnBadSize); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if err := checkFormatOptions(scanOpts); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if err := checkHeaderRows(scanOpts); err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if len(filterExprs) > 0 {
+			if err := checkFilter(); err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+			if scanOpts.Filter, err = parseFilters(filterExprs); err != nil {
+				logError("", "%v", err)
+				os.Exit(1)
+			}
+		}
+		mismatches, err := verify(args[0], args[1], scanOpts)
+		if err != nil {
+			logError("", "%v", err)
+			os.Exit(1)
+		}
+		if mismatches > 0 {
+			logError("", "%s mismatches found", FormatNumber(int64(mismatches)))
+			os.Exit(1)
+		}
+		fmt.Println("Split OK")
+	},
+}
+
+func init() {
+	verifyCmd.Flags().IntVar(&verifyMaxMismatches, "max-mismatches", 10, "print at most this many mismatches")
+	verifyCmd.Flags().StringVarP(&scanOpts.Size.Column, "size-column", "c", "", "name or zero-based index of the column holding each row's size (default 2)")
+	verifyCmd.Flags().StringVar(&scanOpts.Size.Expr, "size-expr", "", "the --size-expr the split ran with")
+	verifyCmd.Flags().StringVar(&scanOpts.Size.Field, "size-field", "", "dotted path to each object's size with --format jsonl, e.g. meta.bytes")
+	verifyCmd.Flags().StringVar(&scanOpts.Format, "format", "csv", "input format, one of the registered record readers")
+	verifyCmd.Flags().StringVar(&sizeMode, "size-mode", "absolute", "absolute reads the size column as bytes, relative as decimal weights such as percentages")
+	verifyCmd.Flags().StringVar(&scanOpts.OnBadSize, "on-bad-size", badSizeSkip, "the --on-bad-size the split ran with, zero expects rows without a usable size in the outputs")
+	verifyCmd.Flags().StringVar(&sizeSource, "size", "column", "column reads each row's size from the size column, bytes measures the row as written to the output")
+	verifyCmd.Flags().StringArrayVar(&filterExprs, "filter", nil, "the --filter expressions the split ran with, whose left-out rows aren't expected in any output")
+	verifyCmd.Flags().BoolVar(&lenientFields, "lenient", false, "the split ran with --lenient, whose outputs hold its rows padded or truncated to the header's field count")
+	verifyCmd.Flags().BoolVar(&scanOpts.SkipEmptyRows, "skip-empty-rows", false, "the split ran with --skip-empty-rows, whose empty rows aren't expected in any output")
+	verifyCmd.Flags().IntVar(&headerRows, "header-rows", 1, "the --header-rows the split ran with")
+	verifyCmd.Flags().BoolVar(&noHeader, "no-header", false, "the split ran with --no-header")
+	verifyCmd.Flags().StringVar(&splitBy, "by", "size", "the --by the split ran with, lines checks row counts instead of sizes")
+}
+
+// verifyRows remembers which input lines hold each distinct row, so every output row can claim one of them
+type verifyRows struct {
+	pending map[uint64][]int // input lines not yet found in an output, keyed by row hash
+	claimed map[uint64]int   // first input line of each row already found, to name duplicates
+}
+
+// readRecords reads every record of path with the scan's record reader and size column, calling fn with the 1-based data row number of each
+func readRecords(path string, format string, size SizeSpec, filter *rowFilter, fn func(line int, record []string, size int64, err error)) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	in, err := inputReader(f, path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newRecordReader(format, bufio.NewReader(in), size)
+	if err != nil {
+		return nil, err
+	}
+
+	var header [][]string
+	for range headerCount(format) {
+		record, _, err := r.Read()
+		if err != nil && !errors.Is(err, ErrBadSize) {
+			return nil, fmt.Errorf("%s: reading header: %w", path, err)
+		}
+		header = append(header, record)
+	}
+	if filter != nil {
+		var names []string
+		if len(header) > 0 {
+			names = header[0]
+		}
+		if err := filter.resolve(names); err != nil {
+			return nil, err
+		}
+	}
+	for line := 1; ; line++ {
+		record, size, err := r.Read()
+		if err == io.EOF {
+			return header, nil
+		}
+		if err != nil && !errors.Is(err, ErrBadSize) {
+			return nil, fmt.Errorf("%s: reading data row %d: %w", path, line, err)
+		}
+		if filter != nil && !filter.match(record) {
+			err = errFiltered
+		}
+		fn(line, record, size, err)
+	}
+}
+
+// readManifest loads <prefix>manifest.json keyed by bucket number, or nil if the split didn't leave one
+func readManifest(prefix string) (map[int]ManifestFile, error) {
+	manifest, err := loadManifest(prefix)
+	if manifest == nil || err != nil {
+		return nil, err
+	}
+	return manifest.byBucket(), nil
+}
+
+// byBucket keys the manifest's files by bucket number
+func (m *manifestDoc) byBucket() map[int]ManifestFile {
+	files := map[int]ManifestFile{}
+	for _, file := range m.Files {
+		files[file.Bucket] = file
+	}
+	return files
+}
+
+// loadManifest reads <prefix>manifest.json as written, or nil if the split didn't leave one
+func loadManifest(prefix string) (*manifestDoc, error) {
+	data, err := os.ReadFile(prefix + manifestName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var manifest manifestDoc
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("%s%s: %w", prefix, manifestName, err)
+	}
+	return &manifest, nil
+}
+
+// verify compares a split's outputs against its input and returns how many mismatches it found
+func verify(input string, prefix string, opts ScanOptions) (int, error) {
+	if isS3Prefix(prefix) {
+		return 0, fmt.Errorf("verify only supports local output paths")
+	}
+	paths, err := discoverBucketFiles(prefix)
+	if err != nil {
+		return 0, err
+	}
+	manifest, err := readManifest(prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	logInfo("verify", "reading input rows...")
+	rows := verifyRows{pending: map[uint64][]int{}, claimed: map[uint64]int{}}
+	inputRows, unsized, filtered, empty := 0, 0, 0, 0
+	header, err := readRecords(input, opts.Format, opts.Size, opts.Filter, func(line int, record []string, _ int64, err error) {
+		if opts.SkipEmptyRows && isEmptyRow(record) {
+			empty++
+			return
+		}
+		if err == errFiltered {
+			filtered++
+			return
+		}
+		// split leaves rows without a usable size out of every output, unless it packed them as size 0
+		if err != nil && opts.OnBadSize != badSizeZero {
+			unsized++
+			return
+		}
+		h := rowHash(record)
+		rows.pending[h] = append(rows.pending[h], line)
+		inputRows++
+	})
+	if err != nil {
+		return 0, err
+	}
+	logInfo("verify", "%s input rows", FormatNumber(int64(inputRows)))
+	if unsized > 0 {
+		logInfo("verify", "%s input rows have no usable size and are not expected in any output", FormatNumber(int64(unsized)))
+	}
+	if opts.Filter != nil {
+		logInfo("verify", "%s input rows are filtered out and not expected in any output", FormatNumber(int64(filtered)))
+	}
+	if opts.SkipEmptyRows {
+		logInfo("verify", "%s input rows are empty and not expected in any output", FormatNumber(int64(empty)))
+	}
+
+	mismatches := 0
+	report := func(kind string, format string, args ...any) {
+		mismatches++
+		if mismatches <= verifyMaxMismatches {
+			logWarn("verify", "%s: "+format, append([]any{kind}, args...)...)
+		}
+	}
+
+	outputRows := 0
+	for _, path := range paths {
+		var size int64
+		n := 0
+		h, err := readRecords(path, outputFormat(opts.Format), opts.Size, nil, func(line int, record []string, rowSize int64, err error) {
+			n++
+			if err == nil {
+				size += rowSize
+			}
+			hash := rowHash(record)
+			if lines := rows.pending[hash]; len(lines) > 0 {
+				rows.pending[hash] = lines[1:]
+				if _, ok := rows.claimed[hash]; !ok {
+					rows.claimed[hash] = lines[0]
+				}
+				return
+			}
+			if first, ok := rows.claimed[hash]; ok {
+				report("duplicate", "%s row %d repeats input line %d", path, line, first)
+			} else {
+				report("extra", "%s row %d is not an input row", path, line)
+			}
+		})
+		if err != nil {
+			return mismatches, err
+		}
+		if !slices.EqualFunc(h, header, slices.Equal) {
+			report("header", "%s has header %v, input has %v", path, h, header)
+		}
+		outputRows += n
+
+		if manifest == nil {
+			continue
+		}
+		bucket, _ := strconv.Atoi(bucketFilePattern(prefix).FindStringSubmatch(path)[1])
+		entry, ok := manifest[bucket]
+		if !ok {
+			report("size", "%s is not in the manifest", path)
+			continue
+		}
+		delete(manifest, bucket)
+		if entry.TotalSize != size {
+			report("size", "%s sums to %d, manifest reports %d", path, size, entry.TotalSize)
+		}
+		if entry.Lines != n {
+			report("size", "%s has %s rows, manifest reports %s", path, FormatNumber(int64(n)), FormatNumber(int64(entry.Lines)))
+		}
+		if entry.Checksum != "" {
+			sum, err := fileChecksum(path, entry.Checksum)
+			if err != nil {
+				return mismatches, err
+			}
+			if sum != entry.Checksum {
+				report("checksum", "%s has checksum %s, manifest reports %s", path, sum, entry.Checksum)
+			}
+		}
+	}
+	// rows a --max-bucket-size split had no room for are in <prefix>oversized.csv instead of an output
+	if _, err := os.Stat(prefix + oversizedName); err == nil {
+		oversized := 0
+		_, err := readRecords(prefix+oversizedName, outputFormat(opts.Format), opts.Size, nil, func(line int, record []string, _ int64, _ error) {
+			hash := rowHash(record)
+			if lines := rows.pending[hash]; len(lines) > 0 {
+				rows.pending[hash] = lines[1:]
+				oversized++
+				return
+			}
+			report("extra", "%s%s row %d is not an input row, or is in an output too", prefix, oversizedName, line)
+		})
+		if err != nil {
+			return mismatches, err
+		}
+		logInfo("verify", "%s rows in %s%s that no bucket had room for", FormatNumber(int64(oversized)), prefix, oversizedName)
+	}
+	unmatched := []int{}
+	for bucket := range manifest {
+		unmatched = append(unmatched, bucket)
+	}
+	sort.Ints(unmatched)
+	for _, bucket := range unmatched {
+		report("size", "%s is in the manifest but missing", manifest[bucket].File)
+	}
+	if manifest == nil {
+		logWarn("verify", "no %s%s, bucket sizes not checked", prefix, manifestName)
+	}
+
+	missing := []int{}
+	for _, lines := range rows.pending {
+		missing = append(missing, lines...)
+	}
+	sort.Ints(missing)
+	for _, line := range missing {
+		report("missing", "input line %d is in no output", line)
+	}
+
+	logInfo("verify", "%s rows in %d output files, %s input rows missing", FormatNumber(int64(outputRows)), len(paths), FormatNumber(int64(len(missing))))
+	if mismatches > verifyMaxMismatches {
+		logWarn("verify", "%s more mismatches not shown", FormatNumber(int64(mismatches-verifyMaxMismatches)))
+	}
+	return mismatches, nil
+}